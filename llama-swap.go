@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/mostlygeek/llama-swap/proxy"
@@ -15,11 +17,33 @@ var version string = "0"
 var commit string = "abcd1234"
 var date = "unknown"
 
+// listenFlags collects -listen, which can be used more than once
+type listenFlags []string
+
+func (l *listenFlags) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listenFlags) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
 func main() {
+	// llama-swap replay [flags] <requests.jsonl>
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		os.Exit(runReplay(os.Args[2:]))
+	}
+
 	// Define a command-line flag for the port
 	configPath := flag.String("config", "config.yaml", "config file name")
-	listenStr := flag.String("listen", ":8080", "listen ip/port")
+	var listenAddrs listenFlags
+	flag.Var(&listenAddrs, "listen", "listen ip/port, http://ip:port, https://ip:port or unix:///path.sock, can be repeated (default \":8080\")")
+	tlsCert := flag.String("tls-cert", "", "TLS certificate file, reloaded on SIGHUP")
+	tlsKey := flag.String("tls-key", "", "TLS key file, reloaded on SIGHUP")
 	showVersion := flag.Bool("version", false, "show version of build")
+	watchConfig := flag.Bool("watch-config", true, "reload config file when it changes")
+	drainTimeout := flag.Duration("drain-timeout", 30*time.Second, "time to wait for in-flight requests to finish when shutting down")
 
 	flag.Parse() // Parse the command-line flags
 
@@ -44,20 +68,84 @@ func main() {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
+	addrs := []string(listenAddrs)
+	if len(addrs) == 0 {
+		addrs = config.Listen
+	}
+	if len(addrs) == 0 {
+		addrs = []string{":8080"}
+	}
+
+	certFile, keyFile := *tlsCert, *tlsKey
+	if certFile == "" && keyFile == "" {
+		certFile, keyFile = config.TLSCert, config.TLSKey
+	}
+	if (certFile == "") != (keyFile == "") {
+		fmt.Println("Error: both a TLS certificate and key are required")
+		os.Exit(1)
+	}
+
+	var listeners []proxy.Listener
+	for _, addr := range addrs {
+		listener, err := proxy.ParseListener(addr, certFile != "")
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		listeners = append(listeners, listener)
+	}
+
 	proxyManager := proxy.New(config)
 
-	sigChan := make(chan os.Signal, 1)
+	if *watchConfig {
+		proxyManager.WatchConfigFile(*configPath, 2*time.Second)
+	}
+
+	// reload the config on SIGHUP
+	reloadChan := make(chan os.Signal, 1)
+	signal.Notify(reloadChan, syscall.SIGHUP)
+	go func() {
+		for range reloadChan {
+			fmt.Println("Received SIGHUP, reloading config")
+			proxyManager.ReloadConfigFile(*configPath)
+			proxyManager.ReloadCertificates()
+		}
+	}()
+
+	sigChan := make(chan os.Signal, 2)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+
+	fmt.Println("llama-swap listening on " + strings.Join(addrs, ", "))
+	serveErr := make(chan error, 1)
 	go func() {
-		<-sigChan
-		fmt.Println("Shutting down llama-swap")
+		serveErr <- proxyManager.Serve(listeners, certFile, keyFile)
+	}()
+
+	select {
+	case err := <-serveErr:
 		proxyManager.Shutdown()
+		if err != nil {
+			fmt.Printf("Server error: %v\n", err)
+			os.Exit(1)
+		}
 		os.Exit(0)
+	case <-sigChan:
+	}
+
+	fmt.Printf("Shutting down llama-swap, waiting up to %v for requests to finish. Send the signal again to stop without waiting\n", *drainTimeout)
+	done := make(chan struct{})
+	go func() {
+		proxyManager.GracefulShutdown(*drainTimeout)
+		close(done)
 	}()
 
-	fmt.Println("llama-swap listening on " + *listenStr)
-	if err := proxyManager.Run(*listenStr); err != nil {
-		fmt.Printf("Server error: %v\n", err)
+	select {
+	case <-done:
+		os.Exit(0)
+	case <-sigChan:
+		// don't leave the upstream processes running without llama-swap
+		fmt.Println("Received second signal, killing upstream processes and exiting")
+		proxyManager.Kill()
 		os.Exit(1)
 	}
 }
@@ -0,0 +1,233 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ginKeyAPIKey holds the *APIKeyConfig of an authenticated request
+const ginKeyAPIKey = "llama-swap.apiKey"
+
+// AuthConfig lists the API keys allowed to use llama-swap. Authentication is
+// disabled when there are no keys.
+type AuthConfig struct {
+	Keys []APIKeyConfig `yaml:"keys"`
+}
+
+func (c *AuthConfig) enabled() bool {
+	return len(c.Keys) > 0
+}
+
+// APIKeyConfig is an API key and what it may access. The key is set with
+// exactly one of key, keyFile or keyEnv.
+type APIKeyConfig struct {
+	// used in logs, defaults to the end of the key. Names must be unique.
+	Name string `yaml:"name"`
+
+	Key     string `yaml:"key"`
+	KeyFile string `yaml:"keyFile"`
+	KeyEnv  string `yaml:"keyEnv"`
+
+	// models or aliases the key may use, all models when empty
+	Models []string `yaml:"models"`
+
+	// allows /unload, /logs, /upstream and loading and unloading models
+	Admin bool `yaml:"admin"`
+
+	// replaces the top level rateLimit for requests using this key
+	RateLimit *RateLimitConfig `yaml:"rateLimit"`
+
+	// real model IDs resolved from Models
+	allowedModels map[string]bool
+
+	// identifies the key for rate limits and response ownership. It is a
+	// hash of the key so it stays the same when the config is reloaded.
+	id string
+}
+
+// AllowsModel returns true if the key may use the real model ID
+func (k *APIKeyConfig) AllowsModel(modelID string) bool {
+	return k.allowedModels == nil || k.allowedModels[modelID]
+}
+
+// resolveAPIKeys loads keys from files and environment variables and
+// resolves model allowlists to real model IDs. Aliases must already be set.
+func resolveAPIKeys(config *Config) error {
+	seen := make(map[string]bool)
+	names := make(map[string]bool)
+	for i := range config.Auth.Keys {
+		k := &config.Auth.Keys[i]
+
+		sources := 0
+		for _, source := range []string{k.Key, k.KeyFile, k.KeyEnv} {
+			if source != "" {
+				sources++
+			}
+		}
+		if sources != 1 {
+			return fmt.Errorf("auth key %d: set exactly one of key, keyFile or keyEnv", i+1)
+		}
+
+		switch {
+		case k.KeyFile != "":
+			data, err := os.ReadFile(k.KeyFile)
+			if err != nil {
+				return fmt.Errorf("auth key %d: %v", i+1, err)
+			}
+			k.Key = strings.TrimSpace(string(data))
+		case k.KeyEnv != "":
+			k.Key = strings.TrimSpace(os.Getenv(k.KeyEnv))
+		}
+
+		if k.Key == "" {
+			return fmt.Errorf("auth key %d: key is empty", i+1)
+		}
+		if seen[k.Key] {
+			return fmt.Errorf("auth key %d: duplicate key", i+1)
+		}
+		seen[k.Key] = true
+
+		sum := sha256.Sum256([]byte(k.Key))
+		k.id = hex.EncodeToString(sum[:])
+
+		if k.Name == "" {
+			k.Name = maskKey(k.Key, i)
+		}
+		if names[k.Name] {
+			return fmt.Errorf("auth key %d: name %s is already used, set a unique name", i+1, k.Name)
+		}
+		names[k.Name] = true
+
+		if len(k.Models) > 0 {
+			k.allowedModels = make(map[string]bool)
+			for _, name := range k.Models {
+				modelID, found := config.RealModelName(name)
+				if !found {
+					return fmt.Errorf("auth key %s: model %s is not defined in models", k.Name, name)
+				}
+				k.allowedModels[modelID] = true
+			}
+		}
+	}
+
+	return nil
+}
+
+// maskKey returns a name for a key that does not reveal it. Short keys are
+// named by their position in the config.
+func maskKey(key string, index int) string {
+	if len(key) <= 8 {
+		return fmt.Sprintf("key %d", index+1)
+	}
+	return "..." + key[len(key)-4:]
+}
+
+// findAPIKey returns the key matching the bearer token
+func (c *AuthConfig) findAPIKey(token string) *APIKeyConfig {
+	var found *APIKeyConfig
+	for i := range c.Keys {
+		// compare every key so the time taken does not reveal a partial match
+		if subtle.ConstantTimeCompare([]byte(c.Keys[i].Key), []byte(token)) == 1 {
+			found = &c.Keys[i]
+		}
+	}
+	return found
+}
+
+// publicPaths are served without a key so the UI can be loaded
+var publicPaths = map[string]bool{
+	"/":            true,
+	"/favicon.ico": true,
+}
+
+// isAdminPath returns true for endpoints that affect all users or expose
+// their data
+func isAdminPath(method, path string) bool {
+	switch {
+	case path == "/unload",
+		path == "/running",
+		path == "/api/usage",
+		path == "/metrics",
+		path == "/logs",
+		strings.HasPrefix(path, "/logs/"),
+		path == "/upstream",
+		strings.HasPrefix(path, "/upstream/"):
+		return true
+	case method == http.MethodPost && strings.HasPrefix(path, "/api/models/"):
+		return true
+	default:
+		return false
+	}
+}
+
+// authMiddleware checks the Authorization: Bearer header, or x-api-key, when
+// keys are configured
+func (pm *ProxyManager) authMiddleware(c *gin.Context) {
+	config := pm.currentConfig()
+	if !config.Auth.enabled() || publicPaths[c.Request.URL.Path] {
+		c.Next()
+		return
+	}
+
+	token, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !found {
+		// Anthropic API clients send the key in x-api-key
+		token = c.GetHeader("x-api-key")
+		found = token != ""
+	}
+	apiKey := config.Auth.findAPIKey(strings.TrimSpace(token))
+	if !found || apiKey == nil {
+		c.Header("WWW-Authenticate", "Bearer")
+		pm.abortWithAuthError(c, http.StatusUnauthorized, "invalid or missing API key")
+		return
+	}
+
+	if !apiKey.Admin && isAdminPath(c.Request.Method, c.Request.URL.Path) {
+		pm.abortWithAuthError(c, http.StatusForbidden, "API key is not allowed to use this endpoint")
+		return
+	}
+
+	c.Set(ginKeyAPIKey, apiKey)
+	c.Next()
+}
+
+// abortWithAuthError sends an error in the format of the request's API and
+// aborts the request
+func (pm *ProxyManager) abortWithAuthError(c *gin.Context, statusCode int, message string) {
+	if !translateRouteError(c, statusCode, gin.H{"error": message}) {
+		pm.sendErrorResponse(c, statusCode, message)
+	}
+	c.Abort()
+}
+
+// requestAPIKey returns the API key used for the request, nil when
+// authentication is disabled
+func requestAPIKey(c *gin.Context) *APIKeyConfig {
+	if value, ok := c.Get(ginKeyAPIKey); ok {
+		return value.(*APIKeyConfig)
+	}
+	return nil
+}
+
+// checkModelAccess sends a 403 and returns false if the request's API key
+// may not use the requested model
+func (pm *ProxyManager) checkModelAccess(c *gin.Context, requestedModel string) bool {
+	apiKey := requestAPIKey(c)
+	if apiKey == nil {
+		return true
+	}
+
+	config := pm.currentConfig()
+	if modelID, found := config.RealModelName(requestedModel); found && !apiKey.AllowsModel(modelID) {
+		pm.sendErrorResponse(c, http.StatusForbidden, fmt.Sprintf("API key is not allowed to use model %s", requestedModel))
+		return false
+	}
+	return true
+}
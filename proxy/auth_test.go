@@ -0,0 +1,187 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func TestAuth_LoadKeys(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key.txt")
+	assert.NoError(t, os.WriteFile(keyFile, []byte("sk-from-file\n"), 0600))
+	t.Setenv("LLAMA_SWAP_TEST_KEY", "sk-from-env")
+
+	tempFile := filepath.Join(dir, "config.yaml")
+	content := `
+auth:
+  keys:
+    - key: sk-inline-12345
+      models: [m1-alias]
+    - name: team-b
+      keyFile: ` + keyFile + `
+      admin: true
+    - keyEnv: LLAMA_SWAP_TEST_KEY
+    - key: short
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+    aliases: [m1-alias]
+  model2:
+    cmd: path/to/cmd --port ${PORT}
+`
+	assert.NoError(t, os.WriteFile(tempFile, []byte(content), 0644))
+
+	config, err := LoadConfig(tempFile)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	keys := config.Auth.Keys
+	if !assert.Len(t, keys, 4) {
+		return
+	}
+	assert.Equal(t, "...2345", keys[0].Name)
+	assert.True(t, keys[0].AllowsModel("model1"))
+	assert.False(t, keys[0].AllowsModel("model2"))
+	assert.Equal(t, "sk-from-file", keys[1].Key)
+	assert.True(t, keys[1].Admin)
+	assert.True(t, keys[1].AllowsModel("model2"))
+	assert.Equal(t, "sk-from-env", keys[2].Key)
+	assert.Equal(t, "key 4", keys[3].Name)
+
+	// every key has its own id, even when names look alike
+	ids := make(map[string]bool)
+	for _, k := range keys {
+		assert.NotEmpty(t, k.id)
+		assert.NotContains(t, k.id, k.Key)
+		ids[k.id] = true
+	}
+	assert.Len(t, ids, 4)
+}
+
+func TestAuth_LoadKeysErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		keys    string
+		wantErr string
+	}{
+		{"no source", "- name: a", "auth key 1: set exactly one of key, keyFile or keyEnv"},
+		{"two sources", "- key: a\n    keyEnv: B", "auth key 1: set exactly one of key, keyFile or keyEnv"},
+		{"unset env", "- keyEnv: LLAMA_SWAP_TEST_UNSET_KEY", "auth key 1: key is empty"},
+		{"duplicate", "- key: sk-1\n  - key: sk-1", "auth key 2: duplicate key"},
+		{"duplicate name", "- key: sk-1\n    name: a\n  - key: sk-2\n    name: a", "auth key 2: name a is already used"},
+		{"duplicate generated name", "- key: sk-aaaa-1234\n  - key: sk-bbbb-1234", "auth key 2: name ...1234 is already used"},
+		{"unknown model", "- key: sk-1\n    name: a\n    models: [nope]", "auth key a: model nope is not defined in models"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tempFile := filepath.Join(t.TempDir(), "config.yaml")
+			content := "auth:\n  keys:\n  " + tt.keys + "\nmodels:\n  model1:\n    cmd: path/to/cmd --port ${PORT}\n"
+			assert.NoError(t, os.WriteFile(tempFile, []byte(content), 0644))
+
+			_, err := LoadConfig(tempFile)
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestAuth_Middleware(t *testing.T) {
+	config := Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]ModelConfig{
+			"model1": getTestSimpleResponderConfig("model1"),
+			"model2": getTestSimpleResponderConfig("model2"),
+		},
+		LogLevel: "error",
+		Auth: AuthConfig{
+			Keys: []APIKeyConfig{
+				{Key: "sk-user", Models: []string{"model1"}},
+				{Key: "sk-admin", Admin: true},
+			},
+		},
+	}
+	assert.NoError(t, resolveAPIKeys(&config))
+	config = AddDefaultGroupToConfig(config)
+
+	proxy := New(config)
+	defer proxy.StopProcesses()
+
+	do := func(method, path, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		w := httptest.NewRecorder()
+		proxy.HandlerFunc(w, req)
+		return w
+	}
+
+	// the UI is public
+	assert.Equal(t, http.StatusOK, do("GET", "/", "", "").Code)
+
+	w := do("GET", "/v1/models", "", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "Bearer", w.Header().Get("WWW-Authenticate"))
+	assert.Equal(t, http.StatusUnauthorized, do("GET", "/v1/models", "sk-wrong", "").Code)
+
+	// models are filtered by the key's allowlist
+	w = do("GET", "/v1/models", "sk-user", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"model1"}, modelIDs(w.Body.String()))
+
+	w = do("GET", "/v1/models", "sk-admin", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.ElementsMatch(t, []string{"model1", "model2"}, modelIDs(w.Body.String()))
+
+	assert.Equal(t, http.StatusOK, do("POST", "/v1/chat/completions", "sk-user", `{"model":"model1"}`).Code)
+	assert.Equal(t, http.StatusForbidden, do("POST", "/v1/chat/completions", "sk-user", `{"model":"model2"}`).Code)
+	assert.Equal(t, StateStopped, proxy.processGroups[DEFAULT_GROUP_ID].processes["model2"].CurrentState())
+
+	// Anthropic API clients send x-api-key
+	req := httptest.NewRequest("GET", "/v1/models", nil)
+	req.Header.Set("x-api-key", "sk-user")
+	w = httptest.NewRecorder()
+	proxy.HandlerFunc(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"model1"}, modelIDs(w.Body.String()))
+
+	// status of models the key may not use is hidden
+	assert.Equal(t, http.StatusOK, do("GET", "/api/models/model1", "sk-user", "").Code)
+	assert.Equal(t, http.StatusNotFound, do("GET", "/api/models/model2", "sk-user", "").Code)
+	assert.Equal(t, http.StatusOK, do("GET", "/api/models/model2", "sk-admin", "").Code)
+
+	assert.Equal(t, http.StatusOK, do("POST", "/v1/chat/completions", "sk-admin", `{"model":"model2"}`).Code)
+	w = do("GET", "/api/ps", "sk-user", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, gjson.Get(w.Body.String(), "models").Array())
+	w = do("GET", "/api/ps", "sk-admin", "")
+	assert.Equal(t, "model2", gjson.Get(w.Body.String(), "models.0.name").String())
+
+	// admin endpoints
+	assert.Equal(t, http.StatusForbidden, do("GET", "/unload", "sk-user", "").Code)
+	assert.Equal(t, http.StatusForbidden, do("GET", "/logs", "sk-user", "").Code)
+	assert.Equal(t, http.StatusForbidden, do("GET", "/upstream/model1/test", "sk-user", "").Code)
+	assert.Equal(t, http.StatusForbidden, do("POST", "/api/models/model1/unload", "sk-user", "").Code)
+	assert.Equal(t, http.StatusForbidden, do("GET", "/running", "sk-user", "").Code)
+	assert.Equal(t, http.StatusForbidden, do("GET", "/api/usage", "sk-user", "").Code)
+	assert.Equal(t, http.StatusForbidden, do("GET", "/metrics", "sk-user", "").Code)
+	assert.Equal(t, http.StatusOK, do("GET", "/metrics", "sk-admin", "").Code)
+	assert.Equal(t, http.StatusOK, do("GET", "/running", "sk-admin", "").Code)
+	assert.Equal(t, http.StatusOK, do("GET", "/unload", "sk-admin", "").Code)
+}
+
+func modelIDs(body string) []string {
+	var ids []string
+	for _, id := range gjson.Get(body, "data.#.id").Array() {
+		ids = append(ids, id.String())
+	}
+	return ids
+}
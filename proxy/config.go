@@ -2,9 +2,13 @@ package proxy
 
 import (
 	"fmt"
+	"net/url"
 	"os"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/shlex"
 	"gopkg.in/yaml.v3"
@@ -12,6 +16,20 @@ import (
 
 const DEFAULT_GROUP_ID = "(default)"
 
+// DEFAULT_START_PORT is the first port assigned to models using the ${PORT} macro
+const DEFAULT_START_PORT = 5800
+
+// PORT_MACRO is replaced with an automatically assigned port in cmd and proxy
+const PORT_MACRO = "${PORT}"
+
+// ENV_MACRO_PREFIX marks a macro, ${env.NAME}, that expands to an environment variable
+const ENV_MACRO_PREFIX = "env."
+
+var (
+	macroPattern     = regexp.MustCompile(`\$\{([^}]*)\}`)
+	macroNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+)
+
 type ModelConfig struct {
 	Cmd           string   `yaml:"cmd"`
 	Proxy         string   `yaml:"proxy"`
@@ -23,17 +41,123 @@ type ModelConfig struct {
 	UseModelName  string   `yaml:"useModelName"`
 	MessagePrefix string   `yaml:"message_prefix"`
 	CachePrompt   *bool    `yaml:"cache_prompt"` // Use pointer to differentiate between unset and false
+
+	// what to do when the upstream crashes after becoming ready
+	Restart RestartConfig `yaml:"restart"`
+
+	// number of times to try starting the upstream before it is marked as failed
+	StartAttempts int `yaml:"startAttempts"`
+
+	// seconds before a failed model can be started again, 0 requires a manual reset
+	FailureCooldown int `yaml:"failureCooldown"`
+
+	// maximum number of requests sent to the upstream at once, 0 is unlimited
+	Concurrency int `yaml:"concurrency"`
+
+	// maximum number of requests waiting when concurrency is reached
+	QueueSize int `yaml:"queueSize"`
+
+	// seconds a request waits in the queue before it is rejected
+	QueueTimeout int `yaml:"queueTimeout"`
+
+	// resources used when the model is loaded, e.g. {vram: 20}. Counted
+	// against Config.Resources
+	Resources map[string]float64 `yaml:"resources"`
+
+	// start the model when llama-swap starts
+	Preload bool `yaml:"preload"`
 }
 
 func (m *ModelConfig) SanitizedCommand() ([]string, error) {
 	return SanitizeCommand(m.Cmd)
 }
 
+func (m *ModelConfig) startAttempts() int {
+	if m.StartAttempts < 1 {
+		return 1
+	}
+	return m.StartAttempts
+}
+
+const (
+	DEFAULT_QUEUE_SIZE    = 100
+	DEFAULT_QUEUE_TIMEOUT = 60
+)
+
+func (m *ModelConfig) queueSize() int {
+	if m.QueueSize < 1 {
+		return DEFAULT_QUEUE_SIZE
+	}
+	return m.QueueSize
+}
+
+func (m *ModelConfig) queueTimeout() time.Duration {
+	if m.QueueTimeout < 1 {
+		return DEFAULT_QUEUE_TIMEOUT * time.Second
+	}
+	return time.Duration(m.QueueTimeout) * time.Second
+}
+
+const (
+	RESTART_NEVER      = "never"
+	RESTART_ON_FAILURE = "on-failure"
+
+	DEFAULT_RESTART_MAX_RETRIES = 3
+	DEFAULT_RESTART_BACKOFF     = 1
+)
+
+type RestartConfig struct {
+	// never (default) or on-failure
+	Policy string `yaml:"policy"`
+
+	// number of restarts attempted before giving up
+	MaxRetries int `yaml:"maxRetries"`
+
+	// seconds to wait before the first restart, doubled on each retry
+	Backoff int `yaml:"backoff"`
+}
+
+func (r RestartConfig) maxRetries() int {
+	if r.MaxRetries < 1 {
+		return DEFAULT_RESTART_MAX_RETRIES
+	}
+	return r.MaxRetries
+}
+
+func (r RestartConfig) backoff() time.Duration {
+	if r.Backoff < 1 {
+		return DEFAULT_RESTART_BACKOFF * time.Second
+	}
+	return time.Duration(r.Backoff) * time.Second
+}
+
 type GroupConfig struct {
 	Swap       bool     `yaml:"swap"`
 	Exclusive  bool     `yaml:"exclusive"`
 	Persistent bool     `yaml:"persistent"`
 	Members    []string `yaml:"members"`
+
+	// how requests for different members are ordered when swap is true.
+	// "" swaps on every request, "batch" serves waiting requests for the
+	// loaded model before swapping
+	Scheduler string `yaml:"scheduler"`
+
+	// seconds a request is held by the batch scheduler before the loaded
+	// model stops accepting new requests so it can be swapped out
+	MaxWait int `yaml:"maxWait"`
+}
+
+const (
+	SCHEDULER_BATCH = "batch"
+
+	DEFAULT_SCHEDULER_MAX_WAIT = 30
+)
+
+func (c *GroupConfig) maxWait() time.Duration {
+	if c.MaxWait < 1 {
+		return DEFAULT_SCHEDULER_MAX_WAIT * time.Second
+	}
+	return time.Duration(c.MaxWait) * time.Second
 }
 
 // set default values for GroupConfig
@@ -54,6 +178,117 @@ func (c *GroupConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	return nil
 }
 
+// RequestLogConfig configures the JSONL request log
+type RequestLogConfig struct {
+	// file to write to, the request log is disabled when empty
+	Path string `yaml:"path"`
+
+	// megabytes written before the file is rotated
+	MaxSize int `yaml:"maxSize"`
+
+	// number of rotated files to keep
+	MaxBackups int `yaml:"maxBackups"`
+
+	// include request and response bodies, each truncated to maxBodySize bytes
+	Bodies      bool `yaml:"bodies"`
+	MaxBodySize int  `yaml:"maxBodySize"`
+}
+
+const (
+	DEFAULT_REQUEST_LOG_MAX_SIZE      = 100
+	DEFAULT_REQUEST_LOG_MAX_BACKUPS   = 3
+	DEFAULT_REQUEST_LOG_MAX_BODY_SIZE = 64 * 1024
+)
+
+func (c *RequestLogConfig) maxSize() int64 {
+	if c.MaxSize < 1 {
+		return DEFAULT_REQUEST_LOG_MAX_SIZE * 1024 * 1024
+	}
+	return int64(c.MaxSize) * 1024 * 1024
+}
+
+func (c *RequestLogConfig) maxBackups() int {
+	if c.MaxBackups < 1 {
+		return DEFAULT_REQUEST_LOG_MAX_BACKUPS
+	}
+	return c.MaxBackups
+}
+
+func (c *RequestLogConfig) maxBodySize() int {
+	if c.MaxBodySize < 1 {
+		return DEFAULT_REQUEST_LOG_MAX_BODY_SIZE
+	}
+	return c.MaxBodySize
+}
+
+// LogFileConfig configures writing the logs to files
+type LogFileConfig struct {
+	// file for llama-swap's logs, not written when empty
+	Proxy string `yaml:"proxy"`
+
+	// file for the logs of all models, not written when empty
+	Upstream string `yaml:"upstream"`
+
+	// directory for a file per model, <model ID>.log, not written when empty
+	ModelDir string `yaml:"modelDir"`
+
+	// megabytes written before a file is rotated
+	MaxSize int `yaml:"maxSize"`
+
+	// number of rotated files to keep
+	MaxBackups int `yaml:"maxBackups"`
+
+	// hours before a file is rotated, files are only rotated by size when 0
+	MaxAge int `yaml:"maxAge"`
+
+	// gzip rotated files
+	Compress bool `yaml:"compress"`
+}
+
+const (
+	DEFAULT_LOG_FILE_MAX_SIZE    = 100
+	DEFAULT_LOG_FILE_MAX_BACKUPS = 3
+)
+
+func (c *LogFileConfig) enabled() bool {
+	return c.Proxy != "" || c.Upstream != "" || c.ModelDir != ""
+}
+
+func (c *LogFileConfig) maxSize() int64 {
+	if c.MaxSize < 1 {
+		return DEFAULT_LOG_FILE_MAX_SIZE * 1024 * 1024
+	}
+	return int64(c.MaxSize) * 1024 * 1024
+}
+
+func (c *LogFileConfig) maxBackups() int {
+	if c.MaxBackups < 1 {
+		return DEFAULT_LOG_FILE_MAX_BACKUPS
+	}
+	return c.MaxBackups
+}
+
+func (c *LogFileConfig) maxAge() time.Duration {
+	if c.MaxAge < 1 {
+		return 0
+	}
+	return time.Duration(c.MaxAge) * time.Hour
+}
+
+func (c *Config) logBufferSize() int {
+	if c.LogBufferSize < 1 {
+		return DEFAULT_LOG_BUFFER_SIZE
+	}
+	return c.LogBufferSize * 1024
+}
+
+func (c *Config) logStreamBufferSize() int {
+	if c.LogStreamBufferSize < 1 {
+		return DEFAULT_LOG_STREAM_BUFFER_SIZE
+	}
+	return c.LogStreamBufferSize
+}
+
 type Config struct {
 	HealthCheckTimeout int                    `yaml:"healthCheckTimeout"`
 	LogRequests        bool                   `yaml:"logRequests"`
@@ -62,8 +297,54 @@ type Config struct {
 	Profiles           map[string][]string    `yaml:"profiles"`
 	Groups             map[string]GroupConfig `yaml:"groups"` /* key is group ID */
 
+	// first port to assign to models using the ${PORT} macro
+	StartPort int `yaml:"startPort"`
+
+	// macros are expanded with ${name} in cmd, proxy, checkEndpoint and env
+	Macros map[string]string `yaml:"macros"`
+
+	// resource budget, e.g. {vram: 48}. When set models are loaded while they
+	// fit and the least recently used models are unloaded to make room
+	Resources map[string]float64 `yaml:"resources"`
+
+	// models or aliases to start when llama-swap starts
+	Preload []string `yaml:"preload"`
+
+	// KB of log lines kept in memory by each log, defaults to 1024
+	LogBufferSize int `yaml:"logBufferSize"`
+
+	// log lines queued for each /logs/stream client, lines are dropped when a
+	// client falls further behind. Defaults to 100
+	LogStreamBufferSize int `yaml:"logStreamBufferSize"`
+
+	// write the logs to rotated files, changes require a restart
+	LogFile LogFileConfig `yaml:"logFile"`
+
+	// write a JSON line for every request, changes require a restart
+	RequestLog RequestLogConfig `yaml:"requestLog"`
+
+	// API keys, requests are not authenticated when there are none
+	Auth AuthConfig `yaml:"auth"`
+
+	// limits for each API key, or client IP when requests are not authenticated
+	RateLimit RateLimitConfig `yaml:"rateLimit"`
+
+	// number of /v1/responses conversations kept for previous_response_id,
+	// the least recently used are dropped first. Defaults to 1000
+	ResponseStoreSize int `yaml:"responseStoreSize"`
+
+	// addresses to listen on when -listen is not used, see ParseListener.
+	// The TLS certificate and key are used when -tls-cert and -tls-key are not set.
+	// Changes require a restart
+	Listen  []string `yaml:"listen"`
+	TLSCert string   `yaml:"tlsCert"`
+	TLSKey  string   `yaml:"tlsKey"`
+
 	// map aliases to actual model IDs
 	aliases map[string]string
+
+	// ports assigned with the ${PORT} macro, key is the model ID
+	ports map[string]int
 }
 
 func (c *Config) RealModelName(search string) (string, bool) {
@@ -76,6 +357,39 @@ func (c *Config) RealModelName(search string) (string, bool) {
 	}
 }
 
+// PreloadModels returns the real model IDs to start when llama-swap starts.
+// Models in the preload list come first, in order, followed by models with
+// preload set, sorted by ID.
+func (c *Config) PreloadModels() []string {
+	var preload []string
+	seen := make(map[string]bool)
+	add := func(modelID string) {
+		if !seen[modelID] {
+			seen[modelID] = true
+			preload = append(preload, modelID)
+		}
+	}
+
+	for _, name := range c.Preload {
+		if realName, found := c.RealModelName(name); found {
+			add(realName)
+		}
+	}
+
+	modelIDs := make([]string, 0, len(c.Models))
+	for modelID, modelConfig := range c.Models {
+		if modelConfig.Preload {
+			modelIDs = append(modelIDs, modelID)
+		}
+	}
+	sort.Strings(modelIDs)
+	for _, modelID := range modelIDs {
+		add(modelID)
+	}
+
+	return preload
+}
+
 func (c *Config) FindConfig(modelName string) (ModelConfig, string, bool) {
 	if realName, found := c.RealModelName(modelName); !found {
 		return ModelConfig{}, "", false
@@ -85,6 +399,12 @@ func (c *Config) FindConfig(modelName string) (ModelConfig, string, bool) {
 }
 
 func LoadConfig(path string) (Config, error) {
+	return loadConfig(path, Config{})
+}
+
+// loadConfig loads the config at path. Models keep the ports assigned to
+// them in previous, the config being reloaded, so they are not restarted.
+func loadConfig(path string, previous Config) (Config, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return Config{}, err
@@ -100,6 +420,30 @@ func LoadConfig(path string) (Config, error) {
 		config.HealthCheckTimeout = 15
 	}
 
+	if config.StartPort < 1 {
+		config.StartPort = DEFAULT_START_PORT
+	}
+
+	if err := expandModelMacros(&config); err != nil {
+		return Config{}, err
+	}
+
+	if err := assignModelPorts(&config, previous); err != nil {
+		return Config{}, err
+	}
+
+	for modelID, modelConfig := range config.Models {
+		switch modelConfig.Restart.Policy {
+		case "", RESTART_NEVER, RESTART_ON_FAILURE:
+		default:
+			return Config{}, fmt.Errorf("model %s: invalid restart policy %q, use %s or %s", modelID, modelConfig.Restart.Policy, RESTART_NEVER, RESTART_ON_FAILURE)
+		}
+	}
+
+	if err := checkModelResources(config); err != nil {
+		return Config{}, err
+	}
+
 	// Populate the aliases map
 	config.aliases = make(map[string]string)
 	for modelName, modelConfig := range config.Models {
@@ -108,7 +452,25 @@ func LoadConfig(path string) (Config, error) {
 		}
 	}
 
+	for _, name := range config.Preload {
+		if _, found := config.RealModelName(name); !found {
+			return Config{}, fmt.Errorf("preload model %s is not defined in models", name)
+		}
+	}
+
+	if err := resolveAPIKeys(&config); err != nil {
+		return Config{}, err
+	}
+
 	config = AddDefaultGroupToConfig(config)
+	for groupID, groupConfig := range config.Groups {
+		switch groupConfig.Scheduler {
+		case "", SCHEDULER_BATCH:
+		default:
+			return Config{}, fmt.Errorf("group %s: invalid scheduler %q, only %s is supported", groupID, groupConfig.Scheduler, SCHEDULER_BATCH)
+		}
+	}
+
 	// check that members are all unique in the groups
 	memberUsage := make(map[string]string) // maps member to group it appears in
 	for groupID, groupConfig := range config.Groups {
@@ -128,9 +490,297 @@ func LoadConfig(path string) (Config, error) {
 		}
 	}
 
+	if err := checkPortConflicts(config); err != nil {
+		return Config{}, err
+	}
+
 	return config, nil
 }
 
+// expandModelMacros expands ${name} macros and ${env.NAME} environment
+// variables in the fields returned by modelMacroFields. ${PORT} is left in
+// place for assignModelPorts, which replaces it in the same fields.
+func expandModelMacros(config *Config) error {
+	for name := range config.Macros {
+		if !macroNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid macro name %q, only letters, numbers, _ and - are allowed", name)
+		}
+		if "${"+name+"}" == PORT_MACRO {
+			return fmt.Errorf("macro name %s is reserved", name)
+		}
+	}
+
+	expander := &macroExpander{
+		macros:   config.Macros,
+		resolved: make(map[string]string),
+	}
+
+	for modelID, modelConfig := range config.Models {
+		for _, field := range modelMacroFields(&modelConfig) {
+			expanded, err := expander.expand(*field.value, nil)
+			if err != nil {
+				return fmt.Errorf("model %s: %s: %v", modelID, field.name, err)
+			}
+			*field.value = expanded
+		}
+		config.Models[modelID] = modelConfig
+	}
+
+	return nil
+}
+
+// macroField is a model setting where macros are expanded
+type macroField struct {
+	name  string
+	value *string
+}
+
+// modelMacroFields returns the settings of a model where macros, ${PORT}
+// included, are expanded. Env is copied so the fields can be changed
+// without changing the config the model was copied from.
+func modelMacroFields(modelConfig *ModelConfig) []macroField {
+	fields := []macroField{
+		{"cmd", &modelConfig.Cmd},
+		{"proxy", &modelConfig.Proxy},
+		{"checkEndpoint", &modelConfig.CheckEndpoint},
+	}
+
+	if modelConfig.Env != nil {
+		modelConfig.Env = append([]string(nil), modelConfig.Env...)
+		for i := range modelConfig.Env {
+			fields = append(fields, macroField{"env", &modelConfig.Env[i]})
+		}
+	}
+	return fields
+}
+
+type macroExpander struct {
+	macros   map[string]string
+	resolved map[string]string
+}
+
+// expand replaces all macros in s. stack holds the macros currently being
+// expanded and is used to detect macros that reference each other in a loop.
+func (e *macroExpander) expand(s string, stack []string) (string, error) {
+	var expandErr error
+	result := macroPattern.ReplaceAllStringFunc(s, func(match string) string {
+		if expandErr != nil {
+			return match
+		}
+
+		if match == PORT_MACRO {
+			return match
+		}
+
+		name := match[2 : len(match)-1]
+		if strings.HasPrefix(name, ENV_MACRO_PREFIX) {
+			envName := strings.TrimPrefix(name, ENV_MACRO_PREFIX)
+			value, found := os.LookupEnv(envName)
+			if !found {
+				expandErr = fmt.Errorf("environment variable %s is not set", envName)
+			}
+			return value
+		}
+
+		if value, found := e.resolved[name]; found {
+			return value
+		}
+
+		for i, seen := range stack {
+			if seen == name {
+				cycle := append(append([]string{}, stack[i:]...), name)
+				expandErr = fmt.Errorf("macro cycle detected: %s", strings.Join(cycle, " -> "))
+				return match
+			}
+		}
+
+		value, found := e.macros[name]
+		if !found {
+			expandErr = fmt.Errorf("undefined macro %s", match)
+			return match
+		}
+
+		value, err := e.expand(value, append(stack, name))
+		if err != nil {
+			expandErr = err
+			return match
+		}
+
+		e.resolved[name] = value
+		return value
+	})
+
+	return result, expandErr
+}
+
+// assignModelPorts replaces the ${PORT} macro in each model's cmd, proxy,
+// checkEndpoint and env with a port starting from config.StartPort. Ports explicitly used in a
+// model's proxy are skipped. Models are assigned ports in sorted order so
+// the same config always results in the same ports.
+//
+// On a reload models keep their port from previous, unless startPort changed,
+// and new models are only given ports that none of the previous models used
+// so they don't collide with a process that is still stopping.
+func assignModelPorts(config *Config, previous Config) error {
+	usedPorts := make(map[int]bool)
+	for _, modelConfig := range config.Models {
+		if strings.Contains(modelConfig.Proxy, PORT_MACRO) {
+			continue
+		}
+		if port, found := proxyPort(modelConfig.Proxy); found {
+			usedPorts[port] = true
+		}
+	}
+
+	previousPorts := previous.ports
+	if previous.StartPort != config.StartPort {
+		previousPorts = nil
+	}
+
+	modelIDs := make([]string, 0, len(config.Models))
+	for modelID := range config.Models {
+		modelIDs = append(modelIDs, modelID)
+	}
+	sort.Strings(modelIDs)
+
+	// models keep their previous port unless it is now used explicitly
+	assigned := make(map[string]int)
+	for _, modelID := range modelIDs {
+		if port, found := previousPorts[modelID]; found && !usedPorts[port] && passesPort(config.Models[modelID]) {
+			assigned[modelID] = port
+		}
+	}
+	for _, port := range assigned {
+		usedPorts[port] = true
+	}
+	for _, port := range previousPorts {
+		usedPorts[port] = true
+	}
+
+	nextPort := config.StartPort
+	for _, modelID := range modelIDs {
+		modelConfig := config.Models[modelID]
+		fields := modelMacroFields(&modelConfig)
+
+		// the upstream only knows its port when it is passed in cmd or env
+		usesPort := false
+		for _, field := range fields {
+			if !strings.Contains(*field.value, PORT_MACRO) {
+				continue
+			}
+			if !passesPort(modelConfig) {
+				return fmt.Errorf("model %s: %s uses %s but cmd and env do not", modelID, field.name, PORT_MACRO)
+			}
+			usesPort = true
+		}
+		if !usesPort {
+			continue
+		}
+
+		assignedPort, found := assigned[modelID]
+		if !found {
+			for usedPorts[nextPort] {
+				nextPort++
+			}
+			if nextPort > 65535 {
+				return fmt.Errorf("model %s: no ports left to assign after startPort %d", modelID, config.StartPort)
+			}
+			assignedPort = nextPort
+			usedPorts[nextPort] = true
+		}
+
+		port := strconv.Itoa(assignedPort)
+		if config.ports == nil {
+			config.ports = make(map[string]int)
+		}
+		config.ports[modelID] = assignedPort
+
+		// default the proxy to the assigned port
+		if modelConfig.Proxy == "" {
+			modelConfig.Proxy = "http://localhost:" + PORT_MACRO
+		}
+
+		for _, field := range fields {
+			*field.value = strings.ReplaceAll(*field.value, PORT_MACRO, port)
+		}
+		config.Models[modelID] = modelConfig
+	}
+
+	return nil
+}
+
+// passesPort returns true if the model's cmd or env passes ${PORT} to the upstream
+func passesPort(modelConfig ModelConfig) bool {
+	if strings.Contains(modelConfig.Cmd, PORT_MACRO) {
+		return true
+	}
+	for _, value := range modelConfig.Env {
+		if strings.Contains(value, PORT_MACRO) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPortConflicts returns an error when two models in a group that runs
+// its members together (swap: false) proxy to the same port
+func checkPortConflicts(config Config) error {
+	groupIDs := make([]string, 0, len(config.Groups))
+	for groupID := range config.Groups {
+		groupIDs = append(groupIDs, groupID)
+	}
+	sort.Strings(groupIDs)
+
+	for _, groupID := range groupIDs {
+		groupConfig := config.Groups[groupID]
+		if groupConfig.Swap {
+			continue
+		}
+
+		portUsage := make(map[int]string) // maps port to the model using it
+		for _, member := range groupConfig.Members {
+			port, found := proxyPort(config.Models[member].Proxy)
+			if !found {
+				continue
+			}
+
+			if otherModel, exists := portUsage[port]; exists {
+				return fmt.Errorf("models %s and %s both use port %d in group: %s", otherModel, member, port, groupID)
+			}
+			portUsage[port] = member
+		}
+	}
+
+	return nil
+}
+
+// proxyPort returns the port of a proxy URL, using the scheme's default
+// port when one is not set
+func proxyPort(proxy string) (int, bool) {
+	u, err := url.Parse(proxy)
+	if err != nil || u.Host == "" {
+		return 0, false
+	}
+
+	portStr := u.Port()
+	if portStr == "" {
+		switch u.Scheme {
+		case "http":
+			return 80, true
+		case "https":
+			return 443, true
+		default:
+			return 0, false
+		}
+	}
+
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return 0, false
+	}
+	return port, true
+}
+
 // rewrites the yaml to include a default group with any orphaned models
 func AddDefaultGroupToConfig(config Config) Config {
 
@@ -143,6 +793,13 @@ func AddDefaultGroupToConfig(config Config) Config {
 		Exclusive: true,
 		Members:   []string{},
 	}
+
+	// with a resource budget models in the default group are loaded
+	// together while they fit instead of one at a time
+	if len(config.Resources) > 0 {
+		defaultGroup.Swap = false
+		defaultGroup.Exclusive = false
+	}
 	// if groups is empty, create a default group and put
 	// all models into it
 	if len(config.Groups) == 0 {
@@ -101,6 +101,7 @@ groups:
 			},
 		},
 		HealthCheckTimeout: 15,
+		StartPort:          DEFAULT_START_PORT,
 		Profiles: map[string][]string{
 			"test": {"model1", "model2"},
 		},
@@ -182,6 +183,363 @@ groups:
 
 }
 
+func TestConfig_AutomaticPortAssignment(t *testing.T) {
+	tempFile := filepath.Join(t.TempDir(), "config.yaml")
+	content := `
+startPort: 9000
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+  model2:
+    cmd: path/to/cmd --port ${PORT}
+    proxy: "http://172.17.0.1:${PORT}/v1"
+  model3:
+    cmd: path/to/cmd --port 9001
+    proxy: "http://localhost:9001"
+  model4:
+    cmd: path/to/cmd --port ${PORT}
+`
+
+	if err := os.WriteFile(tempFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write temporary file: %v", err)
+	}
+
+	config, err := LoadConfig(tempFile)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, "path/to/cmd --port 9000", config.Models["model1"].Cmd)
+	assert.Equal(t, "http://localhost:9000", config.Models["model1"].Proxy)
+
+	// 9001 is explicitly used by model3 so it is skipped
+	assert.Equal(t, "path/to/cmd --port 9002", config.Models["model2"].Cmd)
+	assert.Equal(t, "http://172.17.0.1:9002/v1", config.Models["model2"].Proxy)
+
+	assert.Equal(t, "http://localhost:9001", config.Models["model3"].Proxy)
+
+	assert.Equal(t, "path/to/cmd --port 9003", config.Models["model4"].Cmd)
+	assert.Equal(t, "http://localhost:9003", config.Models["model4"].Proxy)
+}
+
+func TestConfig_PortsKeptOnReload(t *testing.T) {
+	tempFile := filepath.Join(t.TempDir(), "config.yaml")
+	load := func(content string, previous Config) Config {
+		t.Helper()
+		if err := os.WriteFile(tempFile, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write temporary file: %v", err)
+		}
+		config, err := loadConfig(tempFile, previous)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		return config
+	}
+
+	previous := load(`
+startPort: 9000
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+  model3:
+    cmd: path/to/cmd --port ${PORT}
+  model4:
+    cmd: path/to/cmd --port ${PORT}
+`, Config{})
+	assert.Equal(t, "http://localhost:9001", previous.Models["model3"].Proxy)
+	assert.Equal(t, "http://localhost:9002", previous.Models["model4"].Proxy)
+
+	// model2 sorts before model3 but does not renumber it, and it does not
+	// get model4's port while model4 may still be stopping
+	config := load(`
+startPort: 9000
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+  model2:
+    cmd: path/to/cmd --port ${PORT}
+  model3:
+    cmd: path/to/cmd --port ${PORT}
+`, previous)
+	assert.Equal(t, previous.Models["model1"], config.Models["model1"])
+	assert.Equal(t, previous.Models["model3"], config.Models["model3"])
+	assert.Equal(t, "path/to/cmd --port 9003", config.Models["model2"].Cmd)
+
+	// a later reload can use the port again
+	config = load(`
+startPort: 9000
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+  model3:
+    cmd: path/to/cmd --port ${PORT}
+  model5:
+    cmd: path/to/cmd --port ${PORT}
+`, config)
+	assert.Equal(t, "path/to/cmd --port 9002", config.Models["model5"].Cmd)
+
+	// changing startPort assigns all ports again
+	config = load(`
+startPort: 10000
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+  model3:
+    cmd: path/to/cmd --port ${PORT}
+`, config)
+	assert.Equal(t, "path/to/cmd --port 10001", config.Models["model3"].Cmd)
+}
+
+func TestConfig_PortMacroInProxyRequiresCmd(t *testing.T) {
+	tempFile := filepath.Join(t.TempDir(), "config.yaml")
+	content := `
+models:
+  model1:
+    cmd: path/to/cmd --port 9000
+    proxy: "http://localhost:${PORT}"
+`
+
+	if err := os.WriteFile(tempFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write temporary file: %v", err)
+	}
+
+	_, err := LoadConfig(tempFile)
+	assert.ErrorContains(t, err, "proxy uses ${PORT} but cmd and env do not")
+}
+
+func TestConfig_PortMacroInEnvAndCheckEndpoint(t *testing.T) {
+	load := func(content string) (Config, error) {
+		tempFile := filepath.Join(t.TempDir(), "config.yaml")
+		if err := os.WriteFile(tempFile, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write temporary file: %v", err)
+		}
+		return LoadConfig(tempFile)
+	}
+
+	config, err := load(`
+startPort: 9000
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+    checkEndpoint: "http://localhost:${PORT}/health"
+    env: ["LISTEN=127.0.0.1:${PORT}"]
+  model2:
+    cmd: path/to/server
+    env: ["PORT=${PORT}"]
+`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "http://localhost:9000/health", config.Models["model1"].CheckEndpoint)
+	assert.Equal(t, []string{"LISTEN=127.0.0.1:9000"}, config.Models["model1"].Env)
+	assert.Equal(t, []string{"PORT=9001"}, config.Models["model2"].Env)
+	assert.Equal(t, "http://localhost:9001", config.Models["model2"].Proxy)
+
+	// the upstream never gets the port
+	_, err = load(`
+models:
+  model1:
+    cmd: path/to/cmd --port 9000
+    checkEndpoint: "http://localhost:${PORT}/health"
+`)
+	assert.ErrorContains(t, err, "model model1: checkEndpoint uses ${PORT} but cmd and env do not")
+}
+
+func TestConfig_PortConflicts(t *testing.T) {
+	tests := []struct {
+		name        string
+		swap        string
+		expectError bool
+	}{
+		{"swapping group can share a port", "true", false},
+		{"non-swapping group can not share a port", "false", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tempFile := filepath.Join(t.TempDir(), "config.yaml")
+			content := `
+models:
+  model1:
+    cmd: path/to/cmd --port 9001
+    proxy: "http://localhost:9001"
+  model2:
+    cmd: path/to/cmd --port 9001
+    proxy: "http://127.0.0.1:9001"
+groups:
+  group1:
+    swap: ` + tt.swap + `
+    exclusive: false
+    members: ["model1", "model2"]
+`
+
+			if err := os.WriteFile(tempFile, []byte(content), 0644); err != nil {
+				t.Fatalf("Failed to write temporary file: %v", err)
+			}
+
+			_, err := LoadConfig(tempFile)
+			if tt.expectError {
+				assert.ErrorContains(t, err, "both use port 9001 in group: group1")
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestConfig_MacroExpansion(t *testing.T) {
+	t.Setenv("LLAMA_SWAP_TEST_MODELS", "/mnt/models")
+
+	tempFile := filepath.Join(t.TempDir(), "config.yaml")
+	content := `
+macros:
+  server: /app/llama-server ${defaults}
+  defaults: --port ${PORT} -ngl 99
+  models: ${env.LLAMA_SWAP_TEST_MODELS}
+  health: /health
+  listen: 127.0.0.1:${PORT}
+models:
+  model1:
+    cmd: ${server} -m ${models}/model1.gguf
+    checkEndpoint: ${health}
+    env:
+      - "MODEL_DIR=${models}"
+  model2:
+    cmd: /app/server
+    checkEndpoint: http://${listen}/health
+    env:
+      - "LISTEN=${listen}"
+`
+
+	if err := os.WriteFile(tempFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write temporary file: %v", err)
+	}
+
+	config, err := LoadConfig(tempFile)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, "/app/llama-server --port 5800 -ngl 99 -m /mnt/models/model1.gguf", config.Models["model1"].Cmd)
+	assert.Equal(t, "http://localhost:5800", config.Models["model1"].Proxy)
+	assert.Equal(t, "/health", config.Models["model1"].CheckEndpoint)
+	assert.Equal(t, []string{"MODEL_DIR=/mnt/models"}, config.Models["model1"].Env)
+
+	// ${PORT} from a macro is replaced wherever macros are expanded
+	assert.Equal(t, "http://127.0.0.1:5801/health", config.Models["model2"].CheckEndpoint)
+	assert.Equal(t, []string{"LISTEN=127.0.0.1:5801"}, config.Models["model2"].Env)
+	assert.Equal(t, "http://localhost:5801", config.Models["model2"].Proxy)
+}
+
+func TestConfig_MacroErrors(t *testing.T) {
+	tests := []struct {
+		name          string
+		content       string
+		expectedError string
+	}{
+		{
+			name: "undefined macro",
+			content: `
+models:
+  model1:
+    cmd: ${missing} --port 9000
+    proxy: http://localhost:9000
+`,
+			expectedError: "undefined macro ${missing}",
+		},
+		{
+			name: "undefined environment variable",
+			content: `
+models:
+  model1:
+    cmd: ${env.LLAMA_SWAP_TEST_NOT_SET} --port 9000
+    proxy: http://localhost:9000
+`,
+			expectedError: "environment variable LLAMA_SWAP_TEST_NOT_SET is not set",
+		},
+		{
+			name: "macro cycle",
+			content: `
+macros:
+  a: ${b}
+  b: ${c}
+  c: ${a}
+models:
+  model1:
+    cmd: ${a} --port 9000
+    proxy: http://localhost:9000
+`,
+			expectedError: "macro cycle detected: a -> b -> c -> a",
+		},
+		{
+			name: "reserved macro name",
+			content: `
+macros:
+  PORT: "9000"
+models:
+  model1:
+    cmd: cmd --port ${PORT}
+`,
+			expectedError: "macro name PORT is reserved",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tempFile := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(tempFile, []byte(tt.content), 0644); err != nil {
+				t.Fatalf("Failed to write temporary file: %v", err)
+			}
+
+			_, err := LoadConfig(tempFile)
+			assert.ErrorContains(t, err, tt.expectedError)
+		})
+	}
+}
+
+func TestConfig_PreloadModels(t *testing.T) {
+	tempFile := filepath.Join(t.TempDir(), "config.yaml")
+	content := `
+preload:
+  - m3
+  - model1
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+  model2:
+    cmd: path/to/cmd --port ${PORT}
+    preload: true
+  model3:
+    cmd: path/to/cmd --port ${PORT}
+    aliases: ["m3"]
+    preload: true
+`
+
+	if err := os.WriteFile(tempFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write temporary file: %v", err)
+	}
+
+	config, err := LoadConfig(tempFile)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, []string{"model3", "model1", "model2"}, config.PreloadModels())
+
+	content = `
+preload: ["nope"]
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+`
+	if err := os.WriteFile(tempFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write temporary file: %v", err)
+	}
+
+	_, err = LoadConfig(tempFile)
+	assert.ErrorContains(t, err, "preload model nope is not defined in models")
+}
+
 func TestConfig_ModelConfigSanitizedCommand(t *testing.T) {
 	config := &ModelConfig{
 		Cmd: `python model1.py \
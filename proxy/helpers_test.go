@@ -2,6 +2,7 @@ package proxy
 
 import (
 	"fmt"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -9,6 +10,7 @@ import (
 	"testing"
 
 	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
 )
 
 var (
@@ -68,3 +70,19 @@ func getTestSimpleResponderConfigPort(expectedMessage string, port int) ModelCon
 		CheckEndpoint: "/health",
 	}
 }
+
+// translateResponse writes an upstream response through a translatingWriter
+func translateResponse(t *testing.T, translator responseTranslator, statusCode int, contentType string, chunks ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	tw := newTranslatingWriter(c.Writer, translator)
+	tw.Header().Set("Content-Type", contentType)
+	tw.WriteHeader(statusCode)
+	for _, chunk := range chunks {
+		tw.Write([]byte(chunk))
+	}
+	assert.NoError(t, tw.finish())
+	return w
+}
@@ -1,11 +1,13 @@
 package proxy
 
 import (
-	"container/ring"
+	"bytes"
 	"fmt"
 	"io"
 	"os"
+	"strings"
 	"sync"
+	"time"
 )
 
 type LogLevel int
@@ -17,18 +19,113 @@ const (
 	LevelError
 )
 
-type LogMonitor struct {
-	clients  map[chan []byte]bool
-	mu       sync.RWMutex
-	buffer   *ring.Ring
-	bufferMu sync.RWMutex
+// DEFAULT_LOG_BUFFER_SIZE is the number of bytes of log lines each LogMonitor keeps
+const DEFAULT_LOG_BUFFER_SIZE = 1024 * 1024
+
+// DEFAULT_LOG_STREAM_BUFFER_SIZE is the number of entries queued for each
+// subscriber before entries are dropped
+const DEFAULT_LOG_STREAM_BUFFER_SIZE = 100
+
+// lines longer than this are split so a process that never writes a newline
+// can not grow the pending line forever
+const maxLogLineLength = 64 * 1024
+
+// a line that is not completed by a newline within this time is added as it
+// is, so a progress message without a newline still shows up in the logs
+const partialLineTimeout = time.Second
+
+// LogEntry is a line of log output
+type LogEntry struct {
+	Time  time.Time `json:"time"`
+	Level string    `json:"level"`
+
+	// "proxy" or "upstream"
+	Source string `json:"source,omitempty"`
+
+	// the model that wrote the line, empty for llama-swap's own logs
+	Model string `json:"model,omitempty"`
+
+	Message string `json:"message"`
+
+	// set on the entry sent to a subscriber in place of the entries it
+	// dropped because it was not reading fast enough
+	Dropped int `json:"dropped,omitempty"`
+
+	// the line was written with a [LEVEL] tag, it is kept in text output
+	levelTagged bool
+}
+
+// size is the approximate memory used by the entry
+func (e LogEntry) size() int {
+	return len(e.Message) + len(e.Level) + len(e.Source) + len(e.Model) + 64
+}
+
+// text formats the entry as a line of text, tagged with its model when withModel is true
+func (e LogEntry) text(withModel bool) []byte {
+	var b bytes.Buffer
+	if withModel && e.Model != "" {
+		fmt.Fprintf(&b, "[%s] ", e.Model)
+	}
+	if e.levelTagged {
+		fmt.Fprintf(&b, "[%s] ", strings.ToUpper(e.Level))
+	}
+	b.WriteString(e.Message)
+	b.WriteByte('\n')
+	return b.Bytes()
+}
+
+// logSubscriber is a client of a LogMonitor
+type logSubscriber struct {
+	filter LogFilter
+
+	// entries dropped since the last dropped entry was sent
+	dropped int
+}
+
+// droppedLogEntry tells a subscriber n entries were dropped
+func droppedLogEntry(n int) LogEntry {
+	return LogEntry{
+		Time:        time.Now(),
+		Level:       LevelWarn.Name(),
+		Source:      "proxy",
+		Message:     fmt.Sprintf("%d log lines dropped, the client is not reading them fast enough", n),
+		Dropped:     n,
+		levelTagged: true,
+	}
+}
 
-	// typically this can be os.Stdout
+type LogMonitor struct {
+	clients map[chan LogEntry]*logSubscriber
+	mu      sync.RWMutex
+
+	// size of new subscribers' channels
+	streamBufferSize int
+
+	// the most recent lines, up to maxBufferSize bytes
+	buffer        []LogEntry
+	bufferSize    int
+	maxBufferSize int
+	bufferMu      sync.RWMutex
+
+	// a line that has not been completed by a newline yet. partialGen
+	// changes each time it is taken so a stale flush timer does nothing.
+	partial    []byte
+	partialGen int
+	partialMu  sync.Mutex
+
+	// typically this can be os.Stdout. When it is another LogMonitor lines
+	// are passed on as entries.
 	stdout io.Writer
 
+	// other writers, like log files, that get a copy of every line with its time
+	writers []io.Writer
+
 	// logging levels
-	level  LogLevel
-	prefix string
+	level LogLevel
+
+	// set on the lines written to this monitor
+	source string
+	model  string
 }
 
 func NewLogMonitor() *LogMonitor {
@@ -37,60 +134,243 @@ func NewLogMonitor() *LogMonitor {
 
 func NewLogMonitorWriter(stdout io.Writer) *LogMonitor {
 	return &LogMonitor{
-		clients: make(map[chan []byte]bool),
-		buffer:  ring.New(10 * 1024), // keep 10KB of buffered logs
-		stdout:  stdout,
-		level:   LevelInfo,
-		prefix:  "",
+		clients:          make(map[chan LogEntry]*logSubscriber),
+		streamBufferSize: DEFAULT_LOG_STREAM_BUFFER_SIZE,
+		maxBufferSize:    DEFAULT_LOG_BUFFER_SIZE,
+		stdout:           stdout,
+		level:            LevelInfo,
 	}
 }
 
+// Write splits p into lines. A line without a newline is held until it is
+// completed by a later write, or partialLineTimeout has passed.
 func (w *LogMonitor) Write(p []byte) (n int, err error) {
 	if len(p) == 0 {
 		return 0, nil
 	}
 
-	n, err = w.stdout.Write(p)
-	if err != nil {
-		return n, err
+	now := time.Now()
+	var lines [][]byte
+
+	w.partialMu.Lock()
+	started := len(w.partial) == 0
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			if len(w.partial) >= maxLogLineLength {
+				lines = append(lines, w.partial)
+				w.partial = nil
+			}
+			break
+		}
+		lines = append(lines, w.partial[:i])
+		w.partial = w.partial[i+1:]
+	}
+	if len(lines) > 0 {
+		w.partialGen++
+		started = true
+	}
+	if len(w.partial) == 0 {
+		w.partial = nil
+	} else {
+		if len(lines) > 0 {
+			// don't keep the completed lines' memory alive
+			w.partial = append([]byte(nil), w.partial...)
+		}
+		if started {
+			gen := w.partialGen
+			time.AfterFunc(partialLineTimeout, func() { w.flushPartial(gen) })
+		}
+	}
+	w.partialMu.Unlock()
+
+	for _, line := range lines {
+		if err := w.add(parseLogLine(now, line)); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// flushPartial adds the line that has not been completed by a newline, if
+// it is still the one started in generation gen
+func (w *LogMonitor) flushPartial(gen int) {
+	w.partialMu.Lock()
+	if gen != w.partialGen || len(w.partial) == 0 {
+		w.partialMu.Unlock()
+		return
+	}
+	line := w.partial
+	w.partial = nil
+	w.partialGen++
+	w.partialMu.Unlock()
+
+	w.add(parseLogLine(time.Now(), line))
+}
+
+// parseLogLine creates an entry for a line, lines tagged with [LEVEL] keep
+// their level and others are info
+func parseLogLine(now time.Time, line []byte) LogEntry {
+	entry := LogEntry{
+		Time:    now,
+		Level:   "info",
+		Message: string(bytes.TrimRight(line, "\r")),
+	}
+
+	for _, level := range []LogLevel{LevelDebug, LevelInfo, LevelWarn, LevelError} {
+		if message, ok := strings.CutPrefix(entry.Message, "["+level.String()+"] "); ok {
+			entry.Level = level.Name()
+			entry.Message = message
+			entry.levelTagged = true
+			break
+		}
+	}
+	return entry
+}
+
+// add stores and broadcasts an entry and passes it on to stdout
+func (w *LogMonitor) add(entry LogEntry) error {
+	w.mu.RLock()
+	if entry.Source == "" {
+		entry.Source = w.source
+	}
+	if entry.Model == "" {
+		entry.Model = w.model
+	}
+	for _, writer := range w.writers {
+		// errors are ignored, there is nowhere to log them
+		writer.Write(w.fileLine(entry))
+	}
+	w.mu.RUnlock()
+
+	if parent, ok := w.stdout.(*LogMonitor); ok {
+		parent.add(entry)
+	} else if _, err := w.stdout.Write(entry.text(true)); err != nil {
+		return err
 	}
 
 	w.bufferMu.Lock()
-	bufferCopy := make([]byte, len(p))
-	copy(bufferCopy, p)
-	w.buffer.Value = bufferCopy
-	w.buffer = w.buffer.Next()
+	w.buffer = append(w.buffer, entry)
+	w.bufferSize += entry.size()
+	w.trimLocked()
 	w.bufferMu.Unlock()
 
-	w.broadcast(bufferCopy)
-	return n, nil
+	w.broadcast(entry)
+	return nil
+}
+
+// trimLocked drops the oldest entries over the buffer size. The caller must
+// hold bufferMu.
+func (w *LogMonitor) trimLocked() {
+	drop := 0
+	for w.bufferSize > w.maxBufferSize && drop < len(w.buffer) {
+		w.bufferSize -= w.buffer[drop].size()
+		drop++
+	}
+	if drop > 0 {
+		w.buffer = append([]LogEntry(nil), w.buffer[drop:]...)
+	}
+}
+
+// SetBufferSize sets how many bytes of log lines are kept
+func (w *LogMonitor) SetBufferSize(size int) {
+	w.bufferMu.Lock()
+	defer w.bufferMu.Unlock()
+	w.maxBufferSize = size
+	w.trimLocked()
 }
 
+// GetHistory returns the buffered lines as text, followed by the line that
+// has not been completed yet
 func (w *LogMonitor) GetHistory() []byte {
+	var history []byte
+	for _, entry := range w.GetEntries() {
+		history = append(history, w.Format(entry)...)
+	}
+
+	w.partialMu.Lock()
+	history = append(history, w.partial...)
+	w.partialMu.Unlock()
+	return history
+}
+
+// GetEntries returns a copy of the buffered lines, oldest first
+func (w *LogMonitor) GetEntries() []LogEntry {
 	w.bufferMu.RLock()
 	defer w.bufferMu.RUnlock()
+	return append([]LogEntry(nil), w.buffer...)
+}
 
-	var history []byte
-	w.buffer.Do(func(p any) {
-		if p != nil {
-			if content, ok := p.([]byte); ok {
-				history = append(history, content...)
-			}
+// Format returns an entry as a line of text. Lines from other models are
+// tagged with the model.
+func (w *LogMonitor) Format(entry LogEntry) []byte {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return entry.text(entry.Model != w.model)
+}
+
+// fileLine formats an entry for a writer. The caller must hold mu.
+func (w *LogMonitor) fileLine(entry LogEntry) []byte {
+	line := []byte(entry.Time.Format("2006-01-02T15:04:05.000Z07:00 "))
+	return append(line, entry.text(entry.Model != w.model)...)
+}
+
+// AddWriter writes every line to writer as well, prefixed with its time.
+// Adding a writer again does nothing.
+func (w *LogMonitor) AddWriter(writer io.Writer) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, added := range w.writers {
+		if added == writer {
+			return
 		}
-	})
-	return history
+	}
+	w.writers = append(w.writers, writer)
 }
 
-func (w *LogMonitor) Subscribe() chan []byte {
+// Subscribe returns a channel receiving every new entry. Entries used to be
+// sent as raw []byte writes, use Format to get an entry as a line of text.
+func (w *LogMonitor) Subscribe() chan LogEntry {
+	return w.SubscribeFilter(LogFilter{})
+}
+
+// SubscribeFilter subscribes to the entries matching filter. Entries are
+// filtered before they are sent so they don't fill up the channel.
+func (w *LogMonitor) SubscribeFilter(filter LogFilter) chan LogEntry {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	ch := make(chan []byte, 100)
-	w.clients[ch] = true
+	ch := make(chan LogEntry, w.streamBufferSize)
+	w.clients[ch] = &logSubscriber{filter: filter}
 	return ch
 }
 
-func (w *LogMonitor) Unsubscribe(ch chan []byte) {
+// SetStreamBufferSize sets how many entries are queued for new subscribers
+// before entries are dropped
+func (w *LogMonitor) SetStreamBufferSize(size int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.streamBufferSize = size
+}
+
+// TakeDropped returns the number of entries dropped for a subscriber that
+// have not been reported with a dropped entry yet, and resets it. Use it when
+// ch is empty, there are no newer entries queued when it returns more than 0.
+func (w *LogMonitor) TakeDropped(ch chan LogEntry) int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	subscriber, found := w.clients[ch]
+	if !found {
+		return 0
+	}
+	dropped := subscriber.dropped
+	subscriber.dropped = 0
+	return dropped
+}
+
+func (w *LogMonitor) Unsubscribe(ch chan LogEntry) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
@@ -98,44 +378,78 @@ func (w *LogMonitor) Unsubscribe(ch chan []byte) {
 	close(ch)
 }
 
-func (w *LogMonitor) broadcast(msg []byte) {
-	w.mu.RLock()
-	defer w.mu.RUnlock()
+func (w *LogMonitor) broadcast(entry LogEntry) {
+	// not a read lock, the subscribers' dropped counts are updated
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for client, subscriber := range w.clients {
+		if !subscriber.filter.Match(entry) {
+			continue
+		}
+
+		// tell the subscriber about the gap before sending newer entries
+		if subscriber.dropped > 0 {
+			select {
+			case client <- droppedLogEntry(subscriber.dropped):
+				subscriber.dropped = 0
+			default:
+				subscriber.dropped++
+				continue
+			}
+		}
 
-	for client := range w.clients {
 		select {
-		case client <- msg:
+		case client <- entry:
 		default:
-			// If client buffer is full, skip
+			// If client buffer is full, skip and count it
+			subscriber.dropped++
 		}
 	}
 }
 
-func (w *LogMonitor) SetPrefix(prefix string) {
+// SetSource sets the source of lines written to the monitor, "proxy" or "upstream"
+func (w *LogMonitor) SetSource(source string) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
-	w.prefix = prefix
+	w.source = source
 }
 
-func (w *LogMonitor) SetLogLevel(level LogLevel) {
+// SetModel sets the model of lines written to the monitor. The lines are
+// tagged with the model when they are passed on to stdout.
+func (w *LogMonitor) SetModel(model string) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
-	w.level = level
+	w.model = model
 }
 
-func (w *LogMonitor) formatMessage(level string, msg string) []byte {
-	prefix := ""
-	if w.prefix != "" {
-		prefix = fmt.Sprintf("[%s] ", w.prefix)
-	}
-	return []byte(fmt.Sprintf("%s[%s] %s\n", prefix, level, msg))
+// SetPrefix tags the lines passed on to stdout with prefix.
+//
+// Deprecated: lines are now tagged with their model, use SetModel.
+func (w *LogMonitor) SetPrefix(prefix string) {
+	w.SetModel(prefix)
+}
+
+func (w *LogMonitor) SetLogLevel(level LogLevel) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.level = level
 }
 
 func (w *LogMonitor) log(level LogLevel, msg string) {
-	if level < w.level {
+	w.mu.RLock()
+	minLevel := w.level
+	w.mu.RUnlock()
+
+	if level < minLevel {
 		return
 	}
-	w.Write(w.formatMessage(level.String(), msg))
+	w.add(LogEntry{
+		Time:        time.Now(),
+		Level:       level.Name(),
+		Message:     msg,
+		levelTagged: true,
+	})
 }
 
 func (w *LogMonitor) Debug(msg string) {
@@ -184,3 +498,8 @@ func (l LogLevel) String() string {
 		return "UNKNOWN"
 	}
 }
+
+// Name is the lower case name used in LogEntry.Level
+func (l LogLevel) Name() string {
+	return strings.ToLower(l.String())
+}
@@ -2,9 +2,14 @@ package proxy
 
 import (
 	"bytes"
+	"encoding/json"
+	"fmt"
 	"io"
 	"sync"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestLogMonitor(t *testing.T) {
@@ -28,24 +33,24 @@ func TestLogMonitor(t *testing.T) {
 		for {
 			select {
 			case data := <-client1:
-				client1Messages = append(client1Messages, data...)
+				client1Messages = append(client1Messages, logMonitor.Format(data)...)
 			case data := <-client2:
-				client2Messages = append(client2Messages, data...)
+				client2Messages = append(client2Messages, logMonitor.Format(data)...)
 			default:
 				return
 			}
 		}
 	}()
 
-	logMonitor.Write([]byte("1"))
-	logMonitor.Write([]byte("2"))
-	logMonitor.Write([]byte("3"))
+	logMonitor.Write([]byte("1\n"))
+	logMonitor.Write([]byte("2\n"))
+	logMonitor.Write([]byte("3\n"))
 
 	// Wait for the goroutine to finish
 	wg.Wait()
 
 	// Check the buffer
-	expectedHistory := "123"
+	expectedHistory := "1\n2\n3\n"
 	history := string(logMonitor.GetHistory())
 
 	if history != expectedHistory {
@@ -93,3 +98,145 @@ func TestWrite_ImmutableBuffer(t *testing.T) {
 		t.Errorf("Expected history to be %q, got %q", expected, history)
 	}
 }
+
+func TestLogMonitor_Model(t *testing.T) {
+	var parent bytes.Buffer
+	lm := NewLogMonitorWriter(&parent)
+	lm.SetModel("model1")
+
+	// lines are tagged even when they are split across writes
+	lm.Write([]byte("line 1\nline"))
+	lm.Write([]byte(" 2\n"))
+	lm.Write([]byte("line 3\n"))
+
+	if got, want := parent.String(), "[model1] line 1\n[model1] line 2\n[model1] line 3\n"; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+
+	// the monitor's own history is not tagged
+	if got, want := string(lm.GetHistory()), "line 1\nline 2\nline 3\n"; got != want {
+		t.Errorf("Expected history %q, got %q", want, got)
+	}
+}
+
+func TestLogMonitor_SetPrefix(t *testing.T) {
+	var parent bytes.Buffer
+	lm := NewLogMonitorWriter(&parent)
+	lm.SetPrefix("model1")
+	lm.Write([]byte("line 1\n"))
+	assert.Equal(t, "[model1] line 1\n", parent.String())
+}
+
+func TestLogMonitor_PartialLineFlushed(t *testing.T) {
+	lm := NewLogMonitorWriter(io.Discard)
+	lm.Write([]byte("loading"))
+	lm.Write([]byte("..."))
+	assert.Empty(t, lm.GetEntries())
+
+	// a line without a newline is added after a while
+	assert.Eventually(t, func() bool {
+		return len(lm.GetEntries()) == 1
+	}, 3*partialLineTimeout, 10*time.Millisecond)
+	assert.Equal(t, "loading...", lm.GetEntries()[0].Message)
+
+	// the rest of the line is a new entry
+	lm.Write([]byte(" done\n"))
+	entries := lm.GetEntries()
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, " done", entries[1].Message)
+	}
+	assert.Equal(t, "loading...\n done\n", string(lm.GetHistory()))
+}
+
+func TestLogMonitor_Entries(t *testing.T) {
+	mux := NewLogMonitorWriter(io.Discard)
+	upstream := NewLogMonitorWriter(mux)
+	upstream.SetSource("upstream")
+	model := NewLogMonitorWriter(upstream)
+	model.SetSource("upstream")
+	model.SetModel("model1")
+	proxy := NewLogMonitorWriter(mux)
+	proxy.SetSource("proxy")
+
+	before := time.Now()
+	model.Write([]byte("loading\r\n[WARN] low memory\npartial"))
+	proxy.Errorf("failed %d", 1)
+
+	entries := mux.GetEntries()
+	if assert.Len(t, entries, 3) {
+		assert.Equal(t, LogEntry{Time: entries[0].Time, Level: "info", Source: "upstream", Model: "model1", Message: "loading"}, entries[0])
+		assert.Equal(t, "warn", entries[1].Level)
+		assert.Equal(t, "low memory", entries[1].Message)
+		assert.Equal(t, "error", entries[2].Level)
+		assert.Equal(t, "proxy", entries[2].Source)
+		assert.Empty(t, entries[2].Model)
+		assert.False(t, entries[0].Time.Before(before))
+	}
+
+	assert.Equal(t, "[model1] loading\n[model1] [WARN] low memory\n[ERROR] failed 1\n", string(mux.GetHistory()))
+
+	// the pending line is only in the model's history
+	assert.Equal(t, "loading\n[WARN] low memory\npartial", string(model.GetHistory()))
+
+	data, err := json.Marshal(entries[2])
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"time":"`+entries[2].Time.Format(time.RFC3339Nano)+`","level":"error","source":"proxy","message":"failed 1"}`, string(data))
+}
+
+func TestLogMonitor_BufferSize(t *testing.T) {
+	lm := NewLogMonitorWriter(io.Discard)
+	lm.SetBufferSize(3 * LogEntry{Message: "line 0", Level: "info"}.size())
+
+	for i := 0; i < 5; i++ {
+		fmt.Fprintf(lm, "line %d\n", i)
+	}
+	assert.Equal(t, "line 2\nline 3\nline 4\n", string(lm.GetHistory()))
+
+	lm.SetBufferSize(1)
+	assert.Empty(t, lm.GetEntries())
+}
+
+func TestLogMonitor_AddWriter(t *testing.T) {
+	upstream := NewLogMonitorWriter(io.Discard)
+	model := NewLogMonitorWriter(upstream)
+	model.SetModel("model1")
+
+	var upstreamFile, modelFile bytes.Buffer
+	upstream.AddWriter(&upstreamFile)
+	model.AddWriter(&modelFile)
+	model.AddWriter(&modelFile)
+
+	model.Write([]byte("[WARN] low memory\n"))
+	assert.Regexp(t, `^\d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{3}\S* \[WARN\] low memory\n$`, modelFile.String())
+	assert.Regexp(t, `^\S+ \[model1\] \[WARN\] low memory\n$`, upstreamFile.String())
+}
+
+func TestLogMonitor_Dropped(t *testing.T) {
+	lm := NewLogMonitorWriter(io.Discard)
+	lm.SetStreamBufferSize(2)
+	ch := lm.Subscribe()
+	defer lm.Unsubscribe(ch)
+
+	for i := 1; i <= 5; i++ {
+		fmt.Fprintf(lm, "line %d\n", i)
+	}
+	assert.Equal(t, "line 1", (<-ch).Message)
+
+	// the gap is reported before newer entries, line 6 does not fit after it
+	lm.Write([]byte("line 6\n"))
+	assert.Equal(t, "line 2", (<-ch).Message)
+	dropped := <-ch
+	assert.Equal(t, 3, dropped.Dropped)
+	assert.Equal(t, "[WARN] 3 log lines dropped, the client is not reading them fast enough\n", string(lm.Format(dropped)))
+
+	// once the channel is drained the remaining gap can be taken
+	entry, ok := drainedDroppedEntry(lm, ch)
+	assert.True(t, ok)
+	assert.Equal(t, 1, entry.Dropped)
+	assert.Equal(t, 0, lm.TakeDropped(ch))
+
+	lm.Write([]byte("line 7\n"))
+	assert.Equal(t, "line 7", (<-ch).Message)
+	_, ok = drainedDroppedEntry(lm, ch)
+	assert.False(t, ok)
+}
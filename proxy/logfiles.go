@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// logFiles are the rotated files the logs are written to
+type logFiles struct {
+	sync.Mutex
+	config LogFileConfig
+
+	// nil when not configured
+	proxy    *RotatingFile
+	upstream *RotatingFile
+
+	// key is the model ID, opened as models are added
+	models map[string]*RotatingFile
+}
+
+func newLogFiles(config LogFileConfig) (*logFiles, error) {
+	l := &logFiles{
+		config: config,
+		models: make(map[string]*RotatingFile),
+	}
+
+	var err error
+	if config.Proxy != "" {
+		if l.proxy, err = l.open(config.Proxy); err != nil {
+			return nil, err
+		}
+	}
+	if config.Upstream != "" {
+		if l.upstream, err = l.open(config.Upstream); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	if config.ModelDir != "" {
+		if err := os.MkdirAll(config.ModelDir, 0755); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+func (l *logFiles) open(path string) (*RotatingFile, error) {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	file, err := NewRotatingFile(path, l.config.maxSize(), l.config.maxBackups())
+	if err != nil {
+		return nil, err
+	}
+	file.SetMaxAge(l.config.maxAge())
+	file.SetCompress(l.config.Compress)
+	return file, nil
+}
+
+// model returns the file for a model, opening it the first time. It returns
+// nil when there are no per model files.
+func (l *logFiles) model(modelID string) (*RotatingFile, error) {
+	if l.config.ModelDir == "" {
+		return nil, nil
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	if file, found := l.models[modelID]; found {
+		return file, nil
+	}
+
+	// model IDs like org/model are not nested directories. IDs are escaped,
+	// not replaced, so different IDs never share a file.
+	name := url.QueryEscape(modelID) + ".log"
+	file, err := l.open(filepath.Join(l.config.ModelDir, name))
+	if err != nil {
+		return nil, err
+	}
+	l.models[modelID] = file
+	return file, nil
+}
+
+func (l *logFiles) Close() error {
+	l.Lock()
+	defer l.Unlock()
+
+	var firstErr error
+	files := []*RotatingFile{l.proxy, l.upstream}
+	for _, file := range l.models {
+		files = append(files, file)
+	}
+	for _, file := range files {
+		if file == nil {
+			continue
+		}
+		if err := file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// LogFilter selects log entries. Zero values match all entries.
+type LogFilter struct {
+	// real model ID
+	Model string
+
+	// entries at or above this level
+	Level LogLevel
+
+	// entries written in [Since, Until)
+	Since time.Time
+	Until time.Time
+
+	// entries with a message matching the regular expression
+	Grep *regexp.Regexp
+}
+
+// IsZero returns true if the filter matches all entries
+func (f LogFilter) IsZero() bool {
+	return f.Model == "" && f.Level == LevelDebug && f.Since.IsZero() && f.Until.IsZero() && f.Grep == nil
+}
+
+func (f LogFilter) Match(entry LogEntry) bool {
+	if f.Model != "" && entry.Model != f.Model {
+		return false
+	}
+	if f.Level > LevelDebug {
+		if level, ok := ParseLogLevel(entry.Level); ok && level < f.Level {
+			return false
+		}
+	}
+	if !f.Since.IsZero() && entry.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !entry.Time.Before(f.Until) {
+		return false
+	}
+	if f.Grep != nil && !f.Grep.MatchString(entry.Message) {
+		return false
+	}
+	return true
+}
+
+// Filter returns the entries matching the filter
+func (f LogFilter) Filter(entries []LogEntry) []LogEntry {
+	if f.IsZero() {
+		return entries
+	}
+
+	matched := make([]LogEntry, 0, len(entries))
+	for _, entry := range entries {
+		if f.Match(entry) {
+			matched = append(matched, entry)
+		}
+	}
+	return matched
+}
+
+// ParseLogLevel parses a level name, e.g. "warn" or "WARN"
+func ParseLogLevel(name string) (LogLevel, bool) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "debug":
+		return LevelDebug, true
+	case "info":
+		return LevelInfo, true
+	case "warn", "warning":
+		return LevelWarn, true
+	case "error":
+		return LevelError, true
+	default:
+		return LevelInfo, false
+	}
+}
+
+// parseLogTime parses an RFC 3339 time or a duration, e.g. 15m, before now
+func parseLogTime(value string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %s, use RFC 3339, e.g. 2006-01-02T15:04:05Z, or a duration, e.g. 15m", value)
+}
@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"io"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogFilter_Match(t *testing.T) {
+	now := time.Now()
+	entry := LogEntry{Time: now, Level: "warn", Source: "upstream", Model: "model1", Message: "low memory"}
+
+	assert.True(t, LogFilter{}.IsZero())
+	assert.True(t, LogFilter{}.Match(entry))
+
+	tests := []struct {
+		name   string
+		filter LogFilter
+		match  bool
+	}{
+		{"model", LogFilter{Model: "model1"}, true},
+		{"other model", LogFilter{Model: "model2"}, false},
+		{"lower level", LogFilter{Level: LevelInfo}, true},
+		{"same level", LogFilter{Level: LevelWarn}, true},
+		{"higher level", LogFilter{Level: LevelError}, false},
+		{"since", LogFilter{Since: now}, true},
+		{"since later", LogFilter{Since: now.Add(time.Second)}, false},
+		{"until", LogFilter{Until: now}, false},
+		{"until later", LogFilter{Until: now.Add(time.Second)}, true},
+		{"grep", LogFilter{Grep: regexp.MustCompile("mem(ory)?$")}, true},
+		{"grep no match", LogFilter{Grep: regexp.MustCompile("^memory")}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.False(t, tt.filter.IsZero())
+			assert.Equal(t, tt.match, tt.filter.Match(entry))
+		})
+	}
+}
+
+func TestParseLogTime(t *testing.T) {
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	since, err := parseLogTime("2025-01-02T03:00:00Z", now)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2025, 1, 2, 3, 0, 0, 0, time.UTC), since)
+
+	since, err = parseLogTime("15m", now)
+	assert.NoError(t, err)
+	assert.Equal(t, now.Add(-15*time.Minute), since)
+
+	_, err = parseLogTime("yesterday", now)
+	assert.ErrorContains(t, err, "invalid time yesterday")
+}
+
+func TestLogMonitor_SubscribeFilter(t *testing.T) {
+	lm := NewLogMonitorWriter(io.Discard)
+	ch := lm.SubscribeFilter(LogFilter{Level: LevelWarn})
+	defer lm.Unsubscribe(ch)
+
+	lm.Write([]byte("[INFO] one\n[WARN] two\nthree\n"))
+	lm.Errorf("four")
+
+	var messages []string
+	for len(ch) > 0 {
+		messages = append(messages, (<-ch).Message)
+	}
+	assert.Equal(t, []string{"two", "four"}, messages)
+}
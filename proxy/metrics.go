@@ -0,0 +1,343 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ginKeyModel is set by handlers to the real model name so the request
+// metrics middleware can label requests by model
+const ginKeyModel = "llama-swap.model"
+
+// default latency buckets in seconds, the same as the prometheus client defaults
+var defaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// model start buckets in seconds, loading large models can take minutes
+var startDurationBuckets = []float64{0.5, 1, 2.5, 5, 10, 20, 30, 60, 120, 300}
+
+// Metrics collects the counters and histograms exported on /metrics in the
+// Prometheus text format. Gauges for the current state of each model are
+// read from the processes when the metrics are scraped.
+type Metrics struct {
+	requests           *counterVec
+	requestDuration    *histogramVec
+	swaps              *counterVec
+	startDuration      *histogramVec
+	healthCheckFailure *counterVec
+	promptTokens       *counterVec
+	completionTokens   *counterVec
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requests: newCounterVec(
+			"llamaswap_requests_total",
+			"Number of HTTP requests handled.",
+			"model", "endpoint", "status",
+		),
+		requestDuration: newHistogramVec(
+			"llamaswap_request_duration_seconds",
+			"Time taken to handle HTTP requests, including starting the model.",
+			defaultLatencyBuckets,
+			"model", "endpoint", "status",
+		),
+		swaps: newCounterVec(
+			"llamaswap_model_swaps_total",
+			"Number of times a model was started and swapped in.",
+			"model",
+		),
+		startDuration: newHistogramVec(
+			"llamaswap_model_start_duration_seconds",
+			"Time taken for a model to start and pass its health check.",
+			startDurationBuckets,
+			"model",
+		),
+		healthCheckFailure: newCounterVec(
+			"llamaswap_health_check_failures_total",
+			"Number of model start attempts that failed the health check.",
+			"model",
+		),
+		promptTokens: newCounterVec(
+			"llamaswap_prompt_tokens_total",
+			"Number of prompt tokens reported by the upstream.",
+			"model",
+		),
+		completionTokens: newCounterVec(
+			"llamaswap_completion_tokens_total",
+			"Number of completion tokens reported by the upstream.",
+			"model",
+		),
+	}
+}
+
+// metrics is shared by all processes, like the default prometheus registry
+var metrics = NewMetrics()
+
+func (m *Metrics) observeRequest(model, endpoint string, status int, duration time.Duration) {
+	statusStr := strconv.Itoa(status)
+	m.requests.inc(model, endpoint, statusStr)
+	m.requestDuration.observe(duration.Seconds(), model, endpoint, statusStr)
+}
+
+func (m *Metrics) observeStart(model string, duration time.Duration) {
+	m.swaps.inc(model)
+	m.startDuration.observe(duration.Seconds(), model)
+}
+
+func (m *Metrics) observeHealthCheckFailure(model string) {
+	m.healthCheckFailure.inc(model)
+}
+
+func (m *Metrics) observeTokens(model string, usage TokenUsage) {
+	m.promptTokens.add(float64(usage.PromptTokens), model)
+	m.completionTokens.add(float64(usage.CompletionTokens), model)
+}
+
+// write writes all collected metrics in the Prometheus text format
+func (m *Metrics) write(w io.Writer) {
+	m.requests.write(w)
+	m.requestDuration.write(w)
+	m.swaps.write(w)
+	m.startDuration.write(w)
+	m.healthCheckFailure.write(w)
+	m.promptTokens.write(w)
+	m.completionTokens.write(w)
+}
+
+// metricsHandler serves the collected metrics along with the current state,
+// in-flight requests and queue depth of every model
+func (pm *ProxyManager) metricsHandler(c *gin.Context) {
+	c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.Status(200)
+	metrics.write(c.Writer)
+
+	states := []ProcessState{StateStopped, StateStarting, StateReady, StateStopping, StateFailed, StateShutdown}
+	stateGauge := newGaugeVec("llamaswap_model_state", "Current state of the model, 1 for the state it is in.", "model", "state")
+	inFlightGauge := newGaugeVec("llamaswap_in_flight_requests", "Number of requests being handled by the model.", "model")
+	queueGauge := newGaugeVec("llamaswap_queue_depth", "Number of requests waiting for a concurrency slot.", "model")
+
+	for _, processGroup := range pm.currentProcessGroups() {
+		for _, process := range processGroup.processes {
+			current := process.CurrentState()
+			for _, state := range states {
+				value := 0.0
+				if state == current {
+					value = 1
+				}
+				stateGauge.set(value, process.ID, string(state))
+			}
+			inFlightGauge.set(float64(process.InFlightRequests()), process.ID)
+			queueGauge.set(float64(process.QueueDepth()), process.ID)
+		}
+	}
+
+	stateGauge.write(c.Writer)
+	inFlightGauge.write(c.Writer)
+	queueGauge.write(c.Writer)
+}
+
+// metricSeries is a single set of label values of a metric
+type metricSeries struct {
+	labelValues []string
+	value       float64
+
+	// only used by histograms
+	bucketCounts []uint64
+	count        uint64
+}
+
+// metricVec is a metric partitioned by labels
+type metricVec struct {
+	sync.Mutex
+	name       string
+	help       string
+	metricType string
+	labelNames []string
+	series     map[string]*metricSeries
+}
+
+func newMetricVec(name, help, metricType string, labelNames []string) metricVec {
+	return metricVec{
+		name:       name,
+		help:       help,
+		metricType: metricType,
+		labelNames: labelNames,
+		series:     make(map[string]*metricSeries),
+	}
+}
+
+// getSeries returns the series for the label values, creating it if needed.
+// The caller must hold the lock.
+func (v *metricVec) getSeries(labelValues []string) *metricSeries {
+	if len(labelValues) != len(v.labelNames) {
+		panic(fmt.Sprintf("%s: expected %d label values, got %d", v.name, len(v.labelNames), len(labelValues)))
+	}
+
+	key := strings.Join(labelValues, "\xff")
+	s, ok := v.series[key]
+	if !ok {
+		s = &metricSeries{labelValues: append([]string(nil), labelValues...)}
+		v.series[key] = s
+	}
+	return s
+}
+
+// sortedSeries returns the series in a stable order. The caller must hold the lock.
+func (v *metricVec) sortedSeries() []*metricSeries {
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	series := make([]*metricSeries, len(keys))
+	for i, key := range keys {
+		series[i] = v.series[key]
+	}
+	return series
+}
+
+func (v *metricVec) writeHeader(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", v.name, v.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", v.name, v.metricType)
+}
+
+// formatLabels formats label pairs as {a="1",b="2"}, extra is appended after
+// the labels of the series
+func formatLabels(names, values []string, extra ...string) string {
+	if len(names) == 0 && len(extra) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(names)+len(extra)/2)
+	for i, name := range names {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, escapeLabelValue(values[i])))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extra[i], escapeLabelValue(extra[i+1])))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(value string) string {
+	return labelValueReplacer.Replace(value)
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+}
+
+type counterVec struct {
+	metricVec
+}
+
+func newCounterVec(name, help string, labelNames ...string) *counterVec {
+	return &counterVec{newMetricVec(name, help, "counter", labelNames)}
+}
+
+func (c *counterVec) inc(labelValues ...string) {
+	c.add(1, labelValues...)
+}
+
+func (c *counterVec) add(value float64, labelValues ...string) {
+	c.Lock()
+	defer c.Unlock()
+	c.getSeries(labelValues).value += value
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.writeHeader(w)
+	for _, s := range c.sortedSeries() {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labelNames, s.labelValues), formatFloat(s.value))
+	}
+}
+
+type gaugeVec struct {
+	metricVec
+}
+
+func newGaugeVec(name, help string, labelNames ...string) *gaugeVec {
+	return &gaugeVec{newMetricVec(name, help, "gauge", labelNames)}
+}
+
+func (g *gaugeVec) set(value float64, labelValues ...string) {
+	g.Lock()
+	defer g.Unlock()
+	g.getSeries(labelValues).value = value
+}
+
+func (g *gaugeVec) write(w io.Writer) {
+	g.Lock()
+	defer g.Unlock()
+
+	g.writeHeader(w)
+	for _, s := range g.sortedSeries() {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, formatLabels(g.labelNames, s.labelValues), formatFloat(s.value))
+	}
+}
+
+type histogramVec struct {
+	metricVec
+	buckets []float64
+}
+
+func newHistogramVec(name, help string, buckets []float64, labelNames ...string) *histogramVec {
+	return &histogramVec{
+		metricVec: newMetricVec(name, help, "histogram", labelNames),
+		buckets:   buckets,
+	}
+}
+
+func (h *histogramVec) observe(value float64, labelValues ...string) {
+	h.Lock()
+	defer h.Unlock()
+
+	s := h.getSeries(labelValues)
+	if s.bucketCounts == nil {
+		s.bucketCounts = make([]uint64, len(h.buckets))
+	}
+	for i, upperBound := range h.buckets {
+		if value <= upperBound {
+			s.bucketCounts[i]++
+		}
+	}
+	s.count++
+	s.value += value
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.Lock()
+	defer h.Unlock()
+
+	h.writeHeader(w)
+	for _, s := range h.sortedSeries() {
+		for i, upperBound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labelNames, s.labelValues, "le", formatFloat(upperBound)), s.bucketCounts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labelNames, s.labelValues, "le", "+Inf"), s.count)
+
+		labels := formatLabels(h.labelNames, s.labelValues)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, labels, formatFloat(s.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labels, s.count)
+	}
+}
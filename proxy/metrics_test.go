@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics_CounterVec(t *testing.T) {
+	c := newCounterVec("test_total", "A test counter.", "model", "status")
+	c.inc("b", "200")
+	c.inc("a", "200")
+	c.inc("a", "200")
+	c.inc(`quo"te`, "500")
+
+	var buf bytes.Buffer
+	c.write(&buf)
+	assert.Equal(t, `# HELP test_total A test counter.
+# TYPE test_total counter
+test_total{model="a",status="200"} 2
+test_total{model="b",status="200"} 1
+test_total{model="quo\"te",status="500"} 1
+`, buf.String())
+}
+
+func TestMetrics_HistogramVec(t *testing.T) {
+	h := newHistogramVec("test_seconds", "A test histogram.", []float64{1, 5}, "model")
+	h.observe(0.5, "a")
+	h.observe(2, "a")
+	h.observe(10, "a")
+
+	var buf bytes.Buffer
+	h.write(&buf)
+	assert.Equal(t, `# HELP test_seconds A test histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{model="a",le="1"} 1
+test_seconds_bucket{model="a",le="5"} 2
+test_seconds_bucket{model="a",le="+Inf"} 3
+test_seconds_sum{model="a"} 12.5
+test_seconds_count{model="a"} 3
+`, buf.String())
+}
+
+func TestMetrics_GaugeVecWithoutLabels(t *testing.T) {
+	g := newGaugeVec("test_gauge", "A test gauge.")
+	g.set(1.5)
+
+	var buf bytes.Buffer
+	g.write(&buf)
+	assert.Equal(t, "# HELP test_gauge A test gauge.\n# TYPE test_gauge gauge\ntest_gauge 1.5\n", buf.String())
+}
@@ -5,11 +5,14 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"net/url"
 	"os/exec"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
@@ -22,7 +25,8 @@ const (
 	StateReady    ProcessState = ProcessState("ready")
 	StateStopping ProcessState = ProcessState("stopping")
 
-	// failed a health check on start and will not be recovered
+	// failed a health check on start. It is recovered to stopped after the
+	// model's failureCooldown or when it is reset manually
 	StateFailed ProcessState = ProcessState("failed")
 
 	// process is shutdown and will not be restarted
@@ -34,8 +38,30 @@ type Process struct {
 	config ModelConfig
 	cmd    *exec.Cmd
 
-	// for p.cmd.Wait() select { ... }
-	cmdWaitChan chan error
+	// closed when the current cmd exits, cmdExitErr holds the result of cmd.Wait()
+	cmdDone    chan struct{}
+	cmdExitErr error
+
+	// number of times the process was restarted after crashing without
+	// successfully handling a request in between
+	crashRestarts int
+
+	// restarts the process after a crash following its group's swap,
+	// exclusive and resource rules. When nil the process is started directly.
+	restartFunc func() error
+
+	// cancels a restart waiting for its backoff, set while waiting
+	cancelRestart context.CancelFunc
+
+	// reason and time of the last failed start
+	lastFailure     string
+	lastFailureTime time.Time
+
+	// how long the last successful start took
+	lastStartDuration time.Duration
+
+	// token usage of requests handled by the process
+	usage modelUsage
 
 	processLogger *LogMonitor
 	proxyLogger   *LogMonitor
@@ -49,6 +75,10 @@ type Process struct {
 	state      ProcessState
 
 	inFlightRequests sync.WaitGroup
+	inFlightCount    atomic.Int32
+
+	// limits concurrent requests to the upstream, nil when unlimited
+	requestQueue *requestQueue
 
 	// used to block on multiple start() calls
 	waitStarting sync.WaitGroup
@@ -56,15 +86,27 @@ type Process struct {
 	// for managing shutdown state
 	shutdownCtx    context.Context
 	shutdownCancel context.CancelFunc
+
+	// processes retired by a config reload that have to be shut down before
+	// this one starts so they don't hold the same port and memory. That is
+	// the process this one replaced and, in a swap group, the group's other
+	// retired members.
+	waitFor []*Process
 }
 
 func NewProcess(ID string, healthCheckTimeout int, config ModelConfig, processLogger *LogMonitor, proxyLogger *LogMonitor) *Process {
 	ctx, cancel := context.WithCancel(context.Background())
+
+	var queue *requestQueue
+	if config.Concurrency > 0 {
+		queue = newRequestQueue(config.Concurrency, config.queueSize())
+	}
+
 	return &Process{
 		ID:                      ID,
+		requestQueue:            queue,
 		config:                  config,
 		cmd:                     nil,
-		cmdWaitChan:             make(chan error, 1),
 		processLogger:           processLogger,
 		proxyLogger:             proxyLogger,
 		healthCheckTimeout:      healthCheckTimeout,
@@ -118,8 +160,10 @@ func isValidTransition(from, to ProcessState) bool {
 		return to == StateStopping
 	case StateStopping:
 		return to == StateStopped || to == StateShutdown
-	case StateFailed, StateShutdown:
-		return false // No transitions allowed from these states
+	case StateFailed:
+		return to == StateStopped
+	case StateShutdown:
+		return false // No transitions allowed from this state
 	}
 	return false
 }
@@ -166,30 +210,166 @@ func (p *Process) start() error {
 	p.waitStarting.Add(1)
 	defer p.waitStarting.Done()
 
+	// Retire() or Shutdown() takes care of the state
+	if err := p.waitForRetired(); err != nil {
+		return err
+	}
+
+	startTime := time.Now()
+	startAttempts := p.config.startAttempts()
+	var cmdDone chan struct{}
+	for attempt := 1; attempt <= startAttempts; attempt++ {
+		cmdDone, err = p.startCommand(args)
+		if err == nil {
+			break
+		}
+
+		// Shutdown() takes care of the state, the cmd may have been started
+		// after it was stopped
+		if p.shutdownCtx.Err() != nil {
+			p.stopFailedCommand(cmdDone)
+			return err
+		}
+
+		// don't leave a cmd that failed its health check running
+		p.stopFailedCommand(cmdDone)
+
+		if attempt < startAttempts {
+			p.proxyLogger.Warnf("<%s> start attempt %d of %d failed: %v", p.ID, attempt, startAttempts, err)
+		}
+	}
+
+	if err != nil {
+		p.stateMutex.Lock()
+		p.lastFailure = err.Error()
+		p.lastFailureTime = time.Now()
+		p.stateMutex.Unlock()
+
+		if curState, swapErr := p.swapState(StateStarting, StateFailed); swapErr != nil {
+			return fmt.Errorf("%v AND state swap failed: %v, current state: %v", err, swapErr, curState)
+		}
+
+		if cooldown := p.config.FailureCooldown; cooldown > 0 {
+			go p.recoverAfterCooldown(time.Duration(cooldown) * time.Second)
+		}
+
+		return err
+	}
+
+	if p.config.UnloadAfter > 0 {
+		// start a goroutine to check every second if
+		// the process should be stopped
+		go func() {
+			maxDuration := time.Duration(p.config.UnloadAfter) * time.Second
+
+			for range time.Tick(time.Second) {
+				if p.CurrentState() != StateReady {
+					return
+				}
+
+				// wait for all inflight requests to complete and ticker
+				p.inFlightRequests.Wait()
+
+				if time.Since(p.LastRequestHandled()) > maxDuration {
+					p.proxyLogger.Infof("<%s> Unloading model, TTL of %ds reached", p.ID, p.config.UnloadAfter)
+					p.Stop()
+					return
+				}
+			}
+		}()
+	}
+
+	// the TTL counts from when the process is ready if it has not handled a request
+	startDuration := time.Since(startTime)
+	p.stateMutex.Lock()
+	p.lastStartDuration = startDuration
+	p.lastRequestHandled = time.Now()
+	p.stateMutex.Unlock()
+	metrics.observeStart(p.ID, startDuration)
+
+	if curState, err := p.swapState(StateStarting, StateReady); err != nil {
+		return fmt.Errorf("failed to set Process state to ready: current state: %v, error: %v", curState, err)
+	} else {
+		go p.monitorCrash(cmdDone)
+		return nil
+	}
+}
+
+// waitForRetired waits for the retired processes this one has to wait for
+// to shut down. A process that was retired before it started is followed to
+// the ones it was waiting for, which may still be running.
+func (p *Process) waitForRetired() error {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	seen := make(map[*Process]bool)
+	pending := p.retiredProcesses()
+	for len(pending) > 0 {
+		retired := pending[0]
+		pending = pending[1:]
+		if seen[retired] {
+			continue
+		}
+		seen[retired] = true
+		pending = append(pending, retired.retiredProcesses()...)
+
+		if retired.CurrentState() != StateShutdown {
+			p.proxyLogger.Infof("<%s> waiting for retired process %s to stop", p.ID, retired.ID)
+		}
+
+		for retired.CurrentState() != StateShutdown {
+			select {
+			case <-p.shutdownCtx.Done():
+				return errors.New("waiting for the retired process interrupted due to shutdown")
+			case <-ticker.C:
+			}
+		}
+	}
+
+	p.stateMutex.Lock()
+	p.waitFor = nil
+	p.stateMutex.Unlock()
+	return nil
+}
+
+// waitForRetiredProcesses makes the next start wait for the retired
+// processes to shut down
+func (p *Process) waitForRetiredProcesses(retired ...*Process) {
+	p.stateMutex.Lock()
+	defer p.stateMutex.Unlock()
+	p.waitFor = append(p.waitFor, retired...)
+}
+
+func (p *Process) retiredProcesses() []*Process {
+	p.stateMutex.RLock()
+	defer p.stateMutex.RUnlock()
+	return append([]*Process(nil), p.waitFor...)
+}
+
+// startCommand runs the upstream command and waits for it to pass the health
+// check. It returns the channel that is closed when the command exits.
+func (p *Process) startCommand(args []string) (chan struct{}, error) {
 	p.cmd = exec.Command(args[0], args[1:]...)
 	p.cmd.Stdout = p.processLogger
 	p.cmd.Stderr = p.processLogger
 	p.cmd.Env = p.config.Env
 
-	err = p.cmd.Start()
+	cmdDone := make(chan struct{})
+	p.stateMutex.Lock()
+	p.cmdDone = cmdDone
+	p.stateMutex.Unlock()
 
-	// Set process state to failed
-	if err != nil {
-		if curState, swapErr := p.swapState(StateStarting, StateFailed); swapErr != nil {
-			return fmt.Errorf(
-				"failed to start command and state swap failed. command error: %v, current state: %v, state swap error: %v",
-				err, curState, swapErr,
-			)
-		}
-		return fmt.Errorf("start() failed: %v", err)
+	if err := p.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("start() failed: %v", err)
 	}
 
 	// Capture the exit error for later signaling
-	go func() {
-		exitErr := p.cmd.Wait()
+	go func(cmd *exec.Cmd) {
+		exitErr := cmd.Wait()
 		p.proxyLogger.Debugf("<%s> cmd.Wait() returned error: %v", p.ID, exitErr)
-		p.cmdWaitChan <- exitErr
-	}()
+		p.cmdExitErr = exitErr
+		close(cmdDone)
+	}(p.cmd)
 
 	// One of three things can happen at this stage:
 	// 1. The command exits unexpectedly
@@ -204,103 +384,304 @@ func (p *Process) start() error {
 	checkEndpoint := strings.TrimSpace(p.config.CheckEndpoint)
 
 	// a "none" means don't check for health ... I could have picked a better word :facepalm:
-	if checkEndpoint != "none" {
-		// keep default behaviour
-		if checkEndpoint == "" {
-			checkEndpoint = "/health"
-		}
+	if checkEndpoint == "none" {
+		return cmdDone, nil
+	}
 
-		proxyTo := p.config.Proxy
-		healthURL, err := url.JoinPath(proxyTo, checkEndpoint)
-		if err != nil {
-			return fmt.Errorf("failed to create health check URL proxy=%s and checkEndpoint=%s", proxyTo, checkEndpoint)
-		}
+	// keep default behaviour
+	if checkEndpoint == "" {
+		checkEndpoint = "/health"
+	}
 
-		checkDeadline, cancelHealthCheck := context.WithDeadline(
-			context.Background(),
-			checkStartTime.Add(maxDuration),
-		)
-		defer cancelHealthCheck()
+	proxyTo := p.config.Proxy
+	healthURL, err := url.JoinPath(proxyTo, checkEndpoint)
+	if err != nil {
+		return cmdDone, fmt.Errorf("failed to create health check URL proxy=%s and checkEndpoint=%s", proxyTo, checkEndpoint)
+	}
 
-	loop:
-		// Ready Check loop
-		for {
-			select {
-			case <-checkDeadline.Done():
-				if curState, err := p.swapState(StateStarting, StateFailed); err != nil {
-					return fmt.Errorf("health check timed out after %vs AND state swap failed: %v, current state: %v", maxDuration.Seconds(), err, curState)
-				} else {
-					return fmt.Errorf("health check timed out after %vs", maxDuration.Seconds())
-				}
-			case <-p.shutdownCtx.Done():
-				return errors.New("health check interrupted due to shutdown")
-			case exitErr := <-p.cmdWaitChan:
-				if exitErr != nil {
-					p.proxyLogger.Warnf("<%s> upstream command exited prematurely with error: %v", p.ID, exitErr)
-					if curState, err := p.swapState(StateStarting, StateFailed); err != nil {
-						return fmt.Errorf("upstream command exited unexpectedly: %s AND state swap failed: %v, current state: %v", exitErr.Error(), err, curState)
-					} else {
-						return fmt.Errorf("upstream command exited unexpectedly: %s", exitErr.Error())
-					}
-				} else {
-					p.proxyLogger.Warnf("<%s> upstream command exited prematurely but successfully", p.ID)
-					if curState, err := p.swapState(StateStarting, StateFailed); err != nil {
-						return fmt.Errorf("upstream command exited prematurely but successfully AND state swap failed: %v, current state: %v", err, curState)
-					} else {
-						return fmt.Errorf("upstream command exited prematurely but successfully")
-					}
-				}
-			default:
-				if err := p.checkHealthEndpoint(healthURL); err == nil {
-					p.proxyLogger.Infof("<%s> Health check passed on %s", p.ID, healthURL)
-					cancelHealthCheck()
-					break loop
+	checkDeadline, cancelHealthCheck := context.WithDeadline(
+		context.Background(),
+		checkStartTime.Add(maxDuration),
+	)
+	defer cancelHealthCheck()
+
+	// Ready Check loop
+	for {
+		select {
+		case <-checkDeadline.Done():
+			metrics.observeHealthCheckFailure(p.ID)
+			return cmdDone, fmt.Errorf("health check timed out after %vs", maxDuration.Seconds())
+		case <-p.shutdownCtx.Done():
+			return cmdDone, errors.New("health check interrupted due to shutdown")
+		case <-cmdDone:
+			// Shutdown() stops the cmd, that is not a premature exit
+			if p.shutdownCtx.Err() != nil {
+				return cmdDone, errors.New("health check interrupted due to shutdown")
+			}
+
+			metrics.observeHealthCheckFailure(p.ID)
+			exitErr := p.cmdExitErr
+			if exitErr != nil {
+				p.proxyLogger.Warnf("<%s> upstream command exited prematurely with error: %v", p.ID, exitErr)
+				return cmdDone, fmt.Errorf("upstream command exited unexpectedly: %s", exitErr.Error())
+			} else {
+				p.proxyLogger.Warnf("<%s> upstream command exited prematurely but successfully", p.ID)
+				return cmdDone, fmt.Errorf("upstream command exited prematurely but successfully")
+			}
+		default:
+			if err := p.checkHealthEndpoint(healthURL); err == nil {
+				p.proxyLogger.Infof("<%s> Health check passed on %s", p.ID, healthURL)
+				return cmdDone, nil
+			} else {
+				if strings.Contains(err.Error(), "connection refused") {
+					endTime, _ := checkDeadline.Deadline()
+					ttl := time.Until(endTime)
+					p.proxyLogger.Infof("<%s> Connection refused on %s, giving up in %.0fs", p.ID, healthURL, ttl.Seconds())
 				} else {
-					if strings.Contains(err.Error(), "connection refused") {
-						endTime, _ := checkDeadline.Deadline()
-						ttl := time.Until(endTime)
-						p.proxyLogger.Infof("<%s> Connection refused on %s, giving up in %.0fs", p.ID, healthURL, ttl.Seconds())
-					} else {
-						p.proxyLogger.Infof("<%s> Health check error on %s, %v", p.ID, healthURL, err)
-					}
+					p.proxyLogger.Infof("<%s> Health check error on %s, %v", p.ID, healthURL, err)
 				}
 			}
+		}
 
-			<-time.After(p.healthCheckLoopInterval)
+		select {
+		case <-p.shutdownCtx.Done():
+			return cmdDone, errors.New("health check interrupted due to shutdown")
+		case <-time.After(p.healthCheckLoopInterval):
 		}
 	}
+}
 
-	if p.config.UnloadAfter > 0 {
-		// start a goroutine to check every second if
-		// the process should be stopped
-		go func() {
-			maxDuration := time.Duration(p.config.UnloadAfter) * time.Second
+// stopFailedCommand stops a cmd that was started but did not pass its health check
+func (p *Process) stopFailedCommand(cmdDone chan struct{}) {
+	if cmdDone == nil {
+		return
+	}
 
-			for range time.Tick(time.Second) {
-				if p.CurrentState() != StateReady {
-					return
-				}
+	select {
+	case <-cmdDone:
+	default:
+		p.stopCommand(5 * time.Second)
+	}
+}
 
-				// wait for all inflight requests to complete and ticker
-				p.inFlightRequests.Wait()
+// recoverAfterCooldown moves a failed process back to stopped after cooldown
+// so the next request will try to start it again
+func (p *Process) recoverAfterCooldown(cooldown time.Duration) {
+	p.proxyLogger.Infof("<%s> start failed, will recover in %v", p.ID, cooldown)
 
-				if time.Since(p.lastRequestHandled) > maxDuration {
-					p.proxyLogger.Infof("<%s> Unloading model, TTL of %ds reached", p.ID, p.config.UnloadAfter)
-					p.Stop()
-					return
-				}
-			}
-		}()
+	select {
+	case <-p.shutdownCtx.Done():
+		return
+	case <-time.After(cooldown):
 	}
 
-	if curState, err := p.swapState(StateStarting, StateReady); err != nil {
-		return fmt.Errorf("failed to set Process state to ready: current state: %v, error: %v", curState, err)
+	// it may have already been reset manually
+	if p.CurrentState() != StateFailed {
+		return
+	}
+
+	if err := p.Reset(); err != nil {
+		p.proxyLogger.Warnf("<%s> unable to recover after cooldown: %v", p.ID, err)
+	}
+}
+
+// cooldownRemaining returns how long until a failed process recovers after
+// its failureCooldown. It returns false if the process is not failed or
+// does not recover by itself.
+func (p *Process) cooldownRemaining() (time.Duration, bool) {
+	p.stateMutex.RLock()
+	defer p.stateMutex.RUnlock()
+
+	if p.state != StateFailed || p.config.FailureCooldown <= 0 {
+		return 0, false
+	}
+	cooldown := time.Duration(p.config.FailureCooldown) * time.Second
+	return time.Until(p.lastFailureTime.Add(cooldown)), true
+}
+
+// retryAfterSeconds formats d for a Retry-After header, rounded up to whole
+// seconds and at least 1
+func retryAfterSeconds(d time.Duration) string {
+	return strconv.Itoa(max(1, int(math.Ceil(d.Seconds()))))
+}
+
+// Reset moves a process in the failed state back to stopped so it can be
+// started again.
+func (p *Process) Reset() error {
+	if curState, err := p.swapState(StateFailed, StateStopped); err != nil {
+		return fmt.Errorf("unable to reset process in state %s: %v", curState, err)
+	}
+
+	p.proxyLogger.Infof("<%s> recovered from failed state", p.ID)
+	return nil
+}
+
+// LastStartDuration returns how long the last successful start took
+func (p *Process) LastStartDuration() time.Duration {
+	p.stateMutex.RLock()
+	defer p.stateMutex.RUnlock()
+	return p.lastStartDuration
+}
+
+// TTLRemaining returns how long until the process is unloaded for being idle.
+// It returns false if the process has no TTL or is not ready.
+func (p *Process) TTLRemaining() (time.Duration, bool) {
+	if p.config.UnloadAfter <= 0 || p.CurrentState() != StateReady {
+		return 0, false
+	}
+
+	ttl := time.Duration(p.config.UnloadAfter) * time.Second
+	if p.InFlightRequests() > 0 {
+		return ttl, true
+	}
+
+	remaining := ttl - time.Since(p.LastRequestHandled())
+	if remaining < 0 {
+		remaining = 0
+	}
+	return remaining, true
+}
+
+// Usage returns the token usage of requests handled by the process
+func (p *Process) Usage() UsageTotals {
+	return p.usage.get()
+}
+
+// LastRequestHandled returns when the last request to the process completed
+func (p *Process) LastRequestHandled() time.Time {
+	p.stateMutex.RLock()
+	defer p.stateMutex.RUnlock()
+	return p.lastRequestHandled
+}
+
+// InFlightRequests returns the number of requests currently being handled,
+// including requests waiting in the queue or for the process to start
+func (p *Process) InFlightRequests() int {
+	return int(p.inFlightCount.Load())
+}
+
+// QueueDepth returns the number of requests waiting for the upstream to be
+// available. It is always 0 when the model's concurrency is unlimited.
+func (p *Process) QueueDepth() int {
+	if p.requestQueue == nil {
+		return 0
+	}
+	return p.requestQueue.depth()
+}
+
+// LastFailure returns the reason and time of the last failed start. The
+// reason is empty if the process has never failed to start.
+func (p *Process) LastFailure() (string, time.Time) {
+	p.stateMutex.RLock()
+	defer p.stateMutex.RUnlock()
+	return p.lastFailure, p.lastFailureTime
+}
+
+// monitorCrash watches for the upstream command exiting while the process
+// is Ready. When that happens the process is moved to the stopped state so the
+// next request starts it again. Depending on the model's restart policy it
+// may also be restarted right away.
+func (p *Process) monitorCrash(cmdDone chan struct{}) {
+	select {
+	case <-p.shutdownCtx.Done():
+		return
+	case <-cmdDone:
+	}
+
+	// a Stop() in progress, or a cmd from a previous start, is not a crash
+	p.stateMutex.Lock()
+	if p.state != StateReady || p.cmdDone != cmdDone {
+		p.stateMutex.Unlock()
+		return
+	}
+	p.state = StateStopping
+	p.stateMutex.Unlock()
+
+	exitErr := p.cmdExitErr
+	if exitError, ok := exitErr.(*exec.ExitError); ok {
+		p.proxyLogger.Errorf("<%s> upstream command crashed: %v, exit code: %d", p.ID, exitError, exitError.ExitCode())
+	} else if exitErr != nil {
+		p.proxyLogger.Errorf("<%s> upstream command crashed: %v", p.ID, exitErr)
 	} else {
-		return nil
+		p.proxyLogger.Warnf("<%s> upstream command exited unexpectedly, exit code: 0", p.ID)
+	}
+
+	if curState, err := p.swapState(StateStopping, StateStopped); err != nil {
+		p.proxyLogger.Infof("<%s> monitorCrash() StateStopping -> StateStopped err: %v, current state: %v", p.ID, err, curState)
+		return
+	}
+
+	restart := p.config.Restart
+	if restart.Policy != RESTART_ON_FAILURE || exitErr == nil {
+		return
 	}
+
+	p.stateMutex.Lock()
+	attempt := p.crashRestarts
+	if attempt < restart.maxRetries() {
+		p.crashRestarts++
+	}
+	p.stateMutex.Unlock()
+
+	if attempt >= restart.maxRetries() {
+		p.proxyLogger.Errorf("<%s> not restarting, %d restarts attempted", p.ID, attempt)
+		return
+	}
+
+	delay := restart.backoff() * time.Duration(1<<attempt)
+	p.proxyLogger.Infof("<%s> restarting in %v, attempt %d of %d", p.ID, delay, attempt+1, restart.maxRetries())
+
+	// Stop(), e.g. when another model is swapped in, cancels the restart
+	restartCtx, cancel := context.WithCancel(p.shutdownCtx)
+	defer cancel()
+	p.stateMutex.Lock()
+	p.cancelRestart = cancel
+	restartFunc := p.restartFunc
+	p.stateMutex.Unlock()
+
+	select {
+	case <-restartCtx.Done():
+		p.proxyLogger.Debugf("<%s> restart after crash cancelled", p.ID)
+		return
+	case <-time.After(delay):
+	}
+
+	p.stateMutex.Lock()
+	p.cancelRestart = nil
+	p.stateMutex.Unlock()
+
+	// a request may have already started it on demand
+	if p.CurrentState() != StateStopped {
+		return
+	}
+
+	var err error
+	if restartFunc != nil {
+		err = restartFunc()
+	} else {
+		err = p.start()
+	}
+	if err != nil {
+		p.proxyLogger.Errorf("<%s> restart after crash failed: %v", p.ID, err)
+	}
+}
+
+// setRestartFunc sets how the process is restarted after a crash
+func (p *Process) setRestartFunc(restartFunc func() error) {
+	p.stateMutex.Lock()
+	defer p.stateMutex.Unlock()
+	p.restartFunc = restartFunc
 }
 
 func (p *Process) Stop() {
+	// a stopped process may be waiting to restart after a crash
+	p.stateMutex.Lock()
+	if p.cancelRestart != nil {
+		p.cancelRestart()
+		p.cancelRestart = nil
+	}
+	p.stateMutex.Unlock()
+
 	if !isValidTransition(p.CurrentState(), StateStopping) {
 		return
 	}
@@ -323,6 +704,33 @@ func (p *Process) Stop() {
 	}
 }
 
+// Retire stops a process that was removed or replaced by a config reload.
+// Like Stop() it waits for in-flight requests, except when the process is
+// still starting, then it is stopped right away. A retired process is shut
+// down and can not be started again.
+func (p *Process) Retire() {
+	// the count is polled, a request may still be added when the reload
+	// raced with it and inFlightRequests.Wait() must not run concurrently
+	// with an Add() from zero
+	for p.CurrentState() != StateStarting && p.inFlightCount.Load() > 0 {
+		time.Sleep(100 * time.Millisecond)
+	}
+	p.proxyLogger.Debugf("<%s> Retiring process", p.ID)
+
+	// a start in progress is interrupted and stops its own cmd
+	p.shutdownCancel()
+	p.waitStarting.Wait()
+
+	switch p.CurrentState() {
+	case StateReady, StateStopping:
+		p.stopCommand(5 * time.Second)
+	}
+
+	p.stateMutex.Lock()
+	p.state = StateShutdown
+	p.stateMutex.Unlock()
+}
+
 // Shutdown is called when llama-swap is shutting down. It will give a little bit
 // of time for any inflight requests to complete before shutting down. If the Process
 // is in the state of starting, it will cancel it and shut it down
@@ -332,6 +740,23 @@ func (p *Process) Shutdown() {
 	p.state = StateShutdown
 }
 
+// Kill stops the process right away with a SIGKILL, without giving it time
+// to exit gracefully. It is used when llama-swap has to exit immediately.
+func (p *Process) Kill() {
+	p.shutdownCancel()
+	if p.cmd == nil || p.cmd.Process == nil {
+		return
+	}
+
+	p.proxyLogger.Debugf("<%s> Killing process", p.ID)
+	p.cmd.Process.Kill()
+	select {
+	case <-p.cmdDone:
+	case <-time.After(time.Second):
+		p.proxyLogger.Warnf("<%s> Process did not exit after KILL signal", p.ID)
+	}
+}
+
 // stopCommand will send a SIGTERM to the process and wait for it to exit.
 // If it does not exit within 5 seconds, it will send a SIGKILL.
 func (p *Process) stopCommand(sigtermTTL time.Duration) {
@@ -356,11 +781,10 @@ func (p *Process) stopCommand(sigtermTTL time.Duration) {
 	case <-sigtermTimeout.Done():
 		p.proxyLogger.Infof("<%s> Process timed out waiting to stop, sending KILL signal", p.ID)
 		p.cmd.Process.Kill()
-	case err := <-p.cmdWaitChan:
-		// Note: in start(), p.cmdWaitChan also has a select { ... }. That should be OK
-		// because if we make it here then the cmd has been successfully running and made it
-		// through the health check. There is a possibility that ithe cmd crashed after the health check
-		// succeeded but that's not a case llama-swap is handling for now.
+	case <-p.cmdDone:
+		// cmdDone is closed so it can be waited on here, in start() and in
+		// monitorCrash() at the same time
+		err := p.cmdExitErr
 		if err != nil {
 			if errno, ok := err.(syscall.Errno); ok {
 				p.proxyLogger.Errorf("<%s> errno >> %v", p.ID, errno)
@@ -410,13 +834,20 @@ func (p *Process) ProxyRequest(w http.ResponseWriter, r *http.Request) {
 	// prevent new requests from being made while stopping or irrecoverable
 	currentState := p.CurrentState()
 	if currentState == StateFailed || currentState == StateShutdown || currentState == StateStopping {
+		if remaining, ok := p.cooldownRemaining(); ok {
+			w.Header().Set("Retry-After", retryAfterSeconds(remaining))
+		}
 		http.Error(w, fmt.Sprintf("Process can not ProxyRequest, state is %s", currentState), http.StatusServiceUnavailable)
 		return
 	}
 
 	p.inFlightRequests.Add(1)
+	p.inFlightCount.Add(1)
 	defer func() {
+		p.stateMutex.Lock()
 		p.lastRequestHandled = time.Now()
+		p.stateMutex.Unlock()
+		p.inFlightCount.Add(-1)
 		p.inFlightRequests.Done()
 	}()
 
@@ -429,6 +860,28 @@ func (p *Process) ProxyRequest(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		startDuration = time.Since(beginStartTime)
+		if rs := requestStatsFromContext(r.Context()); rs != nil {
+			rs.startDuration = startDuration
+		}
+	}
+
+	if p.requestQueue != nil {
+		queueTimeout := p.config.queueTimeout()
+		if err := p.requestQueue.acquire(r.Context(), queueTimeout); err != nil {
+			// a slot frees up within the queue timeout
+			retryAfter := retryAfterSeconds(queueTimeout)
+			switch err {
+			case ErrQueueFull:
+				w.Header().Set("Retry-After", retryAfter)
+				http.Error(w, fmt.Sprintf("too many requests for %s: %v", p.ID, err), http.StatusTooManyRequests)
+			case ErrQueueTimeout:
+				w.Header().Set("Retry-After", retryAfter)
+				http.Error(w, fmt.Sprintf("%s is busy: %v", p.ID, err), http.StatusServiceUnavailable)
+			}
+			// otherwise the client went away
+			return
+		}
+		defer p.requestQueue.release()
 	}
 
 	proxyTo := p.config.Proxy
@@ -452,6 +905,12 @@ func (p *Process) ProxyRequest(w http.ResponseWriter, r *http.Request) {
 	}
 	w.WriteHeader(resp.StatusCode)
 
+	// observes the response for token usage after it is sent to the client
+	var observer *usageObserver
+	if resp.StatusCode == http.StatusOK {
+		observer = newUsageObserver(resp.Header.Get("Content-Type"))
+	}
+
 	// faster than io.Copy when streaming
 	buf := make([]byte, 32*1024)
 	for {
@@ -463,6 +922,9 @@ func (p *Process) ProxyRequest(w http.ResponseWriter, r *http.Request) {
 			if flusher, ok := w.(http.Flusher); ok {
 				flusher.Flush()
 			}
+			if observer != nil {
+				observer.Write(buf[:n])
+			}
 		}
 		if err == io.EOF {
 			break
@@ -473,6 +935,24 @@ func (p *Process) ProxyRequest(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// the upstream is healthy again
+	if resp.StatusCode < http.StatusInternalServerError {
+		p.stateMutex.Lock()
+		p.crashRestarts = 0
+		p.stateMutex.Unlock()
+	}
+
+	if observer != nil {
+		if usage, found := observer.finish(); found {
+			p.usage.add(usage)
+			metrics.observeTokens(p.ID, usage)
+			if rs := requestStatsFromContext(r.Context()); rs != nil {
+				rs.found = true
+				rs.usage = usage
+			}
+		}
+	}
+
 	totalTime := time.Since(requestBeginTime)
 	p.proxyLogger.Debugf("<%s> request %s - start: %v, total: %v",
 		p.ID, r.RequestURI, startDuration, totalTime)
@@ -107,6 +107,17 @@ func TestProcess_BrokenModelConfig(t *testing.T) {
 	process.ProxyRequest(w, req)
 	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
 	assert.Contains(t, w.Body.String(), "Process can not ProxyRequest, state is failed")
+
+	// without a failureCooldown it only recovers when it is reset
+	assert.Empty(t, w.Header().Get("Retry-After"))
+}
+
+func TestProcess_RetryAfterSeconds(t *testing.T) {
+	assert.Equal(t, "1", retryAfterSeconds(0))
+	assert.Equal(t, "1", retryAfterSeconds(-time.Second))
+	assert.Equal(t, "1", retryAfterSeconds(300*time.Millisecond))
+	assert.Equal(t, "2", retryAfterSeconds(1100*time.Millisecond))
+	assert.Equal(t, "60", retryAfterSeconds(time.Minute))
 }
 
 func TestProcess_UnloadAfterTTL(t *testing.T) {
@@ -257,7 +268,7 @@ func TestProcess_SwapState(t *testing.T) {
 		{"Ready to Starting", StateReady, StateReady, StateStarting, ErrInvalidStateTransition, StateReady},
 		{"Ready to Failed", StateReady, StateReady, StateFailed, ErrInvalidStateTransition, StateReady},
 		{"Stopping to Ready", StateStopping, StateStopping, StateReady, ErrInvalidStateTransition, StateStopping},
-		{"Failed to Stopped", StateFailed, StateFailed, StateStopped, ErrInvalidStateTransition, StateFailed},
+		{"Failed to Stopped", StateFailed, StateFailed, StateStopped, nil, StateStopped},
 		{"Failed to Starting", StateFailed, StateFailed, StateStarting, ErrInvalidStateTransition, StateFailed},
 		{"Shutdown to Stopped", StateShutdown, StateShutdown, StateStopped, ErrInvalidStateTransition, StateShutdown},
 		{"Shutdown to Starting", StateShutdown, StateShutdown, StateStarting, ErrInvalidStateTransition, StateShutdown},
@@ -340,3 +351,175 @@ func TestProcess_ExitInterruptsHealthCheck(t *testing.T) {
 	assert.Equal(t, "upstream command exited prematurely but successfully", err.Error())
 	assert.Equal(t, process.CurrentState(), StateFailed)
 }
+
+func TestProcess_CrashAfterReadyIsDetected(t *testing.T) {
+	expectedMessage := "testing91931"
+	config := getTestSimpleResponderConfig(expectedMessage)
+
+	process := NewProcess("crashy", 5, config, debugLogger, debugLogger)
+	defer process.Stop()
+
+	assert.NoError(t, process.start())
+	assert.Equal(t, StateReady, process.CurrentState())
+
+	// simulate the upstream crashing, e.g. OOM killed
+	assert.NoError(t, process.cmd.Process.Kill())
+	assert.Eventually(t, func() bool {
+		return process.CurrentState() == StateStopped
+	}, 5*time.Second, 50*time.Millisecond)
+
+	// the next request starts it again
+	req := httptest.NewRequest("GET", "/test", nil)
+	w := httptest.NewRecorder()
+	process.ProxyRequest(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), expectedMessage)
+}
+
+func TestProcess_RestartOnFailure(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping slow restart test")
+	}
+
+	config := getTestSimpleResponderConfig("restarter")
+	config.Restart = RestartConfig{
+		Policy:     RESTART_ON_FAILURE,
+		MaxRetries: 1,
+		Backoff:    1,
+	}
+
+	process := NewProcess("restarter", 5, config, debugLogger, debugLogger)
+	process.healthCheckLoopInterval = 100 * time.Millisecond
+	defer process.Stop()
+
+	assert.NoError(t, process.start())
+
+	// first crash is restarted automatically
+	firstCmd := process.cmd
+	assert.NoError(t, firstCmd.Process.Kill())
+	assert.Eventually(t, func() bool {
+		return process.CurrentState() == StateReady && process.cmd != firstCmd
+	}, 5*time.Second, 50*time.Millisecond)
+
+	// out of retries, the second crash leaves it stopped
+	assert.NoError(t, process.cmd.Process.Kill())
+	assert.Eventually(t, func() bool {
+		return process.CurrentState() == StateStopped
+	}, 5*time.Second, 50*time.Millisecond)
+	<-time.After(2 * time.Second)
+	assert.Equal(t, StateStopped, process.CurrentState())
+}
+
+func TestProcess_StartAttempts(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping slow start attempts test")
+	}
+
+	config := ModelConfig{
+		Cmd:           "sleep 1",
+		Proxy:         "http://127.0.0.1:9913",
+		CheckEndpoint: "/health",
+		StartAttempts: 2,
+	}
+
+	process := NewProcess("sleepy", 5, config, debugLogger, debugLogger)
+	process.healthCheckLoopInterval = 100 * time.Millisecond
+
+	startTime := time.Now()
+	err := process.start()
+	assert.Equal(t, "upstream command exited prematurely but successfully", err.Error())
+	assert.Equal(t, StateFailed, process.CurrentState())
+
+	// both attempts ran the 1 second command
+	assert.GreaterOrEqual(t, time.Since(startTime), 2*time.Second)
+
+	reason, failedAt := process.LastFailure()
+	assert.Equal(t, "upstream command exited prematurely but successfully", reason)
+	assert.False(t, failedAt.IsZero())
+}
+
+func TestProcess_FailedRecovery(t *testing.T) {
+	config := ModelConfig{
+		Cmd:           "nonexistent-command",
+		Proxy:         "http://127.0.0.1:9913",
+		CheckEndpoint: "/health",
+	}
+
+	t.Run("manual reset", func(t *testing.T) {
+		process := NewProcess("broken", 1, config, debugLogger, debugLogger)
+		assert.Error(t, process.start())
+		assert.Equal(t, StateFailed, process.CurrentState())
+
+		assert.NoError(t, process.Reset())
+		assert.Equal(t, StateStopped, process.CurrentState())
+
+		// only a failed process can be reset
+		assert.Error(t, process.Reset())
+	})
+
+	t.Run("after cooldown", func(t *testing.T) {
+		cooldownConfig := config
+		cooldownConfig.FailureCooldown = 1
+
+		process := NewProcess("broken", 1, cooldownConfig, debugLogger, debugLogger)
+		assert.Error(t, process.start())
+		assert.Equal(t, StateFailed, process.CurrentState())
+
+		// clients are told when to try again
+		req := httptest.NewRequest("GET", "/", nil)
+		w := httptest.NewRecorder()
+		process.ProxyRequest(w, req)
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Equal(t, "1", w.Header().Get("Retry-After"))
+
+		assert.Eventually(t, func() bool {
+			return process.CurrentState() == StateStopped
+		}, 3*time.Second, 50*time.Millisecond)
+	})
+}
+
+func TestProcess_ConcurrencyQueue(t *testing.T) {
+	expectedMessage := "queued"
+	config := getTestSimpleResponderConfig(expectedMessage)
+	config.Concurrency = 1
+	config.QueueSize = 1
+	config.QueueTimeout = 1
+
+	process := NewProcess("queued", 5, config, debugLogger, debugLogger)
+	defer process.Stop()
+	assert.NoError(t, process.start())
+
+	var wg sync.WaitGroup
+	results := make([]*httptest.ResponseRecorder, 2)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req := httptest.NewRequest("POST", "/v1/chat/completions?wait=2000ms", nil)
+			results[i] = httptest.NewRecorder()
+			process.ProxyRequest(results[i], req)
+		}(i)
+
+		if i == 0 {
+			// make sure the first request has the slot
+			<-time.After(100 * time.Millisecond)
+		}
+	}
+	assert.Eventually(t, func() bool { return process.QueueDepth() == 1 }, time.Second, time.Millisecond)
+
+	// the queue is full
+	req := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+	w := httptest.NewRecorder()
+	process.ProxyRequest(w, req)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+
+	wg.Wait()
+
+	// first request was served, the queued one timed out waiting
+	assert.Equal(t, http.StatusOK, results[0].Code)
+	assert.Equal(t, expectedMessage, results[0].Body.String())
+	assert.Equal(t, http.StatusServiceUnavailable, results[1].Code)
+	assert.Equal(t, "1", results[1].Header().Get("Retry-After"))
+	assert.Equal(t, 0, process.QueueDepth())
+}
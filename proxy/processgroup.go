@@ -1,10 +1,12 @@
 package proxy
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"slices"
 	"sync"
+	"time"
 )
 
 type ProcessGroup struct {
@@ -22,6 +24,14 @@ type ProcessGroup struct {
 	// map of current processes
 	processes       map[string]*Process
 	lastUsedProcess string
+
+	// batch scheduler state, see processgroup_scheduler.go
+	scheduler     string
+	maxWait       time.Duration
+	schedulerCond *sync.Cond
+	active        int
+	waiting       []*batchWaiter
+	lastSwapTime  time.Time
 }
 
 func NewProcessGroup(id string, config Config, proxyLogger *LogMonitor, upstreamLogger *LogMonitor) *ProcessGroup {
@@ -39,12 +49,20 @@ func NewProcessGroup(id string, config Config, proxyLogger *LogMonitor, upstream
 		proxyLogger:    proxyLogger,
 		upstreamLogger: upstreamLogger,
 		processes:      make(map[string]*Process),
+		scheduler:      groupConfig.Scheduler,
+		maxWait:        groupConfig.maxWait(),
 	}
+	pg.schedulerCond = sync.NewCond(&pg.Mutex)
 
 	// Create a Process for each member in the group
 	for _, modelID := range groupConfig.Members {
 		modelConfig, modelID, _ := pg.config.FindConfig(modelID)
-		process := NewProcess(modelID, pg.config.HealthCheckTimeout, modelConfig, pg.upstreamLogger, pg.proxyLogger)
+		// each process has its own log, lines are tagged with the model ID
+		// in the upstream log
+		processLogger := NewLogMonitorWriter(pg.upstreamLogger)
+		processLogger.SetSource("upstream")
+		processLogger.SetModel(modelID)
+		process := NewProcess(modelID, pg.config.HealthCheckTimeout, modelConfig, processLogger, pg.proxyLogger)
 		pg.processes[modelID] = process
 	}
 
@@ -58,20 +76,61 @@ func (pg *ProcessGroup) ProxyRequest(modelID string, writer http.ResponseWriter,
 	}
 
 	if pg.swap {
-		pg.Lock()
-		if pg.lastUsedProcess != modelID {
-			if pg.lastUsedProcess != "" {
-				pg.processes[pg.lastUsedProcess].Stop()
-			}
-			pg.lastUsedProcess = modelID
+		release, err := pg.swapTo(request.Context(), modelID)
+		if err != nil {
+			return fmt.Errorf("request for model %s cancelled while waiting: %w", modelID, err)
 		}
-		pg.Unlock()
+		defer release()
 	}
 
 	pg.processes[modelID].ProxyRequest(writer, request)
 	return nil
 }
 
+// LoadProcess starts the process for modelID following the group's swap
+// rules. It returns once the process is ready.
+func (pg *ProcessGroup) LoadProcess(modelID string) error {
+	if !pg.HasMember(modelID) {
+		return fmt.Errorf("model %s not part of group %s", modelID, pg.id)
+	}
+
+	if pg.swap {
+		release, err := pg.swapTo(context.Background(), modelID)
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
+
+	process := pg.processes[modelID]
+	if process.CurrentState() == StateReady {
+		return nil
+	}
+	return process.start()
+}
+
+// swapTo unloads the other model in a swap group so modelID can run. The
+// returned func must be called once the caller is done with the process.
+// An error is returned if ctx is cancelled while waiting for the swap.
+func (pg *ProcessGroup) swapTo(ctx context.Context, modelID string) (func(), error) {
+	if pg.scheduler == SCHEDULER_BATCH {
+		if err := pg.acquireBatch(ctx, modelID); err != nil {
+			return nil, err
+		}
+		return pg.releaseBatch, nil
+	}
+
+	pg.Lock()
+	defer pg.Unlock()
+	if pg.lastUsedProcess != modelID {
+		if pg.lastUsedProcess != "" {
+			pg.processes[pg.lastUsedProcess].Stop()
+		}
+		pg.lastUsedProcess = modelID
+	}
+	return func() {}, nil
+}
+
 func (pg *ProcessGroup) HasMember(modelName string) bool {
 	return slices.Contains(pg.config.Groups[pg.id].Members, modelName)
 }
@@ -0,0 +1,173 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// batchWaiter is a request waiting for the batch scheduler
+type batchWaiter struct {
+	modelID string
+	since   time.Time
+	held    bool
+}
+
+// acquireBatch blocks until a request for modelID may be sent to its process.
+//
+// Requests for the loaded model are let through while other models wait. When
+// the loaded model has no running or waiting requests the model that has waited
+// the longest is swapped in. So no model starves, once a request has waited
+// maxWait the loaded model stops accepting new requests and is swapped out as
+// soon as its running requests complete.
+//
+// If ctx is cancelled while waiting the request is taken off the queue and
+// ctx's error is returned. A successful acquireBatch must be followed by a
+// call to releaseBatch.
+func (pg *ProcessGroup) acquireBatch(ctx context.Context, modelID string) error {
+	// wake up the waiters when ctx is cancelled
+	stop := context.AfterFunc(ctx, func() {
+		pg.Lock()
+		defer pg.Unlock()
+		pg.schedulerCond.Broadcast()
+	})
+	defer stop()
+
+	pg.Lock()
+	defer pg.Unlock()
+
+	waiter := &batchWaiter{modelID: modelID, since: time.Now()}
+	pg.waiting = append(pg.waiting, waiter)
+
+	for !pg.scheduleLocked(waiter) {
+		if err := ctx.Err(); err != nil {
+			pg.proxyLogger.Debugf("Group %s dropping request for %s, %v", pg.id, modelID, err)
+			pg.waiting = slices.DeleteFunc(pg.waiting, func(w *batchWaiter) bool { return w == waiter })
+
+			// the dropped waiter may have been holding back others
+			pg.schedulerCond.Broadcast()
+			return err
+		}
+
+		// wake up when the waiter may be starving
+		timer := time.AfterFunc(time.Until(pg.starvingAt(waiter)), pg.schedulerCond.Broadcast)
+		pg.schedulerCond.Wait()
+		timer.Stop()
+	}
+
+	pg.waiting = slices.DeleteFunc(pg.waiting, func(w *batchWaiter) bool { return w == waiter })
+	pg.active++
+	return nil
+}
+
+// releaseBatch marks a request acquired with acquireBatch as completed
+func (pg *ProcessGroup) releaseBatch() {
+	pg.Lock()
+	defer pg.Unlock()
+
+	pg.active--
+	pg.schedulerCond.Broadcast()
+}
+
+// scheduleLocked decides if the waiter can run now, swapping models if needed.
+// It must be called with the group locked.
+func (pg *ProcessGroup) scheduleLocked(waiter *batchWaiter) bool {
+	loaded := pg.lastUsedProcess
+	starving := pg.starvingLocked()
+
+	if waiter.modelID == loaded {
+		if starving == nil {
+			return true
+		}
+
+		pg.holdLocked(waiter, "%s has waited over %v", starving.modelID, pg.maxWait)
+		return false
+	}
+
+	if loaded != "" {
+		if pg.active > 0 {
+			pg.holdLocked(waiter, "%s has %d running requests", loaded, pg.active)
+			return false
+		}
+
+		if starving == nil && pg.countWaitingLocked(loaded) > 0 {
+			pg.holdLocked(waiter, "%s has %d waiting requests", loaded, pg.countWaitingLocked(loaded))
+			return false
+		}
+	}
+
+	// swap in the model that has waited the longest
+	for _, other := range pg.waiting {
+		if other.modelID == loaded {
+			continue
+		}
+
+		if other.modelID != waiter.modelID {
+			pg.holdLocked(waiter, "%s has waited longer", other.modelID)
+			return false
+		}
+		break
+	}
+
+	if loaded != "" {
+		pg.proxyLogger.Infof("Group %s swapping %s -> %s, %d requests waiting for %s",
+			pg.id, loaded, waiter.modelID, pg.countWaitingLocked(waiter.modelID), waiter.modelID)
+		pg.processes[loaded].Stop()
+	}
+
+	pg.lastUsedProcess = waiter.modelID
+	pg.lastSwapTime = time.Now()
+
+	// let waiting requests for the swapped in model through
+	pg.schedulerCond.Broadcast()
+	return true
+}
+
+// holdLocked logs the first time a waiter is held back
+func (pg *ProcessGroup) holdLocked(waiter *batchWaiter, reasonFormat string, args ...interface{}) {
+	if waiter.held {
+		return
+	}
+
+	waiter.held = true
+	pg.proxyLogger.Debugf("Group %s holding request for %s, %s", pg.id, waiter.modelID, fmt.Sprintf(reasonFormat, args...))
+}
+
+// starvingAt returns when the waiter will have waited too long. Time spent
+// waiting before the last swap does not count so a newly swapped in model
+// gets to serve requests for at least maxWait.
+func (pg *ProcessGroup) starvingAt(waiter *batchWaiter) time.Time {
+	since := waiter.since
+	if pg.lastSwapTime.After(since) {
+		since = pg.lastSwapTime
+	}
+	return since.Add(pg.maxWait)
+}
+
+// starvingLocked returns the longest waiting request for another model that
+// has waited over maxWait, or nil
+func (pg *ProcessGroup) starvingLocked() *batchWaiter {
+	now := time.Now()
+	for _, waiter := range pg.waiting {
+		if waiter.modelID == pg.lastUsedProcess {
+			continue
+		}
+
+		if !now.Before(pg.starvingAt(waiter)) {
+			return waiter
+		}
+		return nil
+	}
+	return nil
+}
+
+func (pg *ProcessGroup) countWaitingLocked(modelID string) int {
+	count := 0
+	for _, waiter := range pg.waiting {
+		if waiter.modelID == modelID {
+			count++
+		}
+	}
+	return count
+}
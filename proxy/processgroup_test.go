@@ -2,9 +2,12 @@ package proxy
 
 import (
 	"bytes"
+	"context"
 	"net/http"
 	"net/http/httptest"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -94,3 +97,138 @@ func TestProcessGroup_ProxyRequestSwapIsFalse(t *testing.T) {
 		assert.Equal(t, StateReady, process.CurrentState())
 	}
 }
+
+func TestProcessGroup_BatchSchedulerServesLoadedModelFirst(t *testing.T) {
+	config := AddDefaultGroupToConfig(Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]ModelConfig{
+			"model1": getTestSimpleResponderConfig("model1"),
+			"model2": getTestSimpleResponderConfig("model2"),
+		},
+		Groups: map[string]GroupConfig{
+			"G1": {
+				Swap:      true,
+				Exclusive: true,
+				Members:   []string{"model1", "model2"},
+				Scheduler: SCHEDULER_BATCH,
+				MaxWait:   30,
+			},
+		},
+	})
+
+	pg := NewProcessGroup("G1", config, testLogger, testLogger)
+	defer pg.StopProcesses()
+
+	var mu sync.Mutex
+	var completed []string
+	var wg sync.WaitGroup
+	sendRequest := func(modelName string, wait string) {
+		defer wg.Done()
+		req := httptest.NewRequest("POST", "/v1/chat/completions?wait="+wait, nil)
+		w := httptest.NewRecorder()
+		assert.NoError(t, pg.ProxyRequest(modelName, w, req))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, modelName, w.Body.String())
+
+		mu.Lock()
+		completed = append(completed, modelName)
+		mu.Unlock()
+	}
+
+	// load model1 and keep it busy
+	wg.Add(1)
+	go sendRequest("model1", "1000ms")
+	assert.Eventually(t, func() bool {
+		return pg.processes["model1"].CurrentState() == StateReady
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// model2 arrives first but model1 is loaded so its request is served first
+	wg.Add(1)
+	go sendRequest("model2", "0ms")
+	<-time.After(50 * time.Millisecond)
+	wg.Add(1)
+	go sendRequest("model1", "0ms")
+
+	wg.Wait()
+	assert.Equal(t, []string{"model1", "model1", "model2"}, completed)
+	assert.Equal(t, StateStopped, pg.processes["model1"].CurrentState())
+}
+
+func TestProcessGroup_BatchSchedulerMaxWait(t *testing.T) {
+	pg := &ProcessGroup{
+		id:              "G1",
+		proxyLogger:     testLogger,
+		maxWait:         time.Second,
+		lastUsedProcess: "model1",
+		processes:       map[string]*Process{},
+	}
+
+	model2 := &batchWaiter{modelID: "model2", since: time.Now()}
+	model1 := &batchWaiter{modelID: "model1", since: time.Now()}
+	pg.waiting = []*batchWaiter{model2, model1}
+
+	// the loaded model is served first
+	assert.True(t, pg.scheduleLocked(model1))
+	assert.False(t, pg.scheduleLocked(model2))
+
+	// after waiting too long the loaded model stops accepting requests
+	model2.since = time.Now().Add(-2 * time.Second)
+	assert.False(t, pg.scheduleLocked(model1))
+
+	// time spent waiting before the last swap does not count
+	pg.lastSwapTime = time.Now()
+	assert.True(t, pg.scheduleLocked(model1))
+}
+
+func TestProcessGroup_BatchSchedulerCancelledRequest(t *testing.T) {
+	config := AddDefaultGroupToConfig(Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]ModelConfig{
+			"model1": getTestSimpleResponderConfig("model1"),
+			"model2": getTestSimpleResponderConfig("model2"),
+		},
+		Groups: map[string]GroupConfig{
+			"G1": {
+				Swap:      true,
+				Exclusive: true,
+				Members:   []string{"model1", "model2"},
+				Scheduler: SCHEDULER_BATCH,
+				MaxWait:   30,
+			},
+		},
+	})
+
+	pg := NewProcessGroup("G1", config, testLogger, testLogger)
+	defer pg.StopProcesses()
+
+	// load model1 and keep it busy
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		req := httptest.NewRequest("POST", "/v1/chat/completions?wait=500ms", nil)
+		w := httptest.NewRecorder()
+		assert.NoError(t, pg.ProxyRequest("model1", w, req))
+	}()
+	assert.Eventually(t, func() bool {
+		return pg.processes["model1"].CurrentState() == StateReady
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the request for model2 gives up while waiting
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	req := httptest.NewRequest("POST", "/v1/chat/completions", nil).WithContext(ctx)
+	err := pg.ProxyRequest("model2", httptest.NewRecorder(), req)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	pg.Lock()
+	assert.Empty(t, pg.waiting)
+	pg.Unlock()
+
+	// model1 keeps serving and model2 was never loaded
+	wg.Wait()
+	pg.Lock()
+	assert.Equal(t, "model1", pg.lastUsedProcess)
+	pg.Unlock()
+	assert.Equal(t, StateStopped, pg.processes["model2"].CurrentState())
+}
@@ -2,6 +2,7 @@ package proxy
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -26,7 +27,12 @@ const (
 type ProxyManager struct {
 	sync.Mutex
 
-	config    Config
+	// configMutex guards config and processGroups which are replaced
+	// when the configuration is reloaded
+	configMutex   sync.RWMutex
+	config        Config
+	processGroups map[string]*ProcessGroup
+
 	ginEngine *gin.Engine
 
 	// logging
@@ -34,37 +40,52 @@ type ProxyManager struct {
 	upstreamLogger *LogMonitor
 	muxLogger      *LogMonitor
 
-	processGroups map[string]*ProcessGroup
+	// for managing shutdown state
+	shutdownCtx    context.Context
+	shutdownCancel context.CancelFunc
+
+	// cancelled when GracefulShutdown starts draining requests, ends log streams
+	drainCtx    context.Context
+	drainCancel context.CancelFunc
+
+	// serializes placement decisions when a resource budget is configured.
+	// placing holds the models that have been given room and are being
+	// started, their resources are reserved until start() returns.
+	placementMutex sync.Mutex
+	placing        map[string]bool
+
+	// nil when the request log is disabled
+	requestLog *requestLog
+
+	// nil when the logs are not written to files
+	logFiles *logFiles
+
+	// usage counted against rate limits, kept when the config is reloaded
+	rateLimiter *rateLimiter
+
+	// conversations for /v1/responses previous_response_id, also kept on reload
+	responseStore *responseStore
+
+	// serverMutex guards the http servers started by Serve
+	serverMutex  sync.Mutex
+	servers      []*http.Server
+	certificates *certificateReloader
 }
 
 func New(config Config) *ProxyManager {
 	// set up loggers
 	stdoutLogger := NewLogMonitorWriter(os.Stdout)
 	upstreamLogger := NewLogMonitorWriter(stdoutLogger)
+	upstreamLogger.SetSource("upstream")
 	proxyLogger := NewLogMonitorWriter(stdoutLogger)
+	proxyLogger.SetSource("proxy")
 
 	if config.LogRequests {
 		proxyLogger.Warn("LogRequests configuration is deprecated. Use logLevel instead.")
 	}
 
-	switch strings.ToLower(strings.TrimSpace(config.LogLevel)) {
-	case "debug":
-		proxyLogger.SetLogLevel(LevelDebug)
-		upstreamLogger.SetLogLevel(LevelDebug)
-	case "info":
-		proxyLogger.SetLogLevel(LevelInfo)
-		upstreamLogger.SetLogLevel(LevelInfo)
-	case "warn":
-		proxyLogger.SetLogLevel(LevelWarn)
-		upstreamLogger.SetLogLevel(LevelWarn)
-	case "error":
-		proxyLogger.SetLogLevel(LevelError)
-		upstreamLogger.SetLogLevel(LevelError)
-	default:
-		proxyLogger.SetLogLevel(LevelInfo)
-		upstreamLogger.SetLogLevel(LevelInfo)
-	}
-
+	shutdownCtx, shutdownCancel := context.WithCancel(context.Background())
+	drainCtx, drainCancel := context.WithCancel(context.Background())
 	pm := &ProxyManager{
 		config:    config,
 		ginEngine: gin.New(),
@@ -74,6 +95,38 @@ func New(config Config) *ProxyManager {
 		upstreamLogger: upstreamLogger,
 
 		processGroups: make(map[string]*ProcessGroup),
+
+		shutdownCtx:    shutdownCtx,
+		shutdownCancel: shutdownCancel,
+		drainCtx:       drainCtx,
+		drainCancel:    drainCancel,
+
+		rateLimiter:   newRateLimiter(),
+		responseStore: newResponseStore(),
+	}
+
+	pm.setLogLevel(config.LogLevel)
+
+	if config.RequestLog.Path != "" {
+		if requestLog, err := newRequestLog(config.RequestLog); err != nil {
+			proxyLogger.Errorf("Unable to open request log %s: %v", config.RequestLog.Path, err)
+		} else {
+			pm.requestLog = requestLog
+		}
+	}
+
+	if config.LogFile.enabled() {
+		if logFiles, err := newLogFiles(config.LogFile); err != nil {
+			proxyLogger.Errorf("Unable to open log files: %v", err)
+		} else {
+			pm.logFiles = logFiles
+			if logFiles.proxy != nil {
+				proxyLogger.AddWriter(logFiles.proxy)
+			}
+			if logFiles.upstream != nil {
+				upstreamLogger.AddWriter(logFiles.upstream)
+			}
+		}
 	}
 
 	// create the process groups
@@ -81,6 +134,9 @@ func New(config Config) *ProxyManager {
 		processGroup := NewProcessGroup(groupID, config, proxyLogger, upstreamLogger)
 		pm.processGroups[groupID] = processGroup
 	}
+	pm.setLogBufferSize(config.logBufferSize(), config.logStreamBufferSize())
+	pm.addModelLogFiles()
+	pm.setRestartFuncs()
 
 	pm.ginEngine.Use(func(c *gin.Context) {
 		// Start timer
@@ -91,6 +147,15 @@ func New(config Config) *ProxyManager {
 		method := c.Request.Method
 		path := c.Request.URL.Path
 
+		// filled in by Process.ProxyRequest when the upstream reports token usage
+		ctx, stats := withRequestStats(c.Request.Context())
+		c.Request = c.Request.WithContext(ctx)
+
+		var captureBodies func(*RequestLogEntry)
+		if pm.requestLog != nil {
+			captureBodies = pm.requestLog.capture(c)
+		}
+
 		// Process request
 		c.Next()
 
@@ -100,7 +165,23 @@ func New(config Config) *ProxyManager {
 		statusCode := c.Writer.Status()
 		bodySize := c.Writer.Size()
 
-		pm.proxyLogger.Infof("Request %s \"%s %s %s\" %d %d \"%s\" %v",
+		endpoint := c.FullPath()
+		if endpoint == "" {
+			endpoint = "other"
+		}
+		metrics.observeRequest(c.GetString(ginKeyModel), endpoint, statusCode, duration)
+
+		var usageStr string
+		if stats.found {
+			usageStr = fmt.Sprintf(" prompt_tokens=%d completion_tokens=%d prompt_tps=%.2f gen_tps=%.2f",
+				stats.usage.PromptTokens,
+				stats.usage.CompletionTokens,
+				stats.usage.PromptPerSecond,
+				stats.usage.TokensPerSecond,
+			)
+		}
+
+		pm.proxyLogger.Infof("Request %s \"%s %s %s\" %d %d \"%s\" %v%s",
 			clientIP,
 			method,
 			path,
@@ -109,7 +190,32 @@ func New(config Config) *ProxyManager {
 			bodySize,
 			c.Request.UserAgent(),
 			duration,
+			usageStr,
 		)
+
+		// only requests for a model are written to the request log
+		if requestedModel := c.GetString(ginKeyRequestedModel); pm.requestLog != nil && requestedModel != "" {
+			entry := RequestLogEntry{
+				Timestamp:      start,
+				ClientIP:       clientIP,
+				Method:         method,
+				Endpoint:       path,
+				RequestedModel: requestedModel,
+				Model:          c.GetString(ginKeyModel),
+				Status:         statusCode,
+				StartMs:        float64(stats.startDuration.Microseconds()) / 1000,
+				TotalMs:        float64(duration.Microseconds()) / 1000,
+			}
+			if stats.found {
+				usage := stats.usage
+				entry.Usage = &usage
+			}
+			captureBodies(&entry)
+
+			if err := pm.requestLog.write(entry); err != nil {
+				pm.proxyLogger.Errorf("Unable to write request log: %v", err)
+			}
+		}
 	})
 
 	// see: issue: #81, #77 and #42 for CORS issues
@@ -136,35 +242,64 @@ func New(config Config) *ProxyManager {
 		c.Next()
 	})
 
+	// in auth.go
+	pm.ginEngine.Use(pm.authMiddleware)
+
 	// Set up routes using the Gin engine
-	pm.ginEngine.POST("/v1/chat/completions", pm.proxyOAIHandler)
+	pm.ginEngine.POST("/v1/chat/completions", pm.rateLimitMiddleware, pm.proxyOAIHandler)
 	// Support legacy /v1/completions api, see issue #12
-	pm.ginEngine.POST("/v1/completions", pm.proxyOAIHandler)
+	pm.ginEngine.POST("/v1/completions", pm.rateLimitMiddleware, pm.proxyOAIHandler)
 
 	// Support embeddings
-	pm.ginEngine.POST("/v1/embeddings", pm.proxyOAIHandler)
-	pm.ginEngine.POST("/v1/rerank", pm.proxyOAIHandler)
+	pm.ginEngine.POST("/v1/embeddings", pm.rateLimitMiddleware, pm.proxyOAIHandler)
+	pm.ginEngine.POST("/v1/rerank", pm.rateLimitMiddleware, pm.proxyOAIHandler)
 
 	// Support audio/speech endpoint
-	pm.ginEngine.POST("/v1/audio/speech", pm.proxyOAIHandler)
-	pm.ginEngine.POST("/v1/audio/transcriptions", pm.proxyOAIPostFormHandler)
+	pm.ginEngine.POST("/v1/audio/speech", pm.rateLimitMiddleware, pm.proxyOAIHandler)
+	pm.ginEngine.POST("/v1/audio/transcriptions", pm.rateLimitMiddleware, pm.proxyOAIPostFormHandler)
 
 	pm.ginEngine.GET("/v1/models", pm.listModelsHandler)
 
 	// in proxymanager_loghandlers.go
 	pm.ginEngine.GET("/logs", pm.sendLogsHandlers)
+	pm.ginEngine.GET("/logs/query", pm.queryLogsHandler)
 	pm.ginEngine.GET("/logs/stream", pm.streamLogsHandler)
 	pm.ginEngine.GET("/logs/streamSSE", pm.streamLogsHandlerSSE)
 	pm.ginEngine.GET("/logs/stream/:logMonitorID", pm.streamLogsHandler)
 	pm.ginEngine.GET("/logs/streamSSE/:logMonitorID", pm.streamLogsHandlerSSE)
 
 	pm.ginEngine.GET("/upstream", pm.upstreamIndex)
-	pm.ginEngine.Any("/upstream/:model_id/*upstreamPath", pm.proxyToUpstream)
+	pm.ginEngine.Any("/upstream/:model_id/*upstreamPath", pm.rateLimitMiddleware, pm.proxyToUpstream)
 
 	pm.ginEngine.GET("/unload", pm.unloadAllModelsHandler)
 
 	pm.ginEngine.GET("/running", pm.listRunningProcessesHandler)
 
+	// in metrics.go
+	pm.ginEngine.GET("/metrics", pm.metricsHandler)
+
+	// in proxymanager_api.go
+	pm.ginEngine.GET("/api/models/:model_id", pm.getModelHandler)
+	pm.ginEngine.POST("/api/models/:model_id/load", pm.loadModelHandler)
+	pm.ginEngine.POST("/api/models/:model_id/unload", pm.unloadModelHandler)
+	pm.ginEngine.POST("/api/models/:model_id/reset", pm.resetModelHandler)
+	pm.ginEngine.GET("/api/usage", pm.usageHandler)
+
+	// in proxymanager_ollama.go
+	pm.ginEngine.GET("/api/tags", pm.ollamaTagsHandler)
+	pm.ginEngine.POST("/api/show", pm.ollamaShowHandler)
+	pm.ginEngine.GET("/api/ps", pm.ollamaPsHandler)
+	pm.ginEngine.POST("/api/chat", pm.rateLimitMiddleware, pm.ollamaChatHandler)
+	pm.ginEngine.POST("/api/generate", pm.rateLimitMiddleware, pm.ollamaGenerateHandler)
+	pm.ginEngine.POST("/api/embed", pm.rateLimitMiddleware, pm.ollamaEmbedHandler)
+
+	// in proxymanager_anthropic.go
+	pm.ginEngine.POST("/v1/messages", pm.rateLimitMiddleware, pm.anthropicMessagesHandler)
+
+	// in proxymanager_responses.go
+	pm.ginEngine.POST("/v1/responses", pm.rateLimitMiddleware, pm.responsesHandler)
+	pm.ginEngine.DELETE("/v1/responses/:response_id", pm.deleteResponseHandler)
+
 	pm.ginEngine.GET("/", func(c *gin.Context) {
 		// Set the Content-Type header to text/html
 		c.Header("Content-Type", "text/html")
@@ -193,9 +328,132 @@ func New(config Config) *ProxyManager {
 	// Disable console color for testing
 	gin.DisableConsoleColor()
 
+	if preload := config.PreloadModels(); len(preload) > 0 {
+		go pm.preloadModels(preload)
+	}
+
 	return pm
 }
 
+// preloadModels starts models one at a time so group swap and exclusive
+// rules apply the same as if they were requested in order
+func (pm *ProxyManager) preloadModels(modelIDs []string) {
+	for _, modelID := range modelIDs {
+		pm.proxyLogger.Infof("Preloading model %s", modelID)
+		if err := pm.loadModel(modelID); err != nil {
+			pm.proxyLogger.Errorf("Unable to preload model %s: %v", modelID, err)
+		}
+	}
+
+	// a later model may have unloaded an earlier one
+	for _, modelID := range modelIDs {
+		if processGroup := pm.findGroupByModelName(modelID); processGroup != nil {
+			if state := processGroup.processes[modelID].CurrentState(); state == StateStopped {
+				pm.proxyLogger.Warnf("Preloaded model %s was unloaded by group swap or exclusive rules", modelID)
+			}
+		}
+	}
+}
+
+// loadModel starts a model, swapping out other models the same way a request would
+func (pm *ProxyManager) loadModel(requestedModel string) error {
+	processGroup, realModelName, err := pm.swapProcessGroup(requestedModel)
+	if err != nil {
+		return err
+	}
+
+	return processGroup.LoadProcess(realModelName)
+}
+
+// restartModel restarts a model after it crashed the same way a request
+// would load it, so the group's swap and exclusive rules and the resource
+// budget are followed. It does nothing when the model was swapped out or
+// replaced by a config reload while waiting to restart.
+func (pm *ProxyManager) restartModel(process *Process) error {
+	processGroup := pm.findGroupByModelName(process.ID)
+	if processGroup == nil || processGroup.processes[process.ID] != process {
+		pm.proxyLogger.Infof("<%s> not restarting, the model was removed or replaced", process.ID)
+		return nil
+	}
+
+	if processGroup.swap {
+		processGroup.Lock()
+		lastUsedProcess := processGroup.lastUsedProcess
+		processGroup.Unlock()
+		if lastUsedProcess != process.ID {
+			pm.proxyLogger.Infof("<%s> not restarting, %s was swapped in", process.ID, lastUsedProcess)
+			return nil
+		}
+	}
+
+	return pm.loadModel(process.ID)
+}
+
+// setRestartFuncs restarts crashed processes with restartModel
+func (pm *ProxyManager) setRestartFuncs() {
+	for _, processGroup := range pm.currentProcessGroups() {
+		for _, process := range processGroup.processes {
+			process.setRestartFunc(func() error {
+				return pm.restartModel(process)
+			})
+		}
+	}
+}
+
+func (pm *ProxyManager) setLogLevel(logLevel string) {
+	switch strings.ToLower(strings.TrimSpace(logLevel)) {
+	case "debug":
+		pm.proxyLogger.SetLogLevel(LevelDebug)
+		pm.upstreamLogger.SetLogLevel(LevelDebug)
+	case "info":
+		pm.proxyLogger.SetLogLevel(LevelInfo)
+		pm.upstreamLogger.SetLogLevel(LevelInfo)
+	case "warn":
+		pm.proxyLogger.SetLogLevel(LevelWarn)
+		pm.upstreamLogger.SetLogLevel(LevelWarn)
+	case "error":
+		pm.proxyLogger.SetLogLevel(LevelError)
+		pm.upstreamLogger.SetLogLevel(LevelError)
+	default:
+		pm.proxyLogger.SetLogLevel(LevelInfo)
+		pm.upstreamLogger.SetLogLevel(LevelInfo)
+	}
+}
+
+// setLogBufferSize sets how many bytes of log lines each log keeps and
+// how many lines are queued for each stream
+func (pm *ProxyManager) setLogBufferSize(size, streamSize int) {
+	loggers := []*LogMonitor{pm.muxLogger, pm.proxyLogger, pm.upstreamLogger}
+	for _, processGroup := range pm.currentProcessGroups() {
+		for _, process := range processGroup.processes {
+			loggers = append(loggers, process.LogMonitor())
+		}
+	}
+
+	for _, logger := range loggers {
+		logger.SetBufferSize(size)
+		logger.SetStreamBufferSize(streamSize)
+	}
+}
+
+// addModelLogFiles writes each model's log to its own file when logFile.modelDir is set
+func (pm *ProxyManager) addModelLogFiles() {
+	if pm.logFiles == nil {
+		return
+	}
+
+	for _, processGroup := range pm.currentProcessGroups() {
+		for modelID, process := range processGroup.processes {
+			file, err := pm.logFiles.model(modelID)
+			if err != nil {
+				pm.proxyLogger.Errorf("Unable to open log file for %s: %v", modelID, err)
+			} else if file != nil {
+				process.LogMonitor().AddWriter(file)
+			}
+		}
+	}
+}
+
 func (pm *ProxyManager) Run(addr ...string) error {
 	return pm.ginEngine.Run(addr...)
 }
@@ -210,7 +468,7 @@ func (pm *ProxyManager) StopProcesses() {
 
 	// stop Processes in parallel
 	var wg sync.WaitGroup
-	for _, processGroup := range pm.processGroups {
+	for _, processGroup := range pm.currentProcessGroups() {
 		wg.Add(1)
 		go func(processGroup *ProcessGroup) {
 			defer wg.Done()
@@ -228,10 +486,11 @@ func (pm *ProxyManager) Shutdown() {
 	defer pm.Unlock()
 
 	pm.proxyLogger.Debug("Shutdown() called in proxy manager")
+	pm.shutdownCancel()
 
 	var wg sync.WaitGroup
 	// Send shutdown signal to all process in groups
-	for _, processGroup := range pm.processGroups {
+	for _, processGroup := range pm.currentProcessGroups() {
 		wg.Add(1)
 		go func(processGroup *ProcessGroup) {
 			defer wg.Done()
@@ -239,11 +498,54 @@ func (pm *ProxyManager) Shutdown() {
 		}(processGroup)
 	}
 	wg.Wait()
+
+	if pm.requestLog != nil {
+		pm.requestLog.Close()
+	}
+	if pm.logFiles != nil {
+		pm.logFiles.Close()
+	}
+}
+
+// Kill stops all upstream processes right away, without waiting for
+// requests or for the processes to exit gracefully. Unlike Shutdown it does
+// not take the lock so it can interrupt a Shutdown in progress.
+func (pm *ProxyManager) Kill() {
+	pm.shutdownCancel()
+
+	var wg sync.WaitGroup
+	for _, processGroup := range pm.currentProcessGroups() {
+		for _, process := range processGroup.processes {
+			wg.Add(1)
+			go func(process *Process) {
+				defer wg.Done()
+				process.Kill()
+			}(process)
+		}
+	}
+	wg.Wait()
+}
+
+// currentConfig returns the active configuration. It may be replaced at any
+// time by ReloadConfig so callers should use the returned copy.
+func (pm *ProxyManager) currentConfig() Config {
+	pm.configMutex.RLock()
+	defer pm.configMutex.RUnlock()
+	return pm.config
+}
+
+// currentProcessGroups returns the active process groups. The map is
+// replaced, never modified, by ReloadConfig so it is safe to range over.
+func (pm *ProxyManager) currentProcessGroups() map[string]*ProcessGroup {
+	pm.configMutex.RLock()
+	defer pm.configMutex.RUnlock()
+	return pm.processGroups
 }
 
 func (pm *ProxyManager) swapProcessGroup(requestedModel string) (*ProcessGroup, string, error) {
 	// de-alias the real model name and get a real one
-	realModelName, found := pm.config.RealModelName(requestedModel)
+	config := pm.currentConfig()
+	realModelName, found := config.RealModelName(requestedModel)
 	if !found {
 		return nil, realModelName, fmt.Errorf("could not find real modelID for %s", requestedModel)
 	}
@@ -253,9 +555,14 @@ func (pm *ProxyManager) swapProcessGroup(requestedModel string) (*ProcessGroup,
 		return nil, realModelName, fmt.Errorf("could not find process group for model %s", requestedModel)
 	}
 
-	if processGroup.exclusive {
+	// with a resource budget models are unloaded as needed to make room
+	if len(config.Resources) > 0 {
+		if err := pm.placeModel(config, realModelName); err != nil {
+			return nil, realModelName, err
+		}
+	} else if processGroup.exclusive {
 		pm.proxyLogger.Debugf("Exclusive mode for group %s, stopping other process groups", processGroup.id)
-		for groupId, otherGroup := range pm.processGroups {
+		for groupId, otherGroup := range pm.currentProcessGroups() {
 			if groupId != processGroup.id && !otherGroup.persistent {
 				otherGroup.StopProcesses()
 			}
@@ -267,11 +574,16 @@ func (pm *ProxyManager) swapProcessGroup(requestedModel string) (*ProcessGroup,
 
 func (pm *ProxyManager) listModelsHandler(c *gin.Context) {
 	data := []interface{}{}
-	for id, modelConfig := range pm.config.Models {
+	apiKey := requestAPIKey(c)
+	for id, modelConfig := range pm.currentConfig().Models {
 		if modelConfig.Unlisted {
 			continue
 		}
 
+		if apiKey != nil && !apiKey.AllowsModel(id) {
+			continue
+		}
+
 		data = append(data, map[string]interface{}{
 			"id":       id,
 			"object":   "model",
@@ -301,11 +613,14 @@ func (pm *ProxyManager) proxyToUpstream(c *gin.Context) {
 		pm.sendErrorResponse(c, http.StatusBadRequest, "model id required in path")
 		return
 	}
+	c.Set(ginKeyRequestedModel, requestedModel)
 
-	processGroup, _, err := pm.swapProcessGroup(requestedModel)
+	processGroup, realModelName, err := pm.swapProcessGroup(requestedModel)
 	if err != nil {
 		pm.sendErrorResponse(c, http.StatusInternalServerError, fmt.Sprintf("error swapping process group: %s", err.Error()))
+		return
 	}
+	c.Set(ginKeyModel, realModelName)
 
 	// rewrite the path
 	c.Request.URL.Path = c.Param("upstreamPath")
@@ -319,7 +634,7 @@ func (pm *ProxyManager) upstreamIndex(c *gin.Context) {
 
 	// Extract keys and sort them
 	var modelIDs []string
-	for modelID, modelConfig := range pm.config.Models {
+	for modelID, modelConfig := range pm.currentConfig().Models {
 		if modelConfig.Unlisted {
 			continue
 		}
@@ -347,15 +662,27 @@ func (pm *ProxyManager) proxyOAIHandler(c *gin.Context) {
 	requestedModel := gjson.GetBytes(bodyBytes, "model").String()
 	if requestedModel == "" {
 		pm.sendErrorResponse(c, http.StatusBadRequest, "missing or invalid 'model' key")
+		return
+	}
+	c.Set(ginKeyRequestedModel, requestedModel)
+
+	if !pm.checkModelAccess(c, requestedModel) {
+		return
 	}
 
 	processGroup, realModelName, err := pm.swapProcessGroup(requestedModel)
 	if err != nil {
 		pm.sendErrorResponse(c, http.StatusInternalServerError, fmt.Sprintf("error swapping process group: %s", err.Error()))
+		return
 	}
+	c.Set(ginKeyModel, realModelName)
+
+	// use the configuration the process group was created with, it stays
+	// consistent with the running process if the config is reloaded
+	modelConfig := processGroup.config.Models[realModelName]
 
 	// issue #69 allow custom model names to be sent to upstream
-	useModelName := pm.config.Models[realModelName].UseModelName
+	useModelName := modelConfig.UseModelName
 	if useModelName != "" {
 		bodyBytes, err = sjson.SetBytes(bodyBytes, "model", useModelName)
 		if err != nil {
@@ -365,7 +692,7 @@ func (pm *ProxyManager) proxyOAIHandler(c *gin.Context) {
 	}
 
 	// Check if this model has a message_prefix configuration
-	messagePrefix := pm.config.Models[realModelName].MessagePrefix
+	messagePrefix := modelConfig.MessagePrefix
 	if messagePrefix != "" {
 		// Only modify if it's a chat/completions request that has messages
 		if strings.Contains(c.Request.URL.Path, "/chat/completions") && gjson.GetBytes(bodyBytes, "messages").Exists() {
@@ -441,9 +768,9 @@ func (pm *ProxyManager) proxyOAIHandler(c *gin.Context) {
 	}
 
 	// Check if this model has a cache_prompt configuration and the field is not already in the request
-	if pm.config.Models[realModelName].CachePrompt != nil && !gjson.GetBytes(bodyBytes, "cache_prompt").Exists() {
+	if modelConfig.CachePrompt != nil && !gjson.GetBytes(bodyBytes, "cache_prompt").Exists() {
 		// Add the cache_prompt field with the configured value
-		cachePromptValue := *pm.config.Models[realModelName].CachePrompt
+		cachePromptValue := *modelConfig.CachePrompt
 		var err error
 		bodyBytes, err = sjson.SetBytes(bodyBytes, "cache_prompt", cachePromptValue)
 		if err != nil {
@@ -462,6 +789,11 @@ func (pm *ProxyManager) proxyOAIHandler(c *gin.Context) {
 	c.Request.Header.Add("content-length", strconv.Itoa(len(bodyBytes)))
 
 	if err := processGroup.ProxyRequest(realModelName, c.Writer, c.Request); err != nil {
+		if c.Request.Context().Err() != nil {
+			// the client went away while the request was waiting
+			pm.proxyLogger.Debugf("Request for model %s cancelled: %v", realModelName, err)
+			return
+		}
 		pm.sendErrorResponse(c, http.StatusInternalServerError, fmt.Sprintf("error proxying request: %s", err.Error()))
 		pm.proxyLogger.Errorf("Error Proxying Request for processGroup %s and model %s", processGroup.id, realModelName)
 	}
@@ -485,11 +817,18 @@ func (pm *ProxyManager) proxyOAIPostFormHandler(c *gin.Context) {
 		pm.sendErrorResponse(c, http.StatusBadRequest, "missing or invalid 'model' parameter in form data")
 		return
 	}
+	c.Set(ginKeyRequestedModel, requestedModel)
+
+	if !pm.checkModelAccess(c, requestedModel) {
+		return
+	}
 
 	processGroup, realModelName, err := pm.swapProcessGroup(requestedModel)
 	if err != nil {
 		pm.sendErrorResponse(c, http.StatusInternalServerError, fmt.Sprintf("error swapping process group: %s", err.Error()))
+		return
 	}
+	c.Set(ginKeyModel, realModelName)
 
 	// Copy all form values
 	for key, values := range c.Request.MultipartForm.Value {
@@ -498,7 +837,7 @@ func (pm *ProxyManager) proxyOAIPostFormHandler(c *gin.Context) {
 			// If this is the model field and we have a profile, use just the model name
 			if key == "model" {
 				// # issue #69 allow custom model names to be sent to upstream
-				useModelName := pm.config.Models[realModelName].UseModelName
+				useModelName := processGroup.config.Models[realModelName].UseModelName
 
 				if useModelName != "" {
 					fieldValue = useModelName
@@ -566,6 +905,11 @@ func (pm *ProxyManager) proxyOAIPostFormHandler(c *gin.Context) {
 
 	// Use the modified request for proxying
 	if err := processGroup.ProxyRequest(realModelName, c.Writer, modifiedReq); err != nil {
+		if c.Request.Context().Err() != nil {
+			// the client went away while the request was waiting
+			pm.proxyLogger.Debugf("Request for model %s cancelled: %v", realModelName, err)
+			return
+		}
 		pm.sendErrorResponse(c, http.StatusInternalServerError, fmt.Sprintf("error proxying request: %s", err.Error()))
 		pm.proxyLogger.Errorf("Error Proxying Request for processGroup %s and model %s", processGroup.id, realModelName)
 	}
@@ -590,14 +934,26 @@ func (pm *ProxyManager) listRunningProcessesHandler(context *gin.Context) {
 	context.Header("Content-Type", "application/json")
 	runningProcesses := make([]gin.H, 0) // Default to an empty response.
 
-	for _, processGroup := range pm.processGroups {
+	for _, processGroup := range pm.currentProcessGroups() {
 		for _, process := range processGroup.processes {
-			if process.CurrentState() == StateReady {
-				runningProcesses = append(runningProcesses, gin.H{
-					"model": process.ID,
-					"state": process.state,
-				})
+			state := process.CurrentState()
+			if state != StateReady && state != StateFailed {
+				continue
+			}
+
+			processInfo := gin.H{
+				"model":  process.ID,
+				"state":  state,
+				"queued": process.QueueDepth(),
+			}
+
+			// report why a model failed to start
+			if reason, failedAt := process.LastFailure(); reason != "" {
+				processInfo["lastFailure"] = reason
+				processInfo["lastFailureTime"] = failedAt
 			}
+
+			runningProcesses = append(runningProcesses, processInfo)
 		}
 	}
 
@@ -610,7 +966,7 @@ func (pm *ProxyManager) listRunningProcessesHandler(context *gin.Context) {
 }
 
 func (pm *ProxyManager) findGroupByModelName(modelName string) *ProcessGroup {
-	for _, group := range pm.processGroups {
+	for _, group := range pm.currentProcessGroups() {
 		if group.HasMember(modelName) {
 			return group
 		}
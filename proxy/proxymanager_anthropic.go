@@ -0,0 +1,544 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// Anthropic Messages API compatible endpoint. Requests are translated to an
+// OpenAI chat completion and the response translated back.
+// See: https://docs.anthropic.com/en/api/messages
+
+type anthropicRequest struct {
+	Model         string             `json:"model"`
+	System        json.RawMessage    `json:"system"`
+	Messages      []anthropicMessage `json:"messages"`
+	MaxTokens     int                `json:"max_tokens"`
+	Temperature   *float64           `json:"temperature"`
+	TopP          *float64           `json:"top_p"`
+	TopK          *int               `json:"top_k"`
+	StopSequences []string           `json:"stop_sequences"`
+	Stream        bool               `json:"stream"`
+	Tools         []anthropicTool    `json:"tools"`
+	ToolChoice    *anthropicChoice   `json:"tool_choice"`
+}
+
+type anthropicMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+}
+
+// anthropicBlock is a content block. Content is either a string or a list of
+// blocks, the same as a message's content.
+type anthropicBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text"`
+	Source    *anthropicImage `json:"source"`
+	ID        string          `json:"id"`
+	Name      string          `json:"name"`
+	Input     json.RawMessage `json:"input"`
+	ToolUseID string          `json:"tool_use_id"`
+	Content   json.RawMessage `json:"content"`
+	IsError   bool            `json:"is_error"`
+}
+
+type anthropicImage struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type"`
+	Data      string `json:"data"`
+	URL       string `json:"url"`
+}
+
+type anthropicTool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	InputSchema json.RawMessage `json:"input_schema"`
+}
+
+type anthropicChoice struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+}
+
+// anthropicStopReasons maps OpenAI finish reasons to stop reasons
+var anthropicStopReasons = map[string]string{
+	"stop":       "end_turn",
+	"length":     "max_tokens",
+	"tool_calls": "tool_use",
+}
+
+// anthropicErrorTypes maps HTTP status codes to error types
+var anthropicErrorTypes = map[int]string{
+	http.StatusBadRequest:            "invalid_request_error",
+	http.StatusUnauthorized:          "authentication_error",
+	http.StatusForbidden:             "permission_error",
+	http.StatusNotFound:              "not_found_error",
+	http.StatusRequestEntityTooLarge: "request_too_large",
+	http.StatusTooManyRequests:       "rate_limit_error",
+	http.StatusServiceUnavailable:    "overloaded_error",
+}
+
+func sendAnthropicError(c *gin.Context, statusCode int, errorType, message string) {
+	c.JSON(statusCode, gin.H{
+		"type":  "error",
+		"error": gin.H{"type": errorType, "message": message},
+	})
+}
+
+func (pm *ProxyManager) anthropicMessagesHandler(c *gin.Context) {
+	var req anthropicRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		sendAnthropicError(c, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+
+	config := pm.currentConfig()
+	if _, found := config.RealModelName(req.Model); !found {
+		sendAnthropicError(c, http.StatusNotFound, "not_found_error", fmt.Sprintf("model: %s", req.Model))
+		return
+	}
+
+	body, err := anthropicToOpenAI(req)
+	if err != nil {
+		sendAnthropicError(c, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+
+	bodyBytes, err := json.Marshal(body)
+	if err != nil {
+		sendAnthropicError(c, http.StatusInternalServerError, "api_error", err.Error())
+		return
+	}
+
+	pm.proxyTranslatedRequest(c, "/v1/chat/completions", bodyBytes, &anthropicTranslator{
+		id:    newResponseID("msg_"),
+		model: req.Model,
+	})
+}
+
+// anthropicToOpenAI converts a Messages API request into a chat completion request
+func anthropicToOpenAI(req anthropicRequest) (gin.H, error) {
+	messages := make([]gin.H, 0, len(req.Messages)+1)
+
+	if system, err := anthropicText(req.System); err != nil {
+		return nil, fmt.Errorf("system: %v", err)
+	} else if system != "" {
+		messages = append(messages, gin.H{"role": "system", "content": system})
+	}
+
+	for i, m := range req.Messages {
+		converted, err := anthropicMessageToOpenAI(m)
+		if err != nil {
+			return nil, fmt.Errorf("messages.%d: %v", i, err)
+		}
+		messages = append(messages, converted...)
+	}
+
+	body := gin.H{
+		"model":    req.Model,
+		"messages": messages,
+		"stream":   req.Stream,
+	}
+	if req.MaxTokens > 0 {
+		body["max_tokens"] = req.MaxTokens
+	}
+	if req.Temperature != nil {
+		body["temperature"] = *req.Temperature
+	}
+	if req.TopP != nil {
+		body["top_p"] = *req.TopP
+	}
+	if req.TopK != nil {
+		body["top_k"] = *req.TopK
+	}
+	if len(req.StopSequences) > 0 {
+		body["stop"] = req.StopSequences
+	}
+	if req.Stream {
+		body["stream_options"] = gin.H{"include_usage": true}
+	}
+
+	if len(req.Tools) > 0 {
+		tools := make([]gin.H, 0, len(req.Tools))
+		for _, tool := range req.Tools {
+			tools = append(tools, gin.H{
+				"type": "function",
+				"function": gin.H{
+					"name":        tool.Name,
+					"description": tool.Description,
+					"parameters":  tool.InputSchema,
+				},
+			})
+		}
+		body["tools"] = tools
+	}
+
+	if req.ToolChoice != nil {
+		switch req.ToolChoice.Type {
+		case "auto", "none":
+			body["tool_choice"] = req.ToolChoice.Type
+		case "any":
+			body["tool_choice"] = "required"
+		case "tool":
+			body["tool_choice"] = gin.H{"type": "function", "function": gin.H{"name": req.ToolChoice.Name}}
+		}
+	}
+
+	return body, nil
+}
+
+// anthropicMessageToOpenAI converts a message. A user message with
+// tool_result blocks becomes a tool message for each result followed by the
+// rest of its content.
+func anthropicMessageToOpenAI(m anthropicMessage) ([]gin.H, error) {
+	blocks, err := anthropicBlocks(m.Content)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []gin.H
+	var parts []gin.H
+	var toolCalls []gin.H
+	for _, block := range blocks {
+		switch block.Type {
+		case "text":
+			parts = append(parts, gin.H{"type": "text", "text": block.Text})
+		case "image":
+			if block.Source == nil {
+				return nil, fmt.Errorf("image block without a source")
+			}
+			url := block.Source.URL
+			if block.Source.Type == "base64" {
+				url = "data:" + block.Source.MediaType + ";base64," + block.Source.Data
+			}
+			parts = append(parts, gin.H{"type": "image_url", "image_url": gin.H{"url": url}})
+		case "tool_use":
+			arguments := string(block.Input)
+			if arguments == "" || arguments == "null" {
+				arguments = "{}"
+			}
+			toolCalls = append(toolCalls, gin.H{
+				"id":       block.ID,
+				"type":     "function",
+				"function": gin.H{"name": block.Name, "arguments": arguments},
+			})
+		case "tool_result":
+			content, err := anthropicText(block.Content)
+			if err != nil {
+				return nil, fmt.Errorf("tool_result: %v", err)
+			}
+			if block.IsError {
+				content = "Error: " + content
+			}
+			out = append(out, gin.H{"role": "tool", "tool_call_id": block.ToolUseID, "content": content})
+		case "thinking", "redacted_thinking":
+			// the upstream does not need earlier reasoning
+		default:
+			return nil, fmt.Errorf("unsupported content block type %s", block.Type)
+		}
+	}
+
+	if len(parts) == 0 && len(toolCalls) == 0 {
+		return out, nil
+	}
+
+	msg := gin.H{"role": m.Role}
+
+	// plain text is sent as a string, not all upstreams accept content parts
+	textOnly := true
+	for _, part := range parts {
+		textOnly = textOnly && part["type"] == "text"
+	}
+	if textOnly {
+		texts := make([]string, 0, len(parts))
+		for _, part := range parts {
+			texts = append(texts, part["text"].(string))
+		}
+		msg["content"] = strings.Join(texts, "\n")
+	} else {
+		msg["content"] = parts
+	}
+
+	if len(toolCalls) > 0 {
+		msg["tool_calls"] = toolCalls
+	}
+	return append(out, msg), nil
+}
+
+// anthropicBlocks parses content that is either a string or a list of blocks
+func anthropicBlocks(content json.RawMessage) ([]anthropicBlock, error) {
+	if len(content) == 0 || string(content) == "null" {
+		return nil, nil
+	}
+
+	var text string
+	if err := json.Unmarshal(content, &text); err == nil {
+		return []anthropicBlock{{Type: "text", Text: text}}, nil
+	}
+
+	var blocks []anthropicBlock
+	if err := json.Unmarshal(content, &blocks); err != nil {
+		return nil, fmt.Errorf("content must be a string or a list of content blocks")
+	}
+	return blocks, nil
+}
+
+// anthropicText returns the text of content that is a string or text blocks
+func anthropicText(content json.RawMessage) (string, error) {
+	blocks, err := anthropicBlocks(content)
+	if err != nil {
+		return "", err
+	}
+
+	var texts []string
+	for _, block := range blocks {
+		if block.Type == "text" {
+			texts = append(texts, block.Text)
+		}
+	}
+	return strings.Join(texts, "\n"), nil
+}
+
+// anthropicTranslator translates chat completions into Messages API responses
+type anthropicTranslator struct {
+	id    string
+	model string
+
+	// streaming state
+	started      bool
+	blockIndex   int
+	blockType    string // type of the open content block, empty when none is open
+	toolIndex    int    // OpenAI index of the tool call in the open block
+	stopReason   string
+	inputTokens  int64
+	outputTokens int64
+}
+
+func (t *anthropicTranslator) contentType(streaming bool) string {
+	if streaming {
+		return "text/event-stream"
+	}
+	return "application/json"
+}
+
+func (t *anthropicTranslator) streamChunk(w io.Writer, data []byte) error {
+	chunk := gjson.ParseBytes(data)
+	if err := t.start(w); err != nil {
+		return err
+	}
+
+	if usage := chunk.Get("usage"); usage.IsObject() {
+		t.inputTokens = usage.Get("prompt_tokens").Int()
+		t.outputTokens = usage.Get("completion_tokens").Int()
+	}
+
+	choice := chunk.Get("choices.0")
+	if reason := choice.Get("finish_reason").String(); reason != "" {
+		t.stopReason = reason
+	}
+
+	if thinking := choice.Get("delta.reasoning_content").String(); thinking != "" {
+		if err := t.openBlock(w, "thinking", gin.H{"type": "thinking", "thinking": ""}); err != nil {
+			return err
+		}
+		if err := t.delta(w, gin.H{"type": "thinking_delta", "thinking": thinking}); err != nil {
+			return err
+		}
+	}
+
+	if text := choice.Get("delta.content").String(); text != "" {
+		if err := t.openBlock(w, "text", gin.H{"type": "text", "text": ""}); err != nil {
+			return err
+		}
+		if err := t.delta(w, gin.H{"type": "text_delta", "text": text}); err != nil {
+			return err
+		}
+	}
+
+	for _, tc := range choice.Get("delta.tool_calls").Array() {
+		index := int(tc.Get("index").Int())
+		if t.blockType != "tool_use" || index != t.toolIndex {
+			t.toolIndex = index
+			id := tc.Get("id").String()
+			if id == "" {
+				id = newResponseID("toolu_")
+			}
+			block := gin.H{"type": "tool_use", "id": id, "name": tc.Get("function.name").String(), "input": gin.H{}}
+			if err := t.openBlock(w, "tool_use", block); err != nil {
+				return err
+			}
+		}
+
+		if arguments := tc.Get("function.arguments").String(); arguments != "" {
+			if err := t.delta(w, gin.H{"type": "input_json_delta", "partial_json": arguments}); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (t *anthropicTranslator) streamEnd(w io.Writer) error {
+	if err := t.start(w); err != nil {
+		return err
+	}
+	if err := t.closeBlock(w); err != nil {
+		return err
+	}
+
+	err := t.event(w, "message_delta", gin.H{
+		"type":  "message_delta",
+		"delta": gin.H{"stop_reason": t.anthropicStopReason(t.stopReason), "stop_sequence": nil},
+		"usage": gin.H{"input_tokens": t.inputTokens, "output_tokens": t.outputTokens},
+	})
+	if err != nil {
+		return err
+	}
+	return t.event(w, "message_stop", gin.H{"type": "message_stop"})
+}
+
+func (t *anthropicTranslator) response(w io.Writer, body []byte) error {
+	result := gjson.ParseBytes(body)
+	choice := result.Get("choices.0")
+
+	content := make([]gin.H, 0)
+	if thinking := choice.Get("message.reasoning_content").String(); thinking != "" {
+		content = append(content, gin.H{"type": "thinking", "thinking": thinking})
+	}
+	if text := choice.Get("message.content").String(); text != "" {
+		content = append(content, gin.H{"type": "text", "text": text})
+	}
+	for _, tc := range choice.Get("message.tool_calls").Array() {
+		content = append(content, gin.H{
+			"type":  "tool_use",
+			"id":    tc.Get("id").String(),
+			"name":  tc.Get("function.name").String(),
+			"input": toolCallArguments(tc.Get("function.arguments").String()),
+		})
+	}
+
+	return json.NewEncoder(w).Encode(gin.H{
+		"id":            t.id,
+		"type":          "message",
+		"role":          "assistant",
+		"model":         t.model,
+		"content":       content,
+		"stop_reason":   t.anthropicStopReason(choice.Get("finish_reason").String()),
+		"stop_sequence": nil,
+		"usage": gin.H{
+			"input_tokens":  result.Get("usage.prompt_tokens").Int(),
+			"output_tokens": result.Get("usage.completion_tokens").Int(),
+		},
+	})
+}
+
+// errorResponse translates an OpenAI style error, or a plain text one, into
+// an Anthropic error object
+func (t *anthropicTranslator) errorResponse(w io.Writer, statusCode int, body []byte) error {
+	errorType, ok := anthropicErrorTypes[statusCode]
+	if !ok {
+		errorType = "api_error"
+	}
+
+	result := gjson.ParseBytes(body)
+	message := strings.TrimSpace(string(body))
+	switch {
+	case result.Get("error.message").Exists():
+		message = result.Get("error.message").String()
+	case result.Get("error").Type == gjson.String:
+		message = result.Get("error").String()
+	case message == "":
+		message = http.StatusText(statusCode)
+	}
+
+	return json.NewEncoder(w).Encode(gin.H{
+		"type":  "error",
+		"error": gin.H{"type": errorType, "message": message},
+	})
+}
+
+func (t *anthropicTranslator) anthropicStopReason(finishReason string) string {
+	if stopReason, ok := anthropicStopReasons[finishReason]; ok {
+		return stopReason
+	}
+	return "end_turn"
+}
+
+// start sends message_start before the first event
+func (t *anthropicTranslator) start(w io.Writer) error {
+	if t.started {
+		return nil
+	}
+	t.started = true
+
+	return t.event(w, "message_start", gin.H{
+		"type": "message_start",
+		"message": gin.H{
+			"id":            t.id,
+			"type":          "message",
+			"role":          "assistant",
+			"model":         t.model,
+			"content":       []gin.H{},
+			"stop_reason":   nil,
+			"stop_sequence": nil,
+			"usage":         gin.H{"input_tokens": 0, "output_tokens": 0},
+		},
+	})
+}
+
+// openBlock starts a content block of blockType unless one is already open
+func (t *anthropicTranslator) openBlock(w io.Writer, blockType string, block gin.H) error {
+	if t.blockType == blockType && blockType != "tool_use" {
+		return nil
+	}
+	if err := t.closeBlock(w); err != nil {
+		return err
+	}
+
+	t.blockType = blockType
+	return t.event(w, "content_block_start", gin.H{
+		"type":          "content_block_start",
+		"index":         t.blockIndex,
+		"content_block": block,
+	})
+}
+
+func (t *anthropicTranslator) closeBlock(w io.Writer) error {
+	if t.blockType == "" {
+		return nil
+	}
+
+	err := t.event(w, "content_block_stop", gin.H{"type": "content_block_stop", "index": t.blockIndex})
+	t.blockType = ""
+	t.blockIndex++
+	return err
+}
+
+func (t *anthropicTranslator) delta(w io.Writer, delta gin.H) error {
+	return t.event(w, "content_block_delta", gin.H{
+		"type":  "content_block_delta",
+		"index": t.blockIndex,
+		"delta": delta,
+	})
+}
+
+func (t *anthropicTranslator) event(w io.Writer, name string, data gin.H) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, encoded); err != nil {
+		return err
+	}
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func TestAnthropicToOpenAI(t *testing.T) {
+	var req anthropicRequest
+	err := json.Unmarshal([]byte(`{
+		"model": "model1",
+		"max_tokens": 100,
+		"system": [{"type": "text", "text": "be brief"}],
+		"stop_sequences": ["END"],
+		"stream": true,
+		"tools": [{"name": "weather", "description": "get the weather", "input_schema": {"type": "object"}}],
+		"tool_choice": {"type": "any"},
+		"messages": [
+			{"role": "user", "content": "hi"},
+			{"role": "assistant", "content": [
+				{"type": "thinking", "thinking": "hmm"},
+				{"type": "text", "text": "let me check"},
+				{"type": "tool_use", "id": "toolu_1", "name": "weather", "input": {"city": "Paris"}}
+			]},
+			{"role": "user", "content": [
+				{"type": "tool_result", "tool_use_id": "toolu_1", "content": [{"type": "text", "text": "sunny"}]},
+				{"type": "text", "text": "and tomorrow?"},
+				{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "AAAA"}}
+			]}
+		]
+	}`), &req)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	body, err := anthropicToOpenAI(req)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	encoded, _ := json.Marshal(body)
+	assert.JSONEq(t, `{
+		"model": "model1",
+		"max_tokens": 100,
+		"stop": ["END"],
+		"stream": true,
+		"stream_options": {"include_usage": true},
+		"tools": [{"type": "function", "function": {"name": "weather", "description": "get the weather", "parameters": {"type": "object"}}}],
+		"tool_choice": "required",
+		"messages": [
+			{"role": "system", "content": "be brief"},
+			{"role": "user", "content": "hi"},
+			{"role": "assistant", "content": "let me check", "tool_calls": [
+				{"id": "toolu_1", "type": "function", "function": {"name": "weather", "arguments": "{\"city\": \"Paris\"}"}}
+			]},
+			{"role": "tool", "tool_call_id": "toolu_1", "content": "sunny"},
+			{"role": "user", "content": [
+				{"type": "text", "text": "and tomorrow?"},
+				{"type": "image_url", "image_url": {"url": "data:image/png;base64,AAAA"}}
+			]}
+		]
+	}`, string(encoded))
+
+	req.Messages = []anthropicMessage{{Role: "user", Content: []byte(`[{"type":"document"}]`)}}
+	_, err = anthropicToOpenAI(req)
+	assert.ErrorContains(t, err, "unsupported content block type document")
+}
+
+// sseEvents returns the event names and data of an SSE response
+func sseEvents(body string) (names []string, data []string) {
+	for _, event := range strings.Split(strings.TrimSpace(body), "\n\n") {
+		lines := strings.SplitN(event, "\n", 2)
+		names = append(names, strings.TrimPrefix(lines[0], "event: "))
+		data = append(data, strings.TrimPrefix(lines[1], "data: "))
+	}
+	return names, data
+}
+
+func TestAnthropicTranslator_Stream(t *testing.T) {
+	translator := &anthropicTranslator{id: "msg_1", model: "model1"}
+	w := translateResponse(t, translator, http.StatusOK, "text/event-stream",
+		"data: {\"choices\":[{\"delta\":{\"role\":\"assistant\"}}]}\n\n",
+		"data: {\"choices\":[{\"delta\":{\"content\":\"Hel\"}}]}\n\n",
+		"data: {\"choices\":[{\"delta\":{\"content\":\"lo\"}}]}\n\n",
+		"data: {\"choices\":[{\"delta\":{\"tool_calls\":[{\"index\":0,\"id\":\"c1\",\"function\":{\"name\":\"f\",\"arguments\":\"{\"}}]}}]}\n\n",
+		"data: {\"choices\":[{\"delta\":{\"tool_calls\":[{\"index\":0,\"function\":{\"arguments\":\"}\"}}]}}]}\n\n",
+		"data: {\"choices\":[{\"delta\":{},\"finish_reason\":\"tool_calls\"}]}\n\n",
+		"data: {\"choices\":[],\"usage\":{\"prompt_tokens\":3,\"completion_tokens\":4}}\n\n",
+		"data: [DONE]\n\n",
+	)
+
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+	names, data := sseEvents(w.Body.String())
+	assert.Equal(t, []string{
+		"message_start",
+		"content_block_start", "content_block_delta", "content_block_delta", "content_block_stop",
+		"content_block_start", "content_block_delta", "content_block_delta", "content_block_stop",
+		"message_delta", "message_stop",
+	}, names)
+	if len(data) != 11 {
+		return
+	}
+
+	assert.Equal(t, "msg_1", gjson.Get(data[0], "message.id").String())
+	assert.Equal(t, "text", gjson.Get(data[1], "content_block.type").String())
+	assert.Equal(t, "Hel", gjson.Get(data[2], "delta.text").String())
+	assert.Equal(t, int64(0), gjson.Get(data[4], "index").Int())
+	assert.Equal(t, "tool_use", gjson.Get(data[5], "content_block.type").String())
+	assert.Equal(t, "c1", gjson.Get(data[5], "content_block.id").String())
+	assert.Equal(t, int64(1), gjson.Get(data[5], "index").Int())
+	assert.Equal(t, "{", gjson.Get(data[6], "delta.partial_json").String())
+	assert.Equal(t, "tool_use", gjson.Get(data[9], "delta.stop_reason").String())
+	assert.Equal(t, int64(4), gjson.Get(data[9], "usage.output_tokens").Int())
+}
+
+func TestAnthropicTranslator_Response(t *testing.T) {
+	translator := &anthropicTranslator{id: "msg_1", model: "model1"}
+	w := translateResponse(t, translator, http.StatusOK, "application/json",
+		`{"choices":[{"message":{"role":"assistant","content":"Hello","tool_calls":[`,
+		`{"id":"c1","type":"function","function":{"name":"f","arguments":"{\"a\":1}"}}]},"finish_reason":"length"}],`,
+		`"usage":{"prompt_tokens":3,"completion_tokens":4}}`,
+	)
+
+	assert.JSONEq(t, `{
+		"id": "msg_1",
+		"type": "message",
+		"role": "assistant",
+		"model": "model1",
+		"content": [
+			{"type": "text", "text": "Hello"},
+			{"type": "tool_use", "id": "c1", "name": "f", "input": {"a": 1}}
+		],
+		"stop_reason": "max_tokens",
+		"stop_sequence": null,
+		"usage": {"input_tokens": 3, "output_tokens": 4}
+	}`, w.Body.String())
+}
+
+func TestAnthropicTranslator_ErrorResponse(t *testing.T) {
+	tests := []struct {
+		name            string
+		statusCode      int
+		contentType     string
+		body            string
+		expectedType    string
+		expectedMessage string
+	}{
+		{"json error", http.StatusForbidden, "application/json", `{"error":"API key is not allowed to use model model2"}`, "permission_error", "API key is not allowed to use model model2"},
+		{"openai error", http.StatusTooManyRequests, "application/json", `{"error":{"message":"slow down","type":"rate_limit"}}`, "rate_limit_error", "slow down"},
+		{"plain text", http.StatusNotFound, "text/plain", "model not found\n", "not_found_error", "model not found"},
+		{"empty body", http.StatusBadGateway, "text/plain", "", "api_error", "Bad Gateway"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			translator := &anthropicTranslator{id: "msg_1", model: "model1"}
+			w := translateResponse(t, translator, tt.statusCode, tt.contentType, tt.body)
+			assert.Equal(t, tt.statusCode, w.Code)
+			assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+			assert.Equal(t, "error", gjson.Get(w.Body.String(), "type").String())
+			assert.Equal(t, tt.expectedType, gjson.Get(w.Body.String(), "error.type").String())
+			assert.Equal(t, tt.expectedMessage, gjson.Get(w.Body.String(), "error.message").String())
+		})
+	}
+}
+
+func TestProxyManager_AnthropicMessages(t *testing.T) {
+	config := AddDefaultGroupToConfig(Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]ModelConfig{
+			"model1": getTestSimpleResponderConfig("model1"),
+		},
+		LogLevel: "error",
+	})
+
+	proxy := New(config)
+	defer proxy.StopProcesses()
+
+	req := httptest.NewRequest("POST", "/v1/messages", bytes.NewBufferString(`{"model":"nope","max_tokens":10,"messages":[]}`))
+	w := httptest.NewRecorder()
+	proxy.HandlerFunc(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "not_found_error", gjson.Get(w.Body.String(), "error.type").String())
+
+	req = httptest.NewRequest("POST", "/v1/messages", bytes.NewBufferString(`{"model":"model1","max_tokens":10,"messages":[{"role":"user","content":"hi"}]}`))
+	w = httptest.NewRecorder()
+	proxy.HandlerFunc(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, StateReady, proxy.processGroups[DEFAULT_GROUP_ID].processes["model1"].CurrentState())
+	assert.Equal(t, "message", gjson.Get(w.Body.String(), "type").String())
+}
+
+func TestProxyManager_AnthropicMessagesError(t *testing.T) {
+	config := Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]ModelConfig{
+			"model1": getTestSimpleResponderConfig("model1"),
+			"model2": getTestSimpleResponderConfig("model2"),
+		},
+		LogLevel:  "error",
+		RateLimit: RateLimitConfig{RequestsPerMinute: 1},
+		Auth: AuthConfig{
+			Keys: []APIKeyConfig{{Key: "sk-user", Models: []string{"model1"}}},
+		},
+	}
+	assert.NoError(t, resolveAPIKeys(&config))
+	config = AddDefaultGroupToConfig(config)
+
+	proxy := New(config)
+	defer proxy.StopProcesses()
+
+	do := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/messages", bytes.NewBufferString(`{"model":"model2","max_tokens":10,"messages":[{"role":"user","content":"hi"}]}`))
+		if key != "" {
+			req.Header.Set("x-api-key", key)
+		}
+		w := httptest.NewRecorder()
+		proxy.HandlerFunc(w, req)
+		return w
+	}
+
+	// the 401 from the auth middleware is an Anthropic error
+	w := do("")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "error", gjson.Get(w.Body.String(), "type").String())
+	assert.Equal(t, "authentication_error", gjson.Get(w.Body.String(), "error.type").String())
+	assert.Equal(t, "invalid or missing API key", gjson.Get(w.Body.String(), "error.message").String())
+
+	// the 403 from the chat completion handler is an Anthropic error
+	w = do("sk-user")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, "error", gjson.Get(w.Body.String(), "type").String())
+	assert.Equal(t, "permission_error", gjson.Get(w.Body.String(), "error.type").String())
+	assert.Equal(t, "API key is not allowed to use model model2", gjson.Get(w.Body.String(), "error.message").String())
+
+	// the 429 from the rate limit middleware is an Anthropic error
+	w = do("sk-user")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+	assert.Equal(t, "error", gjson.Get(w.Body.String(), "type").String())
+	assert.Equal(t, "rate_limit_error", gjson.Get(w.Body.String(), "error.type").String())
+	assert.NotEmpty(t, gjson.Get(w.Body.String(), "error.message").String())
+}
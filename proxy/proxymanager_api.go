@@ -0,0 +1,138 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// modelStatus describes the current state of a model's process
+func modelStatus(process *Process, processGroup *ProcessGroup) gin.H {
+	status := gin.H{
+		"model":             process.ID,
+		"group":             processGroup.id,
+		"state":             process.CurrentState(),
+		"lastStartDuration": process.LastStartDuration().Seconds(),
+		"lastRequestTime":   nil,
+		"ttl":               process.config.UnloadAfter,
+		"ttlRemaining":      nil,
+		"inFlight":          process.InFlightRequests(),
+		"queued":            process.QueueDepth(),
+		"usage":             process.Usage(),
+	}
+
+	if lastRequest := process.LastRequestHandled(); !lastRequest.IsZero() {
+		status["lastRequestTime"] = lastRequest
+	}
+
+	if remaining, ok := process.TTLRemaining(); ok {
+		status["ttlRemaining"] = remaining.Seconds()
+	}
+
+	if reason, failedAt := process.LastFailure(); reason != "" {
+		status["lastFailure"] = reason
+		status["lastFailureTime"] = failedAt
+	}
+
+	return status
+}
+
+// findProcess returns the process for a model ID or alias
+func (pm *ProxyManager) findProcess(requestedModel string) (*Process, *ProcessGroup, error) {
+	config := pm.currentConfig()
+	realModelName, found := config.RealModelName(requestedModel)
+	if !found {
+		return nil, nil, fmt.Errorf("could not find model %s", requestedModel)
+	}
+
+	processGroup := pm.findGroupByModelName(realModelName)
+	if processGroup == nil {
+		return nil, nil, fmt.Errorf("could not find process group for model %s", requestedModel)
+	}
+
+	return processGroup.processes[realModelName], processGroup, nil
+}
+
+func (pm *ProxyManager) getModelHandler(c *gin.Context) {
+	process, processGroup, err := pm.findProcess(c.Param("model_id"))
+	if err != nil {
+		pm.sendErrorResponse(c, http.StatusNotFound, err.Error())
+		return
+	}
+
+	// models the key may not use are hidden like models that do not exist
+	if apiKey := requestAPIKey(c); apiKey != nil && !apiKey.AllowsModel(process.ID) {
+		pm.sendErrorResponse(c, http.StatusNotFound, fmt.Sprintf("could not find model %s", c.Param("model_id")))
+		return
+	}
+
+	c.JSON(http.StatusOK, modelStatus(process, processGroup))
+}
+
+// loadModelHandler starts a model, swapping out other models the same way a
+// request for it would. It responds once the model is ready.
+func (pm *ProxyManager) loadModelHandler(c *gin.Context) {
+	process, _, err := pm.findProcess(c.Param("model_id"))
+	if err != nil {
+		pm.sendErrorResponse(c, http.StatusNotFound, err.Error())
+		return
+	}
+
+	if err := pm.loadModel(process.ID); err != nil {
+		pm.sendErrorResponse(c, http.StatusBadGateway, fmt.Sprintf("unable to load model %s: %v", process.ID, err))
+		return
+	}
+
+	// the process group may have been replaced by a config reload
+	process, processGroup, err := pm.findProcess(process.ID)
+	if err != nil {
+		pm.sendErrorResponse(c, http.StatusNotFound, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, modelStatus(process, processGroup))
+}
+
+// unloadModelHandler stops a model after its in-flight requests complete
+func (pm *ProxyManager) unloadModelHandler(c *gin.Context) {
+	process, processGroup, err := pm.findProcess(c.Param("model_id"))
+	if err != nil {
+		pm.sendErrorResponse(c, http.StatusNotFound, err.Error())
+		return
+	}
+
+	process.Stop()
+	c.JSON(http.StatusOK, modelStatus(process, processGroup))
+}
+
+// resetModelHandler moves a failed model back to stopped so it can be started again
+func (pm *ProxyManager) resetModelHandler(c *gin.Context) {
+	process, _, err := pm.findProcess(c.Param("model_id"))
+	if err != nil {
+		pm.sendErrorResponse(c, http.StatusNotFound, err.Error())
+		return
+	}
+
+	if err := process.Reset(); err != nil {
+		pm.sendErrorResponse(c, http.StatusConflict, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"model": process.ID,
+		"state": process.CurrentState(),
+	})
+}
+
+// usageHandler reports the token usage and throughput of each model
+func (pm *ProxyManager) usageHandler(c *gin.Context) {
+	usage := make(map[string]UsageTotals)
+	for _, processGroup := range pm.currentProcessGroups() {
+		for modelID, process := range processGroup.processes {
+			usage[modelID] = process.Usage()
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"models": usage})
+}
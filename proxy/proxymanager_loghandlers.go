@@ -1,9 +1,13 @@
 package proxy
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -25,6 +29,8 @@ func (pm *ProxyManager) sendLogsHandlers(c *gin.Context) {
 			c.String(http.StatusInternalServerError, fmt.Sprintf("failed to write response: %v", err))
 			return
 		}
+	} else if c.Query("format") == "json" {
+		c.JSON(http.StatusOK, pm.muxLogger.GetEntries())
 	} else {
 		c.Header("Content-Type", "text/plain")
 		history := pm.muxLogger.GetHistory()
@@ -36,8 +42,120 @@ func (pm *ProxyManager) sendLogsHandlers(c *gin.Context) {
 	}
 }
 
+// queryLogsHandler returns the buffered entries matching the filter in the
+// query string, as JSON or, with format=text, as text
+func (pm *ProxyManager) queryLogsHandler(c *gin.Context) {
+	filter, err := pm.parseLogFilter(c)
+	if err != nil {
+		pm.sendErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	entries := filter.Filter(pm.muxLogger.GetEntries())
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 0 {
+			pm.sendErrorResponse(c, http.StatusBadRequest, fmt.Sprintf("invalid limit %s", limit))
+			return
+		}
+		if n < len(entries) {
+			entries = entries[len(entries)-n:]
+		}
+	}
+
+	if c.Query("format") == "text" {
+		var text []byte
+		for _, entry := range entries {
+			text = append(text, pm.muxLogger.Format(entry)...)
+		}
+		c.Data(http.StatusOK, "text/plain", text)
+		return
+	}
+
+	if entries == nil {
+		entries = []LogEntry{}
+	}
+	c.JSON(http.StatusOK, entries)
+}
+
+// parseLogFilter creates a filter from the model, level, since, until and
+// grep query parameters
+func (pm *ProxyManager) parseLogFilter(c *gin.Context) (LogFilter, error) {
+	var filter LogFilter
+	now := time.Now()
+
+	if model := c.Query("model"); model != "" {
+		config := pm.currentConfig()
+		realModelName, found := config.RealModelName(model)
+		if !found {
+			return filter, fmt.Errorf("could not find model %s", model)
+		}
+		filter.Model = realModelName
+	}
+
+	if name := c.Query("level"); name != "" {
+		level, ok := ParseLogLevel(name)
+		if !ok {
+			return filter, fmt.Errorf("invalid level %s, use debug, info, warn or error", name)
+		}
+		filter.Level = level
+	}
+
+	if since := c.Query("since"); since != "" {
+		t, err := parseLogTime(since, now)
+		if err != nil {
+			return filter, err
+		}
+		filter.Since = t
+	}
+
+	if until := c.Query("until"); until != "" {
+		t, err := parseLogTime(until, now)
+		if err != nil {
+			return filter, err
+		}
+		filter.Until = t
+	}
+
+	if grep := c.Query("grep"); grep != "" {
+		re, err := regexp.Compile(grep)
+		if err != nil {
+			return filter, fmt.Errorf("invalid grep: %v", err)
+		}
+		filter.Grep = re
+	}
+
+	return filter, nil
+}
+
+// formatLogEntry returns an entry as a line of text or, with format=json, of JSON
+func formatLogEntry(c *gin.Context, logger *LogMonitor, entry LogEntry) []byte {
+	if c.Query("format") == "json" {
+		data, _ := json.Marshal(entry)
+		return append(data, '\n')
+	}
+	return logger.Format(entry)
+}
+
+// drainedDroppedEntry returns an entry telling the client about entries
+// dropped for ch once ch is empty, so a burst that is followed by silence
+// is reported without waiting for the next line
+func drainedDroppedEntry(logger *LogMonitor, ch chan LogEntry) (LogEntry, bool) {
+	if len(ch) > 0 {
+		return LogEntry{}, false
+	}
+	if dropped := logger.TakeDropped(ch); dropped > 0 {
+		return droppedLogEntry(dropped), true
+	}
+	return LogEntry{}, false
+}
+
 func (pm *ProxyManager) streamLogsHandler(c *gin.Context) {
-	c.Header("Content-Type", "text/plain")
+	if c.Query("format") == "json" {
+		c.Header("Content-Type", "application/x-ndjson")
+	} else {
+		c.Header("Content-Type", "text/plain")
+	}
 	c.Header("Transfer-Encoding", "chunked")
 	c.Header("X-Content-Type-Options", "nosniff")
 
@@ -47,7 +165,12 @@ func (pm *ProxyManager) streamLogsHandler(c *gin.Context) {
 		c.String(http.StatusBadRequest, err.Error())
 		return
 	}
-	ch := logger.Subscribe()
+	filter, err := pm.parseLogFilter(c)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	ch := logger.SubscribeFilter(filter)
 	defer logger.Unsubscribe(ch)
 
 	notify := c.Request.Context().Done()
@@ -61,7 +184,14 @@ func (pm *ProxyManager) streamLogsHandler(c *gin.Context) {
 	// Send history first if not skipped
 
 	if !skipHistory {
-		history := logger.GetHistory()
+		var history []byte
+		if c.Query("format") == "json" || !filter.IsZero() {
+			for _, entry := range filter.Filter(logger.GetEntries()) {
+				history = append(history, formatLogEntry(c, logger, entry)...)
+			}
+		} else {
+			history = logger.GetHistory()
+		}
 		if len(history) != 0 {
 			c.Writer.Write(history)
 			flusher.Flush()
@@ -71,15 +201,21 @@ func (pm *ProxyManager) streamLogsHandler(c *gin.Context) {
 	// Stream new logs
 	for {
 		select {
-		case msg := <-ch:
-			_, err := c.Writer.Write(msg)
+		case entry := <-ch:
+			_, err := c.Writer.Write(formatLogEntry(c, logger, entry))
 			if err != nil {
 				// just break the loop if we can't write for some reason
 				return
 			}
+			if dropped, ok := drainedDroppedEntry(logger, ch); ok {
+				c.Writer.Write(formatLogEntry(c, logger, dropped))
+			}
 			flusher.Flush()
 		case <-notify:
 			return
+		case <-pm.drainCtx.Done():
+			// don't hold up a graceful shutdown
+			return
 		}
 	}
 }
@@ -96,34 +232,60 @@ func (pm *ProxyManager) streamLogsHandlerSSE(c *gin.Context) {
 		c.String(http.StatusBadRequest, err.Error())
 		return
 	}
-	ch := logger.Subscribe()
+	filter, err := pm.parseLogFilter(c)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	ch := logger.SubscribeFilter(filter)
 	defer logger.Unsubscribe(ch)
 
 	notify := c.Request.Context().Done()
 
 	// Send history first if not skipped
 	_, skipHistory := c.GetQuery("no-history")
+	// with format=json each event is one entry
 	if !skipHistory {
-		history := logger.GetHistory()
-		if len(history) != 0 {
-			c.SSEvent("message", string(history))
-			c.Writer.Flush()
+		if c.Query("format") == "json" {
+			for _, entry := range filter.Filter(logger.GetEntries()) {
+				c.SSEvent("message", string(formatLogEntry(c, logger, entry)))
+			}
+		} else if filter.IsZero() {
+			if history := logger.GetHistory(); len(history) != 0 {
+				c.SSEvent("message", string(history))
+			}
+		} else {
+			var history []byte
+			for _, entry := range filter.Filter(logger.GetEntries()) {
+				history = append(history, logger.Format(entry)...)
+			}
+			if len(history) != 0 {
+				c.SSEvent("message", string(history))
+			}
 		}
+		c.Writer.Flush()
 	}
 
 	// Stream new logs
 	for {
 		select {
-		case msg := <-ch:
-			c.SSEvent("message", string(msg))
+		case entry := <-ch:
+			c.SSEvent("message", string(formatLogEntry(c, logger, entry)))
+			if dropped, ok := drainedDroppedEntry(logger, ch); ok {
+				c.SSEvent("message", string(formatLogEntry(c, logger, dropped)))
+			}
 			c.Writer.Flush()
 		case <-notify:
 			return
+		case <-pm.drainCtx.Done():
+			// don't hold up a graceful shutdown
+			return
 		}
 	}
 }
 
-// getLogger searches for the appropriate logger based on the logMonitorId
+// getLogger searches for the appropriate logger based on the logMonitorId,
+// which is "proxy", "upstream" or a model ID or alias
 func (pm *ProxyManager) getLogger(logMonitorId string) (*LogMonitor, error) {
 	var logger *LogMonitor
 
@@ -134,8 +296,10 @@ func (pm *ProxyManager) getLogger(logMonitorId string) (*LogMonitor, error) {
 		logger = pm.proxyLogger
 	} else if logMonitorId == "upstream" {
 		logger = pm.upstreamLogger
+	} else if process, _, err := pm.findProcess(logMonitorId); err == nil {
+		logger = process.LogMonitor()
 	} else {
-		return nil, fmt.Errorf("invalid logger. Use 'proxy' or 'upstream'")
+		return nil, fmt.Errorf("invalid logger. Use 'proxy', 'upstream' or a model ID")
 	}
 
 	return logger, nil
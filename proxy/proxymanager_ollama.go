@@ -0,0 +1,630 @@
+package proxy
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// Ollama API compatible endpoints. Requests are translated to the OpenAI API
+// served by the upstreams and the responses translated back.
+// See: https://github.com/ollama/ollama/blob/main/docs/api.md
+
+type ollamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	Thinking  string           `json:"thinking,omitempty"`
+	Images    []string         `json:"images,omitempty"`
+	ToolCalls []ollamaToolCall `json:"tool_calls,omitempty"`
+}
+
+type ollamaToolCall struct {
+	Function ollamaToolCallFunction `json:"function"`
+}
+
+type ollamaToolCallFunction struct {
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+type ollamaChatRequest struct {
+	Model    string          `json:"model"`
+	Messages []ollamaMessage `json:"messages"`
+	Tools    json.RawMessage `json:"tools"`
+	Format   json.RawMessage `json:"format"`
+	Options  map[string]any  `json:"options"`
+	Stream   *bool           `json:"stream"`
+}
+
+type ollamaGenerateRequest struct {
+	Model   string          `json:"model"`
+	Prompt  string          `json:"prompt"`
+	System  string          `json:"system"`
+	Images  []string        `json:"images"`
+	Format  json.RawMessage `json:"format"`
+	Options map[string]any  `json:"options"`
+	Stream  *bool           `json:"stream"`
+}
+
+type ollamaEmbedRequest struct {
+	Model string          `json:"model"`
+	Input json.RawMessage `json:"input"`
+}
+
+// ollamaOptions maps Ollama model options to OpenAI request fields
+var ollamaOptions = map[string]string{
+	"temperature":       "temperature",
+	"top_p":             "top_p",
+	"top_k":             "top_k",
+	"min_p":             "min_p",
+	"seed":              "seed",
+	"stop":              "stop",
+	"num_predict":       "max_tokens",
+	"repeat_penalty":    "repeat_penalty",
+	"presence_penalty":  "presence_penalty",
+	"frequency_penalty": "frequency_penalty",
+}
+
+func sendOllamaError(c *gin.Context, statusCode int, message string) {
+	c.JSON(statusCode, gin.H{"error": message})
+}
+
+// resolveOllamaModel returns the model name to use for an Ollama request.
+// Ollama clients often add the default :latest tag.
+func (pm *ProxyManager) resolveOllamaModel(name string) (string, bool) {
+	config := pm.currentConfig()
+	if _, found := config.RealModelName(name); found {
+		return name, true
+	}
+
+	trimmed := strings.TrimSuffix(name, ":latest")
+	if _, found := config.RealModelName(trimmed); found {
+		return trimmed, true
+	}
+	return name, false
+}
+
+func ollamaModelDetails() gin.H {
+	return gin.H{
+		"format":             "gguf",
+		"family":             "",
+		"families":           nil,
+		"parameter_size":     "",
+		"quantization_level": "",
+	}
+}
+
+func (pm *ProxyManager) ollamaTagsHandler(c *gin.Context) {
+	apiKey := requestAPIKey(c)
+	modifiedAt := time.Now().UTC().Format(time.RFC3339)
+
+	var ids []string
+	for id, modelConfig := range pm.currentConfig().Models {
+		if modelConfig.Unlisted || (apiKey != nil && !apiKey.AllowsModel(id)) {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	models := make([]gin.H, 0, len(ids))
+	for _, id := range ids {
+		models = append(models, gin.H{
+			"name":        id,
+			"model":       id,
+			"modified_at": modifiedAt,
+			"size":        0,
+			"digest":      "",
+			"details":     ollamaModelDetails(),
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"models": models})
+}
+
+func (pm *ProxyManager) ollamaShowHandler(c *gin.Context) {
+	var req struct {
+		Model string `json:"model"`
+		Name  string `json:"name"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		sendOllamaError(c, http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+	if req.Model == "" {
+		req.Model = req.Name
+	}
+
+	model, found := pm.resolveOllamaModel(req.Model)
+	if !found || !pm.checkModelAccess(c, model) {
+		if !c.IsAborted() && !c.Writer.Written() {
+			sendOllamaError(c, http.StatusNotFound, fmt.Sprintf("model '%s' not found", req.Model))
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"modelfile":    "",
+		"parameters":   "",
+		"template":     "",
+		"details":      ollamaModelDetails(),
+		"model_info":   gin.H{},
+		"capabilities": []string{"completion"},
+		"modified_at":  time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// ollamaPsHandler lists the models that are loaded
+func (pm *ProxyManager) ollamaPsHandler(c *gin.Context) {
+	apiKey := requestAPIKey(c)
+	models := make([]gin.H, 0)
+	for _, processGroup := range pm.currentProcessGroups() {
+		for _, process := range processGroup.processes {
+			if process.CurrentState() != StateReady || (apiKey != nil && !apiKey.AllowsModel(process.ID)) {
+				continue
+			}
+
+			var expiresAt time.Time
+			if remaining, ok := process.TTLRemaining(); ok {
+				expiresAt = time.Now().Add(remaining)
+			}
+
+			models = append(models, gin.H{
+				"name":       process.ID,
+				"model":      process.ID,
+				"size":       0,
+				"digest":     "",
+				"details":    ollamaModelDetails(),
+				"expires_at": expiresAt.UTC().Format(time.RFC3339),
+				"size_vram":  0,
+			})
+		}
+	}
+
+	sort.Slice(models, func(i, j int) bool {
+		return models[i]["name"].(string) < models[j]["name"].(string)
+	})
+	c.JSON(http.StatusOK, gin.H{"models": models})
+}
+
+func (pm *ProxyManager) ollamaChatHandler(c *gin.Context) {
+	var req ollamaChatRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		sendOllamaError(c, http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+
+	model, found := pm.resolveOllamaModel(req.Model)
+	if !found {
+		sendOllamaError(c, http.StatusNotFound, fmt.Sprintf("model '%s' not found", req.Model))
+		return
+	}
+
+	stream := req.Stream == nil || *req.Stream
+	body := gin.H{
+		"model":    model,
+		"messages": ollamaToOpenAIMessages(req.Messages),
+		"stream":   stream,
+	}
+	if len(req.Tools) > 0 && string(req.Tools) != "null" {
+		body["tools"] = req.Tools
+	}
+	applyOllamaOptions(body, req.Format, req.Options, stream)
+
+	bodyBytes, err := json.Marshal(body)
+	if err != nil {
+		sendOllamaError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	pm.proxyTranslatedRequest(c, "/v1/chat/completions", bodyBytes, newOllamaTranslator(c, req.Model, false))
+}
+
+func (pm *ProxyManager) ollamaGenerateHandler(c *gin.Context) {
+	var req ollamaGenerateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		sendOllamaError(c, http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+
+	model, found := pm.resolveOllamaModel(req.Model)
+	if !found {
+		sendOllamaError(c, http.StatusNotFound, fmt.Sprintf("model '%s' not found", req.Model))
+		return
+	}
+
+	// an empty prompt loads the model
+	if req.Prompt == "" && len(req.Images) == 0 {
+		if !pm.checkModelAccess(c, model) {
+			return
+		}
+		if err := pm.loadModel(model); err != nil {
+			sendOllamaError(c, http.StatusInternalServerError, fmt.Sprintf("unable to load model: %v", err))
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"model":       req.Model,
+			"created_at":  time.Now().UTC().Format(time.RFC3339Nano),
+			"response":    "",
+			"done":        true,
+			"done_reason": "load",
+		})
+		return
+	}
+
+	var messages []ollamaMessage
+	if req.System != "" {
+		messages = append(messages, ollamaMessage{Role: "system", Content: req.System})
+	}
+	messages = append(messages, ollamaMessage{Role: "user", Content: req.Prompt, Images: req.Images})
+
+	stream := req.Stream == nil || *req.Stream
+	body := gin.H{
+		"model":    model,
+		"messages": ollamaToOpenAIMessages(messages),
+		"stream":   stream,
+	}
+	applyOllamaOptions(body, req.Format, req.Options, stream)
+
+	bodyBytes, err := json.Marshal(body)
+	if err != nil {
+		sendOllamaError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	pm.proxyTranslatedRequest(c, "/v1/chat/completions", bodyBytes, newOllamaTranslator(c, req.Model, true))
+}
+
+func (pm *ProxyManager) ollamaEmbedHandler(c *gin.Context) {
+	var req ollamaEmbedRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		sendOllamaError(c, http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+
+	model, found := pm.resolveOllamaModel(req.Model)
+	if !found {
+		sendOllamaError(c, http.StatusNotFound, fmt.Sprintf("model '%s' not found", req.Model))
+		return
+	}
+
+	bodyBytes, err := json.Marshal(gin.H{"model": model, "input": req.Input})
+	if err != nil {
+		sendOllamaError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	pm.proxyTranslatedRequest(c, "/v1/embeddings", bodyBytes, &ollamaEmbedTranslator{model: req.Model})
+}
+
+// ollamaToOpenAIMessages converts messages, images become image_url content
+// parts and tool calls are given the ids OpenAI requires
+func ollamaToOpenAIMessages(messages []ollamaMessage) []gin.H {
+	var pendingCallIDs []string
+	callCount := 0
+
+	out := make([]gin.H, 0, len(messages))
+	for _, m := range messages {
+		msg := gin.H{"role": m.Role, "content": m.Content}
+
+		if len(m.Images) > 0 {
+			parts := []gin.H{{"type": "text", "text": m.Content}}
+			for _, image := range m.Images {
+				parts = append(parts, gin.H{
+					"type":      "image_url",
+					"image_url": gin.H{"url": imageDataURL(image)},
+				})
+			}
+			msg["content"] = parts
+		}
+
+		if len(m.ToolCalls) > 0 {
+			toolCalls := make([]gin.H, 0, len(m.ToolCalls))
+			for _, tc := range m.ToolCalls {
+				callCount++
+				id := fmt.Sprintf("call_%d", callCount)
+				pendingCallIDs = append(pendingCallIDs, id)
+
+				arguments := string(tc.Function.Arguments)
+				if arguments == "" {
+					arguments = "{}"
+				}
+				toolCalls = append(toolCalls, gin.H{
+					"id":   id,
+					"type": "function",
+					"function": gin.H{
+						"name":      tc.Function.Name,
+						"arguments": arguments,
+					},
+				})
+			}
+			msg["tool_calls"] = toolCalls
+		}
+
+		// tool results answer the tool calls in order
+		if m.Role == "tool" && len(pendingCallIDs) > 0 {
+			msg["tool_call_id"] = pendingCallIDs[0]
+			pendingCallIDs = pendingCallIDs[1:]
+		}
+
+		out = append(out, msg)
+	}
+	return out
+}
+
+// imageDataURL turns a base64 encoded image into a data URL
+func imageDataURL(image string) string {
+	if strings.HasPrefix(image, "data:") {
+		return image
+	}
+
+	mimeType := "image/jpeg"
+	prefix := image
+	if len(prefix) > 64 {
+		prefix = prefix[:64]
+	}
+	if data, err := base64.StdEncoding.DecodeString(prefix[:len(prefix)/4*4]); err == nil {
+		if detected := http.DetectContentType(data); strings.HasPrefix(detected, "image/") {
+			mimeType = detected
+		}
+	}
+	return "data:" + mimeType + ";base64," + image
+}
+
+// applyOllamaOptions sets the OpenAI request fields for the Ollama format and options
+func applyOllamaOptions(body gin.H, format json.RawMessage, options map[string]any, stream bool) {
+	switch {
+	case len(format) == 0 || string(format) == "null" || string(format) == `""`:
+	case string(format) == `"json"`:
+		body["response_format"] = gin.H{"type": "json_object"}
+	default:
+		body["response_format"] = gin.H{
+			"type":        "json_schema",
+			"json_schema": gin.H{"name": "response", "schema": format},
+		}
+	}
+
+	for option, value := range options {
+		if field, ok := ollamaOptions[option]; ok {
+			body[field] = value
+		}
+	}
+
+	if stream {
+		body["stream_options"] = gin.H{"include_usage": true}
+	}
+}
+
+// ollamaTranslator translates chat completions into /api/chat or
+// /api/generate responses
+type ollamaTranslator struct {
+	model    string
+	generate bool
+	start    time.Time
+	stats    *requestStats
+
+	doneReason string
+	usage      gjson.Result
+	timings    gjson.Result
+	toolCalls  []*streamedToolCall
+}
+
+// streamedToolCall accumulates a tool call sent in pieces
+type streamedToolCall struct {
+	id        string
+	name      string
+	arguments strings.Builder
+}
+
+func newOllamaTranslator(c *gin.Context, model string, generate bool) *ollamaTranslator {
+	return &ollamaTranslator{
+		model:    model,
+		generate: generate,
+		start:    time.Now(),
+		stats:    requestStatsFromContext(c.Request.Context()),
+	}
+}
+
+func (t *ollamaTranslator) contentType(streaming bool) string {
+	if streaming {
+		return "application/x-ndjson"
+	}
+	return "application/json"
+}
+
+func (t *ollamaTranslator) streamChunk(w io.Writer, data []byte) error {
+	chunk := gjson.ParseBytes(data)
+	if usage := chunk.Get("usage"); usage.IsObject() {
+		t.usage = usage
+	}
+	if timings := chunk.Get("timings"); timings.IsObject() {
+		t.timings = timings
+	}
+
+	choice := chunk.Get("choices.0")
+	if reason := choice.Get("finish_reason").String(); reason != "" {
+		t.doneReason = reason
+	}
+
+	for _, tc := range choice.Get("delta.tool_calls").Array() {
+		index := int(tc.Get("index").Int())
+		for len(t.toolCalls) <= index {
+			t.toolCalls = append(t.toolCalls, &streamedToolCall{})
+		}
+		call := t.toolCalls[index]
+		if id := tc.Get("id").String(); id != "" {
+			call.id = id
+		}
+		if name := tc.Get("function.name").String(); name != "" {
+			call.name = name
+		}
+		call.arguments.WriteString(tc.Get("function.arguments").String())
+	}
+
+	content := choice.Get("delta.content").String()
+	thinking := choice.Get("delta.reasoning_content").String()
+	if content == "" && thinking == "" {
+		return nil
+	}
+	return t.writeLine(w, t.message(content, thinking, nil))
+}
+
+func (t *ollamaTranslator) streamEnd(w io.Writer) error {
+	if len(t.toolCalls) > 0 {
+		toolCalls := make([]ollamaToolCall, 0, len(t.toolCalls))
+		for _, call := range t.toolCalls {
+			toolCalls = append(toolCalls, ollamaToolCall{Function: ollamaToolCallFunction{
+				Name:      call.name,
+				Arguments: toolCallArguments(call.arguments.String()),
+			}})
+		}
+		if err := t.writeLine(w, t.message("", "", toolCalls)); err != nil {
+			return err
+		}
+	}
+
+	return t.writeLine(w, t.done(t.message("", "", nil)))
+}
+
+func (t *ollamaTranslator) response(w io.Writer, body []byte) error {
+	result := gjson.ParseBytes(body)
+	t.usage = result.Get("usage")
+	t.timings = result.Get("timings")
+
+	choice := result.Get("choices.0")
+	t.doneReason = choice.Get("finish_reason").String()
+
+	var toolCalls []ollamaToolCall
+	for _, tc := range choice.Get("message.tool_calls").Array() {
+		toolCalls = append(toolCalls, ollamaToolCall{Function: ollamaToolCallFunction{
+			Name:      tc.Get("function.name").String(),
+			Arguments: toolCallArguments(tc.Get("function.arguments").String()),
+		}})
+	}
+
+	message := t.message(
+		choice.Get("message.content").String(),
+		choice.Get("message.reasoning_content").String(),
+		toolCalls,
+	)
+	return json.NewEncoder(w).Encode(t.done(message))
+}
+
+// message returns a response object with the content
+func (t *ollamaTranslator) message(content, thinking string, toolCalls []ollamaToolCall) gin.H {
+	response := gin.H{
+		"model":      t.model,
+		"created_at": time.Now().UTC().Format(time.RFC3339Nano),
+		"done":       false,
+	}
+
+	if t.generate {
+		response["response"] = content
+		if thinking != "" {
+			response["thinking"] = thinking
+		}
+	} else {
+		response["message"] = ollamaMessage{
+			Role:      "assistant",
+			Content:   content,
+			Thinking:  thinking,
+			ToolCalls: toolCalls,
+		}
+	}
+	return response
+}
+
+// done adds the final fields and statistics to a response
+func (t *ollamaTranslator) done(response gin.H) gin.H {
+	doneReason := t.doneReason
+	if doneReason == "" || doneReason == "tool_calls" {
+		doneReason = "stop"
+	}
+
+	promptTokens := t.usage.Get("prompt_tokens").Int()
+	if !t.usage.Exists() {
+		promptTokens = t.timings.Get("prompt_n").Int()
+	}
+	completionTokens := t.usage.Get("completion_tokens").Int()
+	if !t.usage.Exists() {
+		completionTokens = t.timings.Get("predicted_n").Int()
+	}
+
+	var loadDuration time.Duration
+	if t.stats != nil {
+		loadDuration = t.stats.startDuration
+	}
+
+	response["done"] = true
+	response["done_reason"] = doneReason
+	response["total_duration"] = time.Since(t.start).Nanoseconds()
+	response["load_duration"] = loadDuration.Nanoseconds()
+	response["prompt_eval_count"] = promptTokens
+	response["prompt_eval_duration"] = int64(t.timings.Get("prompt_ms").Float() * float64(time.Millisecond))
+	response["eval_count"] = completionTokens
+	response["eval_duration"] = int64(t.timings.Get("predicted_ms").Float() * float64(time.Millisecond))
+	return response
+}
+
+func (t *ollamaTranslator) writeLine(w io.Writer, response gin.H) error {
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		return err
+	}
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+// toolCallArguments returns OpenAI's JSON encoded string arguments as the
+// object Ollama uses
+func toolCallArguments(arguments string) json.RawMessage {
+	if arguments == "" {
+		return json.RawMessage("{}")
+	}
+	if gjson.Valid(arguments) {
+		return json.RawMessage(arguments)
+	}
+	encoded, _ := json.Marshal(arguments)
+	return encoded
+}
+
+// ollamaEmbedTranslator translates /v1/embeddings responses into /api/embed responses
+type ollamaEmbedTranslator struct {
+	model string
+}
+
+func (t *ollamaEmbedTranslator) contentType(bool) string {
+	return "application/json"
+}
+
+func (t *ollamaEmbedTranslator) streamChunk(io.Writer, []byte) error {
+	return fmt.Errorf("embeddings can not be streamed")
+}
+
+func (t *ollamaEmbedTranslator) streamEnd(io.Writer) error {
+	return nil
+}
+
+func (t *ollamaEmbedTranslator) response(w io.Writer, body []byte) error {
+	result := gjson.ParseBytes(body)
+
+	embeddings := make([]json.RawMessage, 0)
+	for _, item := range result.Get("data").Array() {
+		embeddings = append(embeddings, json.RawMessage(item.Get("embedding").Raw))
+	}
+
+	return json.NewEncoder(w).Encode(gin.H{
+		"model":             t.model,
+		"embeddings":        embeddings,
+		"prompt_eval_count": result.Get("usage.prompt_tokens").Int(),
+	})
+}
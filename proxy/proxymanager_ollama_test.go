@@ -0,0 +1,167 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func TestOllamaToOpenAIMessages(t *testing.T) {
+	png := "iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mNk"
+	messages := ollamaToOpenAIMessages([]ollamaMessage{
+		{Role: "user", Content: "what is this?", Images: []string{png}},
+		{Role: "assistant", ToolCalls: []ollamaToolCall{
+			{Function: ollamaToolCallFunction{Name: "a", Arguments: []byte(`{"x":1}`)}},
+			{Function: ollamaToolCallFunction{Name: "b"}},
+		}},
+		{Role: "tool", Content: "result a"},
+		{Role: "tool", Content: "result b"},
+	})
+
+	assert.Len(t, messages, 4)
+	parts := messages[0]["content"].([]gin.H)
+	assert.Equal(t, "what is this?", parts[0]["text"])
+	assert.Equal(t, "data:image/png;base64,"+png, parts[1]["image_url"].(gin.H)["url"])
+
+	toolCalls := messages[1]["tool_calls"].([]gin.H)
+	assert.Equal(t, `{"x":1}`, toolCalls[0]["function"].(gin.H)["arguments"])
+	assert.Equal(t, "{}", toolCalls[1]["function"].(gin.H)["arguments"])
+	assert.Equal(t, toolCalls[0]["id"], messages[2]["tool_call_id"])
+	assert.Equal(t, toolCalls[1]["id"], messages[3]["tool_call_id"])
+}
+
+func TestApplyOllamaOptions(t *testing.T) {
+	body := gin.H{}
+	applyOllamaOptions(body, []byte(`"json"`), map[string]any{"num_predict": 10, "temperature": 0.5, "num_ctx": 4096}, true)
+	assert.Equal(t, gin.H{
+		"response_format": gin.H{"type": "json_object"},
+		"max_tokens":      10,
+		"temperature":     0.5,
+		"stream_options":  gin.H{"include_usage": true},
+	}, body)
+
+	body = gin.H{}
+	applyOllamaOptions(body, []byte(`{"type":"object"}`), nil, false)
+	assert.Equal(t, "json_schema", body["response_format"].(gin.H)["type"])
+}
+
+func TestOllamaTranslator_Stream(t *testing.T) {
+	translator := &ollamaTranslator{model: "model1:latest"}
+	w := translateResponse(t, translator, http.StatusOK, "text/event-stream",
+		"data: {\"choices\":[{\"delta\":{\"role\":\"assistant\"}}]}\n\n",
+		"data: {\"choices\":[{\"delta\":{\"content\":\"Hel\"}}]}\n\ndata: {\"choices\":[{\"delta\":{\"con",
+		"tent\":\"lo\"}}]}\n\n",
+		"data: {\"choices\":[{\"delta\":{\"tool_calls\":[{\"index\":0,\"id\":\"c1\",\"function\":{\"name\":\"f\",\"arguments\":\"{\\\"a\\\"\"}}]}}]}\n\n",
+		"data: {\"choices\":[{\"delta\":{\"tool_calls\":[{\"index\":0,\"function\":{\"arguments\":\":1}\"}}]}}]}\n\n",
+		"data: {\"choices\":[{\"delta\":{},\"finish_reason\":\"tool_calls\"}]}\n\n",
+		"data: {\"choices\":[],\"usage\":{\"prompt_tokens\":3,\"completion_tokens\":4},\"timings\":{\"prompt_ms\":1.5,\"predicted_ms\":20}}\n\n",
+		"data: [DONE]\n\n",
+	)
+
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if !assert.Len(t, lines, 4) {
+		return
+	}
+
+	assert.Equal(t, "model1:latest", gjson.Get(lines[0], "model").String())
+	assert.Equal(t, "Hel", gjson.Get(lines[0], "message.content").String())
+	assert.False(t, gjson.Get(lines[0], "done").Bool())
+	assert.Equal(t, "lo", gjson.Get(lines[1], "message.content").String())
+	assert.Equal(t, "f", gjson.Get(lines[2], "message.tool_calls.0.function.name").String())
+	assert.Equal(t, int64(1), gjson.Get(lines[2], "message.tool_calls.0.function.arguments.a").Int())
+
+	assert.True(t, gjson.Get(lines[3], "done").Bool())
+	assert.Equal(t, "stop", gjson.Get(lines[3], "done_reason").String())
+	assert.Equal(t, int64(3), gjson.Get(lines[3], "prompt_eval_count").Int())
+	assert.Equal(t, int64(4), gjson.Get(lines[3], "eval_count").Int())
+	assert.Equal(t, int64(1_500_000), gjson.Get(lines[3], "prompt_eval_duration").Int())
+	assert.Equal(t, int64(20_000_000), gjson.Get(lines[3], "eval_duration").Int())
+}
+
+func TestOllamaTranslator_Response(t *testing.T) {
+	translator := &ollamaTranslator{model: "model1", generate: true}
+	w := translateResponse(t, translator, http.StatusOK, "application/json",
+		`{"choices":[{"message":{"role":"assistant","content":"Hello"},"finish_reason":"length"}],`,
+		`"usage":{"prompt_tokens":3,"completion_tokens":4}}`,
+	)
+
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	body := w.Body.String()
+	assert.Equal(t, "Hello", gjson.Get(body, "response").String())
+	assert.False(t, gjson.Get(body, "message").Exists())
+	assert.True(t, gjson.Get(body, "done").Bool())
+	assert.Equal(t, "length", gjson.Get(body, "done_reason").String())
+	assert.Equal(t, int64(4), gjson.Get(body, "eval_count").Int())
+}
+
+func TestOllamaTranslator_ErrorPassthrough(t *testing.T) {
+	w := translateResponse(t, &ollamaTranslator{}, http.StatusBadRequest, "application/json", `{"error":"bad"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.Equal(t, `{"error":"bad"}`, w.Body.String())
+}
+
+func TestOllamaEmbedTranslator(t *testing.T) {
+	w := translateResponse(t, &ollamaEmbedTranslator{model: "embed"}, http.StatusOK, "application/json",
+		`{"data":[{"embedding":[0.1,0.2]},{"embedding":[0.3]}],"usage":{"prompt_tokens":6}}`)
+	assert.JSONEq(t, `{"model":"embed","embeddings":[[0.1,0.2],[0.3]],"prompt_eval_count":6}`, w.Body.String())
+}
+
+func TestProxyManager_OllamaAPI(t *testing.T) {
+	model2Config := getTestSimpleResponderConfig("model2")
+	model2Config.Unlisted = true
+
+	config := AddDefaultGroupToConfig(Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]ModelConfig{
+			"model1": getTestSimpleResponderConfig("model1"),
+			"model2": model2Config,
+		},
+		LogLevel: "error",
+	})
+
+	proxy := New(config)
+	defer proxy.StopProcesses()
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		w := httptest.NewRecorder()
+		proxy.HandlerFunc(w, req)
+		return w
+	}
+
+	w := do("GET", "/api/tags", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `["model1"]`, gjson.Get(w.Body.String(), "models.#.name").Raw)
+
+	w = do("GET", "/api/ps", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `[]`, gjson.Get(w.Body.String(), "models").Raw)
+
+	w = do("POST", "/api/show", `{"model":"model1:latest"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "gguf", gjson.Get(w.Body.String(), "details.format").String())
+
+	w = do("POST", "/api/show", `{"model":"nope"}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "model 'nope' not found", gjson.Get(w.Body.String(), "error").String())
+
+	// an empty prompt loads the model
+	w = do("POST", "/api/generate", `{"model":"model1:latest"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "load", gjson.Get(w.Body.String(), "done_reason").String())
+	assert.Equal(t, StateReady, proxy.processGroups[DEFAULT_GROUP_ID].processes["model1"].CurrentState())
+
+	w = do("GET", "/api/ps", "")
+	assert.Equal(t, `["model1"]`, gjson.Get(w.Body.String(), "models.#.name").Raw)
+
+	w = do("POST", "/api/chat", `{"model":"nope","messages":[]}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
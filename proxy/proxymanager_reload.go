@@ -0,0 +1,224 @@
+package proxy
+
+import (
+	"os"
+	"reflect"
+	"time"
+)
+
+// ReloadConfigFile loads the configuration at path and applies it with
+// ReloadConfig. An invalid configuration is logged and rejected, leaving
+// the current configuration active.
+func (pm *ProxyManager) ReloadConfigFile(path string) error {
+	newConfig, err := loadConfig(path, pm.currentConfig())
+	if err != nil {
+		pm.proxyLogger.Errorf("Config reload rejected, keeping current config. Error loading %s: %v", path, err)
+		return err
+	}
+
+	pm.proxyLogger.Infof("Reloading config from %s", path)
+	pm.ReloadConfig(newConfig)
+	return nil
+}
+
+// ReloadConfig replaces the active configuration with newConfig. Only the
+// process groups and processes whose configuration changed are rebuilt.
+// Processes with an unchanged ModelConfig are carried over as-is, keeping
+// them running. Processes that were removed or changed are stopped in the
+// background after their in-flight requests complete.
+func (pm *ProxyManager) ReloadConfig(newConfig Config) {
+	pm.Lock()
+	defer pm.Unlock()
+
+	pm.configMutex.Lock()
+	oldConfig := pm.config
+	oldGroups := pm.processGroups
+
+	// index the current processes so they can be found if a model moves groups
+	oldProcesses := make(map[string]*Process)
+	for _, processGroup := range oldGroups {
+		for modelID, process := range processGroup.processes {
+			oldProcesses[modelID] = process
+		}
+	}
+
+	kept := make(map[*Process]bool)
+	rebuilt := make(map[string]bool)
+	newGroups := make(map[string]*ProcessGroup)
+	for groupID := range newConfig.Groups {
+		if oldGroup, found := oldGroups[groupID]; found && !groupConfigChanged(oldConfig, newConfig, groupID) {
+			pm.proxyLogger.Debugf("Config reload: group %s unchanged", groupID)
+			newGroups[groupID] = oldGroup
+			for _, process := range oldGroup.processes {
+				kept[process] = true
+			}
+			continue
+		}
+
+		pm.proxyLogger.Infof("Config reload: rebuilding group %s", groupID)
+		rebuilt[groupID] = true
+		processGroup := NewProcessGroup(groupID, newConfig, pm.proxyLogger, pm.upstreamLogger)
+		for modelID := range processGroup.processes {
+			oldProcess, found := oldProcesses[modelID]
+			if !found {
+				continue
+			}
+			if !modelConfigUnchanged(oldConfig, newConfig, modelID) {
+				// keep the model's log so its history and streams continue
+				processGroup.processes[modelID].processLogger = oldProcess.processLogger
+				continue
+			}
+
+			processGroup.processes[modelID] = oldProcess
+			kept[oldProcess] = true
+
+			// carry over the loaded process so swapping still unloads it
+			if oldProcess.CurrentState() != StateStopped && processGroup.lastUsedProcess == "" {
+				processGroup.lastUsedProcess = modelID
+			}
+		}
+
+		// a swap group only runs one process at a time
+		if processGroup.swap {
+			for modelID, process := range processGroup.processes {
+				if modelID != processGroup.lastUsedProcess && kept[process] && process.CurrentState() != StateStopped {
+					pm.proxyLogger.Infof("Config reload: <%s> stopping, group %s only runs one model at a time", modelID, groupID)
+					go process.Stop()
+				}
+			}
+		}
+
+		newGroups[groupID] = processGroup
+	}
+
+	// processes of rebuilt groups don't start until the retired processes
+	// they would run alongside have let go of their port and memory
+	for groupID := range rebuilt {
+		processGroup := newGroups[groupID]
+		for modelID, process := range processGroup.processes {
+			var retired []*Process
+			if processGroup.swap {
+				retired = retiredMembers(processGroup, oldGroups[groupID], oldProcesses, kept)
+			} else if oldProcess, found := oldProcesses[modelID]; found && !kept[oldProcess] {
+				retired = []*Process{oldProcess}
+			}
+			process.waitForRetiredProcesses(retired...)
+		}
+	}
+
+	pm.config = newConfig
+	pm.processGroups = newGroups
+	pm.configMutex.Unlock()
+
+	pm.setLogLevel(newConfig.LogLevel)
+	pm.setLogBufferSize(newConfig.logBufferSize(), newConfig.logStreamBufferSize())
+	pm.addModelLogFiles()
+	pm.setRestartFuncs()
+
+	// stop processes that were removed or had their configuration changed.
+	// Retire() waits for in-flight requests so they are allowed to finish,
+	// processes that are still starting are stopped right away.
+	for modelID, process := range oldProcesses {
+		if kept[process] {
+			continue
+		}
+
+		pm.proxyLogger.Infof("Config reload: <%s> removed or changed, stopping", modelID)
+		go process.Retire()
+	}
+}
+
+// WatchConfigFile polls the configuration file at path and reloads it
+// when it changes. Polling stops when the ProxyManager is shut down.
+func (pm *ProxyManager) WatchConfigFile(path string, interval time.Duration) {
+	lastStat, err := os.Stat(path)
+	if err != nil {
+		pm.proxyLogger.Warnf("Unable to watch config file %s: %v", path, err)
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-pm.shutdownCtx.Done():
+				return
+			case <-ticker.C:
+				stat, err := os.Stat(path)
+				if err != nil {
+					continue
+				}
+
+				if lastStat != nil && stat.ModTime().Equal(lastStat.ModTime()) && stat.Size() == lastStat.Size() {
+					continue
+				}
+
+				lastStat = stat
+				pm.proxyLogger.Infof("Config file %s changed", path)
+				pm.ReloadConfigFile(path)
+			}
+		}
+	}()
+}
+
+// retiredMembers returns the retired processes of the group's members and
+// of the members of the group it replaces
+func retiredMembers(processGroup *ProcessGroup, oldGroup *ProcessGroup, oldProcesses map[string]*Process, kept map[*Process]bool) []*Process {
+	var retired []*Process
+	seen := make(map[*Process]bool)
+	add := func(process *Process) {
+		if process != nil && !kept[process] && !seen[process] {
+			seen[process] = true
+			retired = append(retired, process)
+		}
+	}
+
+	for modelID := range processGroup.processes {
+		add(oldProcesses[modelID])
+	}
+	if oldGroup != nil {
+		for _, process := range oldGroup.processes {
+			add(process)
+		}
+	}
+	return retired
+}
+
+// groupConfigChanged returns true if the group's configuration, or the
+// configuration of any of its members, differs between the two configs
+func groupConfigChanged(oldConfig, newConfig Config, groupID string) bool {
+	oldGroup, found := oldConfig.Groups[groupID]
+	if !found {
+		return true
+	}
+
+	newGroup := newConfig.Groups[groupID]
+	if !reflect.DeepEqual(oldGroup, newGroup) {
+		return true
+	}
+
+	for _, modelID := range newGroup.Members {
+		if !modelConfigUnchanged(oldConfig, newConfig, modelID) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// modelConfigUnchanged returns true if a process created for modelID with
+// oldConfig would be identical to one created with newConfig
+func modelConfigUnchanged(oldConfig, newConfig Config, modelID string) bool {
+	if oldConfig.HealthCheckTimeout != newConfig.HealthCheckTimeout {
+		return false
+	}
+
+	oldModel, oldFound := oldConfig.Models[modelID]
+	newModel, newFound := newConfig.Models[modelID]
+	if !oldFound || !newFound {
+		return false
+	}
+
+	return reflect.DeepEqual(oldModel, newModel)
+}
@@ -0,0 +1,744 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// OpenAI Responses API compatible endpoint. Requests are translated to a
+// chat completion and the response translated back. Conversations are kept
+// in pm.responseStore so they can be continued with previous_response_id.
+// See: https://platform.openai.com/docs/api-reference/responses
+
+type responsesRequest struct {
+	Model              string          `json:"model"`
+	Input              json.RawMessage `json:"input"`
+	Instructions       string          `json:"instructions"`
+	PreviousResponseID string          `json:"previous_response_id"`
+	Stream             bool            `json:"stream"`
+	Store              *bool           `json:"store"`
+	MaxOutputTokens    int             `json:"max_output_tokens"`
+	Temperature        *float64        `json:"temperature"`
+	TopP               *float64        `json:"top_p"`
+	Tools              []responsesTool `json:"tools"`
+	ToolChoice         json.RawMessage `json:"tool_choice"`
+	Text               struct {
+		Format json.RawMessage `json:"format"`
+	} `json:"text"`
+}
+
+type responsesTool struct {
+	Type        string          `json:"type"`
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Parameters  json.RawMessage `json:"parameters"`
+	Strict      *bool           `json:"strict"`
+}
+
+// responsesItem is an input item: a message, a function call or the output
+// of a function call
+type responsesItem struct {
+	Type      string          `json:"type"`
+	Role      string          `json:"role"`
+	Content   json.RawMessage `json:"content"`
+	CallID    string          `json:"call_id"`
+	Name      string          `json:"name"`
+	Arguments string          `json:"arguments"`
+	Output    json.RawMessage `json:"output"`
+}
+
+type responsesContent struct {
+	Type     string `json:"type"`
+	Text     string `json:"text"`
+	ImageURL string `json:"image_url"`
+}
+
+func sendResponsesError(c *gin.Context, statusCode int, message, param, code string) {
+	c.JSON(statusCode, gin.H{
+		"error": gin.H{
+			"message": message,
+			"type":    "invalid_request_error",
+			"param":   param,
+			"code":    code,
+		},
+	})
+}
+
+func (pm *ProxyManager) responsesHandler(c *gin.Context) {
+	var req responsesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		sendResponsesError(c, http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err), "", "")
+		return
+	}
+
+	config := pm.currentConfig()
+	if _, found := config.RealModelName(req.Model); !found {
+		sendResponsesError(c, http.StatusNotFound, fmt.Sprintf("The model '%s' does not exist", req.Model), "model", "model_not_found")
+		return
+	}
+
+	owner := responseOwner(c)
+	var previous *storedResponse
+	var history []gin.H
+	if req.PreviousResponseID != "" {
+		var found bool
+		previous, found = pm.responseStore.get(req.PreviousResponseID, owner)
+		if !found {
+			sendResponsesError(c, http.StatusNotFound,
+				fmt.Sprintf("Previous response with id '%s' not found.", req.PreviousResponseID),
+				"previous_response_id", "previous_response_not_found")
+			return
+		}
+		history = previous.conversation()
+	}
+
+	input, err := responsesInputToMessages(req.Input)
+	if err != nil {
+		sendResponsesError(c, http.StatusBadRequest, err.Error(), "input", "")
+		return
+	}
+	history = append(history, input...)
+
+	body, err := responsesToOpenAI(req, history)
+	if err != nil {
+		sendResponsesError(c, http.StatusBadRequest, err.Error(), "", "")
+		return
+	}
+
+	bodyBytes, err := json.Marshal(body)
+	if err != nil {
+		sendResponsesError(c, http.StatusInternalServerError, err.Error(), "", "")
+		return
+	}
+
+	translator := &responsesTranslator{
+		id:                 newResponseID("resp_"),
+		model:              req.Model,
+		createdAt:          time.Now().Unix(),
+		instructions:       req.Instructions,
+		previousResponseID: req.PreviousResponseID,
+		owner:              owner,
+		previous:           previous,
+		input:              input,
+	}
+	if req.Store == nil || *req.Store {
+		translator.store = pm.responseStore
+		translator.storeSize = config.responseStoreSize()
+	}
+
+	pm.proxyTranslatedRequest(c, "/v1/chat/completions", bodyBytes, translator)
+}
+
+func (pm *ProxyManager) deleteResponseHandler(c *gin.Context) {
+	id := c.Param("response_id")
+	if !pm.responseStore.delete(id, responseOwner(c)) {
+		sendResponsesError(c, http.StatusNotFound, fmt.Sprintf("Response with id '%s' not found.", id), "response_id", "")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": id, "object": "response", "deleted": true})
+}
+
+// responsesToOpenAI builds the chat completion request for the conversation
+func responsesToOpenAI(req responsesRequest, history []gin.H) (gin.H, error) {
+	messages := make([]gin.H, 0, len(history)+1)
+	if req.Instructions != "" {
+		messages = append(messages, gin.H{"role": "system", "content": req.Instructions})
+	}
+	messages = append(messages, history...)
+
+	body := gin.H{
+		"model":    req.Model,
+		"messages": messages,
+		"stream":   req.Stream,
+	}
+	if req.MaxOutputTokens > 0 {
+		body["max_tokens"] = req.MaxOutputTokens
+	}
+	if req.Temperature != nil {
+		body["temperature"] = *req.Temperature
+	}
+	if req.TopP != nil {
+		body["top_p"] = *req.TopP
+	}
+	if req.Stream {
+		body["stream_options"] = gin.H{"include_usage": true}
+	}
+
+	if len(req.Tools) > 0 {
+		tools := make([]gin.H, 0, len(req.Tools))
+		for _, tool := range req.Tools {
+			if tool.Type != "function" {
+				return nil, fmt.Errorf("unsupported tool type %s, only function tools are supported", tool.Type)
+			}
+			function := gin.H{
+				"name":        tool.Name,
+				"description": tool.Description,
+				"parameters":  tool.Parameters,
+			}
+			if tool.Strict != nil {
+				function["strict"] = *tool.Strict
+			}
+			tools = append(tools, gin.H{"type": "function", "function": function})
+		}
+		body["tools"] = tools
+	}
+
+	if choice := gjson.ParseBytes(req.ToolChoice); choice.Type == gjson.String {
+		body["tool_choice"] = choice.String()
+	} else if choice.Get("type").String() == "function" {
+		body["tool_choice"] = gin.H{"type": "function", "function": gin.H{"name": choice.Get("name").String()}}
+	}
+
+	format := gjson.ParseBytes(req.Text.Format)
+	switch format.Get("type").String() {
+	case "json_object":
+		body["response_format"] = gin.H{"type": "json_object"}
+	case "json_schema":
+		schema := gin.H{
+			"name":   format.Get("name").String(),
+			"schema": json.RawMessage(format.Get("schema").Raw),
+		}
+		if strict := format.Get("strict"); strict.Exists() {
+			schema["strict"] = strict.Bool()
+		}
+		body["response_format"] = gin.H{"type": "json_schema", "json_schema": schema}
+	}
+
+	return body, nil
+}
+
+// responsesInputToMessages converts the input, a string or a list of items,
+// into chat completion messages
+func responsesInputToMessages(input json.RawMessage) ([]gin.H, error) {
+	if len(input) == 0 || string(input) == "null" {
+		return nil, nil
+	}
+
+	var text string
+	if err := json.Unmarshal(input, &text); err == nil {
+		return []gin.H{{"role": "user", "content": text}}, nil
+	}
+
+	var items []responsesItem
+	if err := json.Unmarshal(input, &items); err != nil {
+		return nil, fmt.Errorf("input must be a string or a list of items")
+	}
+
+	var messages []gin.H
+	for i, item := range items {
+		switch item.Type {
+		case "", "message":
+			content, err := responsesContentToOpenAI(item.Content)
+			if err != nil {
+				return nil, fmt.Errorf("input.%d: %v", i, err)
+			}
+			role := item.Role
+			if role == "developer" {
+				role = "system"
+			}
+			messages = append(messages, gin.H{"role": role, "content": content})
+		case "function_call":
+			toolCall := gin.H{
+				"id":       item.CallID,
+				"type":     "function",
+				"function": gin.H{"name": item.Name, "arguments": item.Arguments},
+			}
+
+			// consecutive calls belong to the same assistant message
+			if n := len(messages); n > 0 && messages[n-1]["role"] == "assistant" && messages[n-1]["tool_calls"] != nil {
+				messages[n-1]["tool_calls"] = append(messages[n-1]["tool_calls"].([]gin.H), toolCall)
+			} else {
+				messages = append(messages, gin.H{"role": "assistant", "content": "", "tool_calls": []gin.H{toolCall}})
+			}
+		case "function_call_output":
+			output := gjson.ParseBytes(item.Output)
+			content := output.String()
+			if output.IsArray() {
+				var texts []string
+				for _, part := range output.Array() {
+					texts = append(texts, part.Get("text").String())
+				}
+				content = strings.Join(texts, "\n")
+			}
+			messages = append(messages, gin.H{"role": "tool", "tool_call_id": item.CallID, "content": content})
+		case "reasoning":
+			// the upstream does not need earlier reasoning
+		default:
+			return nil, fmt.Errorf("input.%d: unsupported item type %s", i, item.Type)
+		}
+	}
+	return messages, nil
+}
+
+// responsesContentToOpenAI converts message content, a string or a list of
+// content parts. Text only content becomes a string.
+func responsesContentToOpenAI(content json.RawMessage) (any, error) {
+	var text string
+	if err := json.Unmarshal(content, &text); err == nil {
+		return text, nil
+	}
+
+	var parts []responsesContent
+	if err := json.Unmarshal(content, &parts); err != nil {
+		return nil, fmt.Errorf("content must be a string or a list of content parts")
+	}
+
+	var texts []string
+	converted := make([]gin.H, 0, len(parts))
+	textOnly := true
+	for _, part := range parts {
+		switch part.Type {
+		case "input_text", "output_text":
+			texts = append(texts, part.Text)
+			converted = append(converted, gin.H{"type": "text", "text": part.Text})
+		case "input_image":
+			if part.ImageURL == "" {
+				return nil, fmt.Errorf("input_image requires an image_url")
+			}
+			textOnly = false
+			converted = append(converted, gin.H{"type": "image_url", "image_url": gin.H{"url": part.ImageURL}})
+		default:
+			return nil, fmt.Errorf("unsupported content type %s", part.Type)
+		}
+	}
+
+	if textOnly {
+		return strings.Join(texts, "\n"), nil
+	}
+	return converted, nil
+}
+
+// responsesTranslator translates chat completions into Responses API
+// responses and stores the conversation
+type responsesTranslator struct {
+	id                 string
+	model              string
+	createdAt          int64
+	instructions       string
+	previousResponseID string
+
+	// stored response continued by the request, and the messages the
+	// request added to the conversation
+	owner    string
+	previous *storedResponse
+	input    []gin.H
+
+	// nil when the response is not stored
+	store     *responseStore
+	storeSize int
+
+	// completed output items
+	output       []gin.H
+	finishReason string
+	usage        gjson.Result
+
+	// streaming state
+	sequence  int
+	started   bool
+	current   gin.H // the open output item, nil when none is open
+	text      strings.Builder
+	toolIndex int
+}
+
+func (t *responsesTranslator) contentType(streaming bool) string {
+	if streaming {
+		return "text/event-stream"
+	}
+	return "application/json"
+}
+
+func (t *responsesTranslator) streamChunk(w io.Writer, data []byte) error {
+	chunk := gjson.ParseBytes(data)
+	if err := t.start(w); err != nil {
+		return err
+	}
+
+	if usage := chunk.Get("usage"); usage.IsObject() {
+		t.usage = usage
+	}
+
+	choice := chunk.Get("choices.0")
+	if reason := choice.Get("finish_reason").String(); reason != "" {
+		t.finishReason = reason
+	}
+
+	if reasoning := choice.Get("delta.reasoning_content").String(); reasoning != "" {
+		if err := t.openItem(w, reasoningItem("")); err != nil {
+			return err
+		}
+		if err := t.delta(w, reasoning); err != nil {
+			return err
+		}
+	}
+
+	if text := choice.Get("delta.content").String(); text != "" {
+		if err := t.openItem(w, messageItem("")); err != nil {
+			return err
+		}
+		if err := t.delta(w, text); err != nil {
+			return err
+		}
+	}
+
+	for _, tc := range choice.Get("delta.tool_calls").Array() {
+		index := int(tc.Get("index").Int())
+		if t.current == nil || t.current["type"] != "function_call" || index != t.toolIndex {
+			t.toolIndex = index
+			item := functionCallItem(tc.Get("id").String(), tc.Get("function.name").String(), "")
+			if err := t.openItem(w, item); err != nil {
+				return err
+			}
+		}
+
+		if arguments := tc.Get("function.arguments").String(); arguments != "" {
+			if err := t.delta(w, arguments); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (t *responsesTranslator) streamEnd(w io.Writer) error {
+	if err := t.start(w); err != nil {
+		return err
+	}
+	if err := t.closeItem(w); err != nil {
+		return err
+	}
+
+	response := t.responseObject()
+	eventType := "response.completed"
+	if response["status"] == "incomplete" {
+		eventType = "response.incomplete"
+	}
+	if err := t.event(w, gin.H{"type": eventType, "response": response}); err != nil {
+		return err
+	}
+
+	t.save()
+	return nil
+}
+
+func (t *responsesTranslator) response(w io.Writer, body []byte) error {
+	result := gjson.ParseBytes(body)
+	t.usage = result.Get("usage")
+
+	choice := result.Get("choices.0")
+	t.finishReason = choice.Get("finish_reason").String()
+
+	if reasoning := choice.Get("message.reasoning_content").String(); reasoning != "" {
+		t.output = append(t.output, reasoningItem(reasoning))
+	}
+	if text := choice.Get("message.content").String(); text != "" {
+		t.output = append(t.output, messageItem(text))
+	}
+	for _, tc := range choice.Get("message.tool_calls").Array() {
+		t.output = append(t.output, functionCallItem(
+			tc.Get("id").String(),
+			tc.Get("function.name").String(),
+			tc.Get("function.arguments").String(),
+		))
+	}
+
+	if err := json.NewEncoder(w).Encode(t.responseObject()); err != nil {
+		return err
+	}
+
+	t.save()
+	return nil
+}
+
+// responseObject returns the response with the output so far
+func (t *responsesTranslator) responseObject() gin.H {
+	status := "completed"
+	var incompleteDetails gin.H
+	if t.finishReason == "length" {
+		status = "incomplete"
+		incompleteDetails = gin.H{"reason": "max_output_tokens"}
+	}
+
+	var usage gin.H
+	if t.usage.Exists() {
+		inputTokens := t.usage.Get("prompt_tokens").Int()
+		outputTokens := t.usage.Get("completion_tokens").Int()
+		usage = gin.H{
+			"input_tokens":          inputTokens,
+			"input_tokens_details":  gin.H{"cached_tokens": t.usage.Get("prompt_tokens_details.cached_tokens").Int()},
+			"output_tokens":         outputTokens,
+			"output_tokens_details": gin.H{"reasoning_tokens": t.usage.Get("completion_tokens_details.reasoning_tokens").Int()},
+			"total_tokens":          inputTokens + outputTokens,
+		}
+	}
+
+	return t.responseWith(status, incompleteDetails, t.output, usage)
+}
+
+func (t *responsesTranslator) responseWith(status string, incompleteDetails gin.H, output []gin.H, usage gin.H) gin.H {
+	if output == nil {
+		output = []gin.H{}
+	}
+
+	var instructions, previousResponseID any
+	if t.instructions != "" {
+		instructions = t.instructions
+	}
+	if t.previousResponseID != "" {
+		previousResponseID = t.previousResponseID
+	}
+
+	return gin.H{
+		"id":                   t.id,
+		"object":               "response",
+		"created_at":           t.createdAt,
+		"status":               status,
+		"error":                nil,
+		"incomplete_details":   incompleteDetails,
+		"instructions":         instructions,
+		"model":                t.model,
+		"output":               output,
+		"previous_response_id": previousResponseID,
+		"store":                t.store != nil,
+		"usage":                usage,
+	}
+}
+
+// save stores the conversation including the response's output
+func (t *responsesTranslator) save() {
+	if t.store == nil {
+		return
+	}
+
+	var texts []string
+	var toolCalls []gin.H
+	for _, item := range t.output {
+		switch item["type"] {
+		case "message":
+			for _, part := range item["content"].([]gin.H) {
+				texts = append(texts, part["text"].(string))
+			}
+		case "function_call":
+			toolCalls = append(toolCalls, gin.H{
+				"id":       item["call_id"],
+				"type":     "function",
+				"function": gin.H{"name": item["name"], "arguments": item["arguments"]},
+			})
+		}
+	}
+
+	assistant := gin.H{"role": "assistant", "content": strings.Join(texts, "")}
+	if len(toolCalls) > 0 {
+		assistant["tool_calls"] = toolCalls
+	}
+
+	messages := make([]gin.H, 0, len(t.input)+1)
+	messages = append(messages, t.input...)
+	messages = append(messages, assistant)
+	t.store.put(&storedResponse{
+		id:       t.id,
+		owner:    t.owner,
+		previous: t.previous,
+		messages: messages,
+	}, t.storeSize)
+}
+
+// start sends response.created and response.in_progress before the first event
+func (t *responsesTranslator) start(w io.Writer) error {
+	if t.started {
+		return nil
+	}
+	t.started = true
+
+	response := t.responseWith("in_progress", nil, nil, nil)
+	if err := t.event(w, gin.H{"type": "response.created", "response": response}); err != nil {
+		return err
+	}
+	return t.event(w, gin.H{"type": "response.in_progress", "response": response})
+}
+
+// openItem starts an output item unless one of the same type is open.
+// Function calls always start a new item.
+func (t *responsesTranslator) openItem(w io.Writer, item gin.H) error {
+	if t.current != nil && t.current["type"] == item["type"] && item["type"] != "function_call" {
+		return nil
+	}
+	if err := t.closeItem(w); err != nil {
+		return err
+	}
+
+	item["status"] = "in_progress"
+	t.current = item
+	t.text.Reset()
+
+	outputIndex := len(t.output)
+	err := t.event(w, gin.H{"type": "response.output_item.added", "output_index": outputIndex, "item": item})
+	if err != nil {
+		return err
+	}
+
+	if part := responsesContentPart(item, ""); part != nil {
+		return t.event(w, gin.H{
+			"type":          "response.content_part.added",
+			"item_id":       item["id"],
+			"output_index":  outputIndex,
+			"content_index": 0,
+			"part":          part,
+		})
+	}
+	return nil
+}
+
+// responsesContentPart returns the content part of an output item, nil for
+// function calls
+func responsesContentPart(item gin.H, text string) gin.H {
+	switch item["type"] {
+	case "message":
+		return gin.H{"type": "output_text", "text": text, "annotations": []gin.H{}}
+	case "reasoning":
+		return gin.H{"type": "reasoning_text", "text": text}
+	default:
+		return nil
+	}
+}
+
+func (t *responsesTranslator) delta(w io.Writer, delta string) error {
+	t.text.WriteString(delta)
+
+	event := gin.H{
+		"item_id":       t.current["id"],
+		"output_index":  len(t.output),
+		"content_index": 0,
+		"delta":         delta,
+	}
+	switch t.current["type"] {
+	case "message":
+		event["type"] = "response.output_text.delta"
+	case "reasoning":
+		event["type"] = "response.reasoning_text.delta"
+	default:
+		event["type"] = "response.function_call_arguments.delta"
+		delete(event, "content_index")
+	}
+	return t.event(w, event)
+}
+
+func (t *responsesTranslator) closeItem(w io.Writer) error {
+	if t.current == nil {
+		return nil
+	}
+
+	item := t.current
+	text := t.text.String()
+	outputIndex := len(t.output)
+	t.current = nil
+
+	item["status"] = "completed"
+	if part := responsesContentPart(item, text); part != nil {
+		doneType := "response.output_text.done"
+		if item["type"] == "reasoning" {
+			doneType = "response.reasoning_text.done"
+		}
+		err := t.event(w, gin.H{
+			"type":          doneType,
+			"item_id":       item["id"],
+			"output_index":  outputIndex,
+			"content_index": 0,
+			"text":          text,
+		})
+		if err != nil {
+			return err
+		}
+
+		err = t.event(w, gin.H{
+			"type":          "response.content_part.done",
+			"item_id":       item["id"],
+			"output_index":  outputIndex,
+			"content_index": 0,
+			"part":          part,
+		})
+		if err != nil {
+			return err
+		}
+		item["content"] = []gin.H{part}
+	} else {
+		item["arguments"] = text
+		err := t.event(w, gin.H{
+			"type":         "response.function_call_arguments.done",
+			"item_id":      item["id"],
+			"output_index": outputIndex,
+			"arguments":    text,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	t.output = append(t.output, item)
+	return t.event(w, gin.H{"type": "response.output_item.done", "output_index": outputIndex, "item": item})
+}
+
+func (t *responsesTranslator) event(w io.Writer, data gin.H) error {
+	data["sequence_number"] = t.sequence
+	t.sequence++
+
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", data["type"], encoded); err != nil {
+		return err
+	}
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+func messageItem(text string) gin.H {
+	item := gin.H{
+		"type":    "message",
+		"id":      newResponseID("msg_"),
+		"status":  "completed",
+		"role":    "assistant",
+		"content": []gin.H{},
+	}
+	if text != "" {
+		item["content"] = []gin.H{{"type": "output_text", "text": text, "annotations": []gin.H{}}}
+	}
+	return item
+}
+
+func reasoningItem(text string) gin.H {
+	item := gin.H{
+		"type":    "reasoning",
+		"id":      newResponseID("rs_"),
+		"summary": []gin.H{},
+		"content": []gin.H{},
+	}
+	if text != "" {
+		item["content"] = []gin.H{{"type": "reasoning_text", "text": text}}
+	}
+	return item
+}
+
+func functionCallItem(callID, name, arguments string) gin.H {
+	if callID == "" {
+		callID = newResponseID("call_")
+	}
+	return gin.H{
+		"type":      "function_call",
+		"id":        newResponseID("fc_"),
+		"status":    "completed",
+		"call_id":   callID,
+		"name":      name,
+		"arguments": arguments,
+	}
+}
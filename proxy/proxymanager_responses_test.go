@@ -0,0 +1,318 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func TestResponseStore(t *testing.T) {
+	store := newResponseStore()
+	put := func(id string, previous *storedResponse) {
+		store.put(&storedResponse{id: id, previous: previous, messages: []gin.H{{"role": "user", "content": id}}}, 2)
+	}
+	put("a", nil)
+	put("b", nil)
+
+	// a was used more recently than b so b is dropped
+	a, found := store.get("a", "")
+	assert.True(t, found)
+	put("c", a)
+
+	_, found = store.get("b", "")
+	assert.False(t, found)
+	c, found := store.get("c", "")
+	assert.True(t, found)
+	assert.Equal(t, []gin.H{{"role": "user", "content": "a"}, {"role": "user", "content": "c"}}, c.conversation())
+
+	// the conversation is kept when an earlier response is dropped
+	put("d", nil)
+	put("e", nil)
+	assert.Equal(t, []gin.H{{"role": "user", "content": "a"}, {"role": "user", "content": "c"}}, c.conversation())
+
+	assert.True(t, store.delete("e", ""))
+	assert.False(t, store.delete("e", ""))
+	_, found = store.get("e", "")
+	assert.False(t, found)
+}
+
+func TestResponseStore_Owner(t *testing.T) {
+	store := newResponseStore()
+	store.put(&storedResponse{id: "a", owner: "team-a"}, 10)
+
+	_, found := store.get("a", "team-b")
+	assert.False(t, found)
+	assert.False(t, store.delete("a", "team-b"))
+
+	_, found = store.get("a", "team-a")
+	assert.True(t, found)
+	assert.True(t, store.delete("a", "team-a"))
+}
+
+func TestResponsesInputToMessages(t *testing.T) {
+	messages, err := responsesInputToMessages([]byte(`"hi"`))
+	assert.NoError(t, err)
+	assert.Equal(t, []gin.H{{"role": "user", "content": "hi"}}, messages)
+
+	messages, err = responsesInputToMessages([]byte(`[
+		{"role": "developer", "content": "be brief"},
+		{"type": "message", "role": "user", "content": [
+			{"type": "input_text", "text": "what is this?"},
+			{"type": "input_image", "image_url": "data:image/png;base64,AAAA"}
+		]},
+		{"type": "reasoning", "summary": []},
+		{"type": "function_call", "call_id": "call_1", "name": "a", "arguments": "{}"},
+		{"type": "function_call", "call_id": "call_2", "name": "b", "arguments": "{}"},
+		{"type": "function_call_output", "call_id": "call_1", "output": "result a"},
+		{"type": "function_call_output", "call_id": "call_2", "output": [{"type": "input_text", "text": "result b"}]}
+	]`))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	encoded, _ := json.Marshal(messages)
+	assert.JSONEq(t, `[
+		{"role": "system", "content": "be brief"},
+		{"role": "user", "content": [
+			{"type": "text", "text": "what is this?"},
+			{"type": "image_url", "image_url": {"url": "data:image/png;base64,AAAA"}}
+		]},
+		{"role": "assistant", "content": "", "tool_calls": [
+			{"id": "call_1", "type": "function", "function": {"name": "a", "arguments": "{}"}},
+			{"id": "call_2", "type": "function", "function": {"name": "b", "arguments": "{}"}}
+		]},
+		{"role": "tool", "tool_call_id": "call_1", "content": "result a"},
+		{"role": "tool", "tool_call_id": "call_2", "content": "result b"}
+	]`, string(encoded))
+
+	_, err = responsesInputToMessages([]byte(`[{"type": "web_search_call"}]`))
+	assert.ErrorContains(t, err, "unsupported item type web_search_call")
+}
+
+func TestResponsesToOpenAI(t *testing.T) {
+	var req responsesRequest
+	err := json.Unmarshal([]byte(`{
+		"model": "model1",
+		"instructions": "be brief",
+		"max_output_tokens": 100,
+		"stream": true,
+		"tools": [{"type": "function", "name": "f", "parameters": {"type": "object"}}],
+		"tool_choice": {"type": "function", "name": "f"},
+		"text": {"format": {"type": "json_schema", "name": "out", "schema": {"type": "object"}, "strict": true}}
+	}`), &req)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	body, err := responsesToOpenAI(req, []gin.H{{"role": "user", "content": "hi"}})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	encoded, _ := json.Marshal(body)
+	assert.JSONEq(t, `{
+		"model": "model1",
+		"messages": [{"role": "system", "content": "be brief"}, {"role": "user", "content": "hi"}],
+		"max_tokens": 100,
+		"stream": true,
+		"stream_options": {"include_usage": true},
+		"tools": [{"type": "function", "function": {"name": "f", "description": "", "parameters": {"type": "object"}}}],
+		"tool_choice": {"type": "function", "function": {"name": "f"}},
+		"response_format": {"type": "json_schema", "json_schema": {"name": "out", "schema": {"type": "object"}, "strict": true}}
+	}`, string(encoded))
+
+	req.Tools = []responsesTool{{Type: "web_search"}}
+	_, err = responsesToOpenAI(req, nil)
+	assert.ErrorContains(t, err, "unsupported tool type web_search")
+}
+
+func TestResponsesTranslator_Stream(t *testing.T) {
+	store := newResponseStore()
+	previous := &storedResponse{id: "resp_0", messages: []gin.H{{"role": "user", "content": "hi"}, {"role": "assistant", "content": "hey"}}}
+	input := []gin.H{{"role": "user", "content": "again"}}
+	translator := &responsesTranslator{id: "resp_1", model: "model1", previous: previous, input: input, store: store, storeSize: 10}
+
+	w := translateResponse(t, translator, http.StatusOK, "text/event-stream",
+		"data: {\"choices\":[{\"delta\":{\"reasoning_content\":\"hmm\"}}]}\n\n",
+		"data: {\"choices\":[{\"delta\":{\"content\":\"Hel\"}}]}\n\n",
+		"data: {\"choices\":[{\"delta\":{\"content\":\"lo\"}}]}\n\n",
+		"data: {\"choices\":[{\"delta\":{\"tool_calls\":[{\"index\":0,\"id\":\"c1\",\"function\":{\"name\":\"f\",\"arguments\":\"{\"}}]}}]}\n\n",
+		"data: {\"choices\":[{\"delta\":{\"tool_calls\":[{\"index\":0,\"function\":{\"arguments\":\"}\"}}]}}]}\n\n",
+		"data: {\"choices\":[{\"delta\":{},\"finish_reason\":\"tool_calls\"}]}\n\n",
+		"data: {\"choices\":[],\"usage\":{\"prompt_tokens\":3,\"completion_tokens\":4}}\n\n",
+		"data: [DONE]\n\n",
+	)
+
+	names, data := sseEvents(w.Body.String())
+	assert.Equal(t, []string{
+		"response.created", "response.in_progress",
+		"response.output_item.added", "response.content_part.added", "response.reasoning_text.delta",
+		"response.reasoning_text.done", "response.content_part.done", "response.output_item.done",
+		"response.output_item.added", "response.content_part.added", "response.output_text.delta", "response.output_text.delta",
+		"response.output_text.done", "response.content_part.done", "response.output_item.done",
+		"response.output_item.added", "response.function_call_arguments.delta", "response.function_call_arguments.delta",
+		"response.function_call_arguments.done", "response.output_item.done",
+		"response.completed",
+	}, names)
+	if len(data) != 21 {
+		return
+	}
+
+	for i, event := range data {
+		assert.Equal(t, int64(i), gjson.Get(event, "sequence_number").Int())
+		assert.Equal(t, names[i], gjson.Get(event, "type").String())
+	}
+	assert.Equal(t, "Hello", gjson.Get(data[12], "text").String())
+	assert.Equal(t, int64(1), gjson.Get(data[12], "output_index").Int())
+	assert.Equal(t, "{}", gjson.Get(data[18], "arguments").String())
+
+	completed := gjson.Get(data[20], "response")
+	assert.Equal(t, "completed", completed.Get("status").String())
+	assert.Equal(t, `["reasoning","message","function_call"]`, completed.Get("output.#.type").Raw)
+	assert.Equal(t, "Hello", completed.Get("output.1.content.0.text").String())
+	assert.Equal(t, "c1", completed.Get("output.2.call_id").String())
+	assert.Equal(t, int64(7), completed.Get("usage.total_tokens").Int())
+
+	stored, found := store.get("resp_1", "")
+	assert.True(t, found)
+	assert.Same(t, previous, stored.previous)
+	encoded, _ := json.Marshal(stored.conversation())
+	assert.JSONEq(t, `[
+		{"role": "user", "content": "hi"},
+		{"role": "assistant", "content": "hey"},
+		{"role": "user", "content": "again"},
+		{"role": "assistant", "content": "Hello", "tool_calls": [
+			{"id": "c1", "type": "function", "function": {"name": "f", "arguments": "{}"}}
+		]}
+	]`, string(encoded))
+}
+
+func TestResponsesTranslator_Response(t *testing.T) {
+	translator := &responsesTranslator{id: "resp_1", model: "model1", previousResponseID: "resp_0"}
+	w := translateResponse(t, translator, http.StatusOK, "application/json",
+		`{"choices":[{"message":{"role":"assistant","content":"Hello"},"finish_reason":"length"}],`,
+		`"usage":{"prompt_tokens":3,"completion_tokens":4}}`,
+	)
+
+	body := w.Body.String()
+	assert.Equal(t, "resp_1", gjson.Get(body, "id").String())
+	assert.Equal(t, "resp_0", gjson.Get(body, "previous_response_id").String())
+	assert.Equal(t, "incomplete", gjson.Get(body, "status").String())
+	assert.Equal(t, "max_output_tokens", gjson.Get(body, "incomplete_details.reason").String())
+	assert.Equal(t, "Hello", gjson.Get(body, "output.0.content.0.text").String())
+	assert.False(t, gjson.Get(body, "store").Bool())
+}
+
+func TestProxyManager_Responses(t *testing.T) {
+	config := AddDefaultGroupToConfig(Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]ModelConfig{
+			"model1": getTestSimpleResponderConfig("model1"),
+		},
+		LogLevel: "error",
+	})
+
+	proxy := New(config)
+	defer proxy.StopProcesses()
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		w := httptest.NewRecorder()
+		proxy.HandlerFunc(w, req)
+		return w
+	}
+
+	w := do("POST", "/v1/responses", `{"model":"nope","input":"hi"}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "model_not_found", gjson.Get(w.Body.String(), "error.code").String())
+
+	w = do("POST", "/v1/responses", `{"model":"model1","input":"hi","previous_response_id":"resp_nope"}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "previous_response_not_found", gjson.Get(w.Body.String(), "error.code").String())
+
+	w = do("POST", "/v1/responses", `{"model":"model1","input":"hi"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	id := gjson.Get(w.Body.String(), "id").String()
+	assert.NotEmpty(t, id)
+
+	// the conversation continues from the stored response
+	w = do("POST", "/v1/responses", `{"model":"model1","input":"again","previous_response_id":"`+id+`"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	stored, found := proxy.responseStore.get(gjson.Get(w.Body.String(), "id").String(), "")
+	assert.True(t, found)
+	assert.Len(t, stored.messages, 2)
+	assert.Len(t, stored.conversation(), 4)
+
+	// store: false responses can not be continued
+	w = do("POST", "/v1/responses", `{"model":"model1","input":"hi","store":false}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	_, found = proxy.responseStore.get(gjson.Get(w.Body.String(), "id").String(), "")
+	assert.False(t, found)
+
+	assert.Equal(t, http.StatusOK, do("DELETE", "/v1/responses/"+id, "").Code)
+	assert.Equal(t, http.StatusNotFound, do("DELETE", "/v1/responses/"+id, "").Code)
+}
+
+func TestProxyManager_ResponsesOwner(t *testing.T) {
+	config := Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]ModelConfig{
+			"model1": getTestSimpleResponderConfig("model1"),
+		},
+		LogLevel: "error",
+		Auth: AuthConfig{
+			Keys: []APIKeyConfig{
+				{Key: "sk-team-a", Name: "team-a"},
+				{Key: "sk-team-b", Name: "team-b"},
+				// short keys without a name
+				{Key: "sk-c"},
+				{Key: "sk-d"},
+			},
+		},
+	}
+	assert.NoError(t, resolveAPIKeys(&config))
+	config = AddDefaultGroupToConfig(config)
+
+	proxy := New(config)
+	defer proxy.StopProcesses()
+
+	do := func(method, path, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+key)
+		w := httptest.NewRecorder()
+		proxy.HandlerFunc(w, req)
+		return w
+	}
+
+	w := do("POST", "/v1/responses", "sk-team-a", `{"model":"model1","input":"hi"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	id := gjson.Get(w.Body.String(), "id").String()
+
+	// other keys can not see the response
+	w = do("POST", "/v1/responses", "sk-team-b", `{"model":"model1","input":"again","previous_response_id":"`+id+`"}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, http.StatusNotFound, do("DELETE", "/v1/responses/"+id, "sk-team-b", "").Code)
+
+	w = do("POST", "/v1/responses", "sk-team-a", `{"model":"model1","input":"again","previous_response_id":"`+id+`"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// the owner is the key, renaming it does not change the owner
+	renamed := config
+	renamed.Auth.Keys = append([]APIKeyConfig(nil), config.Auth.Keys...)
+	renamed.Auth.Keys[0].Name = "renamed"
+	assert.NoError(t, resolveAPIKeys(&renamed))
+	proxy.ReloadConfig(renamed)
+	assert.Equal(t, http.StatusOK, do("DELETE", "/v1/responses/"+id, "sk-team-a", "").Code)
+
+	w = do("POST", "/v1/responses", "sk-c", `{"model":"model1","input":"hi"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	id = gjson.Get(w.Body.String(), "id").String()
+	assert.Equal(t, http.StatusNotFound, do("DELETE", "/v1/responses/"+id, "sk-d", "").Code)
+	assert.Equal(t, http.StatusOK, do("DELETE", "/v1/responses/"+id, "sk-c", "").Code)
+}
@@ -2,17 +2,22 @@ package proxy
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"math/rand"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
 )
 
 func TestProxyManager_SwapProcessCorrectly(t *testing.T) {
@@ -793,3 +798,767 @@ func TestProxyManager_Upstream(t *testing.T) {
 	assert.Equal(t, http.StatusOK, rec.Code)
 	assert.Equal(t, "model1", rec.Body.String())
 }
+
+func TestProxyManager_ReloadConfig(t *testing.T) {
+	model1Config := getTestSimpleResponderConfig("model1")
+	model2Config := getTestSimpleResponderConfig("model2")
+
+	config := AddDefaultGroupToConfig(Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]ModelConfig{
+			"model1": model1Config,
+			"model2": model2Config,
+		},
+		LogLevel: "error",
+		Groups: map[string]GroupConfig{
+			"G1": {
+				Swap:      true,
+				Exclusive: false,
+				Members:   []string{"model1"},
+			},
+			"G2": {
+				Swap:      true,
+				Exclusive: false,
+				Members:   []string{"model2"},
+			},
+		},
+	})
+
+	proxy := New(config)
+	defer proxy.StopProcesses()
+
+	for _, modelName := range []string{"model1", "model2"} {
+		reqBody := fmt.Sprintf(`{"model":"%s"}`, modelName)
+		req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(reqBody))
+		w := httptest.NewRecorder()
+		proxy.HandlerFunc(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+
+	process1 := proxy.findGroupByModelName("model1").processes["model1"]
+	process2 := proxy.findGroupByModelName("model2").processes["model2"]
+
+	// change model2, leave model1 alone
+	newConfig := AddDefaultGroupToConfig(Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]ModelConfig{
+			"model1": model1Config,
+			"model2": getTestSimpleResponderConfig("model2-changed"),
+		},
+		LogLevel: "error",
+		Groups: map[string]GroupConfig{
+			"G1": config.Groups["G1"],
+			"G2": config.Groups["G2"],
+		},
+	})
+	proxy.ReloadConfig(newConfig)
+
+	assert.Same(t, process1, proxy.findGroupByModelName("model1").processes["model1"])
+	assert.Equal(t, StateReady, process1.CurrentState())
+	assert.NotSame(t, process2, proxy.findGroupByModelName("model2").processes["model2"])
+
+	// the replaced process is stopped in the background
+	assert.Eventually(t, func() bool {
+		return process2.CurrentState() == StateShutdown
+	}, 5*time.Second, 50*time.Millisecond)
+
+	req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"model2"}`))
+	w := httptest.NewRecorder()
+	proxy.HandlerFunc(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "model2-changed", w.Body.String())
+}
+
+func TestProxyManager_ReloadConfigSamePort(t *testing.T) {
+	model1Config := getTestSimpleResponderConfig("model1")
+	config := AddDefaultGroupToConfig(Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]ModelConfig{
+			"model1": model1Config,
+		},
+		LogLevel: "error",
+	})
+
+	proxy := New(config)
+	defer proxy.StopProcesses()
+	assert.NoError(t, proxy.loadModel("model1"))
+
+	// a slow request keeps the old process on the port after the reload
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		req := httptest.NewRequest("POST", "/v1/chat/completions?wait=1s", bytes.NewBufferString(`{"model":"model1"}`))
+		w := httptest.NewRecorder()
+		proxy.HandlerFunc(w, req)
+		assert.Equal(t, "model1", w.Body.String())
+	}()
+	time.Sleep(200 * time.Millisecond)
+
+	var port int
+	fmt.Sscanf(model1Config.Proxy, "http://127.0.0.1:%d", &port)
+	newConfig := AddDefaultGroupToConfig(Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]ModelConfig{
+			"model1": getTestSimpleResponderConfigPort("model1-changed", port),
+		},
+		LogLevel: "error",
+	})
+	proxy.ReloadConfig(newConfig)
+
+	// the new process waits for the old one to release the port
+	req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"model1"}`))
+	w := httptest.NewRecorder()
+	proxy.HandlerFunc(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "model1-changed", w.Body.String())
+	wg.Wait()
+}
+
+func TestProxyManager_ReloadConfigStopsStartingProcess(t *testing.T) {
+	// the health check never passes so the process stays starting
+	startingConfig := getTestSimpleResponderConfig("model1")
+	startingConfig.CheckEndpoint = "/nope"
+
+	config := AddDefaultGroupToConfig(Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]ModelConfig{
+			"model1": startingConfig,
+		},
+		LogLevel: "error",
+	})
+
+	proxy := New(config)
+	defer proxy.StopProcesses()
+
+	process := proxy.findGroupByModelName("model1").processes["model1"]
+	go proxy.loadModel("model1")
+
+	cmdDone := func() chan struct{} {
+		process.stateMutex.RLock()
+		defer process.stateMutex.RUnlock()
+		return process.cmdDone
+	}
+	assert.Eventually(t, func() bool {
+		return process.CurrentState() == StateStarting && cmdDone() != nil
+	}, 5*time.Second, 50*time.Millisecond)
+
+	newConfig := AddDefaultGroupToConfig(Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]ModelConfig{
+			"model1": getTestSimpleResponderConfig("model1-changed"),
+		},
+		LogLevel: "error",
+	})
+	proxy.ReloadConfig(newConfig)
+
+	assert.Eventually(t, func() bool {
+		return process.CurrentState() == StateShutdown
+	}, 5*time.Second, 50*time.Millisecond)
+	select {
+	case <-cmdDone():
+	case <-time.After(5 * time.Second):
+		t.Error("the starting process was not stopped")
+	}
+
+	req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"model1"}`))
+	w := httptest.NewRecorder()
+	proxy.HandlerFunc(w, req)
+	assert.Equal(t, "model1-changed", w.Body.String())
+}
+
+func TestProxyManager_ReloadConfigWaitsForRetiredGroupMember(t *testing.T) {
+	config := AddDefaultGroupToConfig(Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]ModelConfig{
+			"model1": getTestSimpleResponderConfig("model1"),
+			"model2": getTestSimpleResponderConfig("model2"),
+		},
+		LogLevel: "error",
+	})
+
+	proxy := New(config)
+	defer proxy.StopProcesses()
+
+	var mu sync.Mutex
+	var completed []string
+	var wg sync.WaitGroup
+	sendRequest := func(modelName string, wait string) {
+		defer wg.Done()
+		req := httptest.NewRequest("POST", "/v1/chat/completions?wait="+wait, bytes.NewBufferString(`{"model":"`+modelName+`"}`))
+		w := httptest.NewRecorder()
+		proxy.HandlerFunc(w, req)
+		assert.Equal(t, http.StatusOK, w.Code, modelName+": "+w.Body.String())
+
+		mu.Lock()
+		completed = append(completed, modelName)
+		mu.Unlock()
+	}
+
+	// model1 is busy with a slow request when it is retired
+	oldProcess := proxy.findGroupByModelName("model1").processes["model1"]
+	assert.NoError(t, proxy.loadModel("model1"))
+	wg.Add(1)
+	go sendRequest("model1", "1000ms")
+	assert.Eventually(t, func() bool {
+		return oldProcess.InFlightRequests() > 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	newConfig := AddDefaultGroupToConfig(Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]ModelConfig{
+			"model1": getTestSimpleResponderConfig("model1-changed"),
+			"model2": getTestSimpleResponderConfig("model2"),
+		},
+		LogLevel: "error",
+	})
+	proxy.ReloadConfig(newConfig)
+
+	// model2 does not start while the retired model1 is draining
+	wg.Add(1)
+	go sendRequest("model2", "0ms")
+	wg.Wait()
+
+	assert.Equal(t, []string{"model1", "model2"}, completed)
+	assert.Equal(t, StateShutdown, oldProcess.CurrentState())
+}
+
+func TestProxyManager_CrashRestartFollowsSwap(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping slow restart test")
+	}
+
+	model1Config := getTestSimpleResponderConfig("model1")
+	model1Config.Restart = RestartConfig{
+		Policy:     RESTART_ON_FAILURE,
+		MaxRetries: 3,
+		Backoff:    1,
+	}
+
+	config := AddDefaultGroupToConfig(Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]ModelConfig{
+			"model1": model1Config,
+			"model2": getTestSimpleResponderConfig("model2"),
+		},
+		LogLevel: "error",
+	})
+
+	proxy := New(config)
+	defer proxy.StopProcesses()
+
+	assert.NoError(t, proxy.loadModel("model1"))
+	process1 := proxy.findGroupByModelName("model1").processes["model1"]
+	process1.stateMutex.RLock()
+	cmd := process1.cmd
+	process1.stateMutex.RUnlock()
+	assert.NoError(t, cmd.Process.Kill())
+	assert.Eventually(t, func() bool {
+		return process1.CurrentState() == StateStopped
+	}, 5*time.Second, 50*time.Millisecond)
+
+	// swapping in model2 cancels model1's restart, they never run together
+	assert.NoError(t, proxy.loadModel("model2"))
+	<-time.After(2 * time.Second)
+	assert.Equal(t, StateStopped, process1.CurrentState())
+	assert.Equal(t, StateReady, proxy.findGroupByModelName("model2").processes["model2"].CurrentState())
+}
+
+func TestProxyManager_ReloadConfigFileRejectsInvalidConfig(t *testing.T) {
+	config := AddDefaultGroupToConfig(Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]ModelConfig{
+			"model1": getTestSimpleResponderConfig("model1"),
+		},
+		LogLevel: "error",
+	})
+
+	proxy := New(config)
+	defer proxy.StopProcesses()
+
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	assert.NoError(t, os.WriteFile(configFile, []byte("models: [this is not valid"), 0644))
+	assert.Error(t, proxy.ReloadConfigFile(configFile))
+
+	req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"model1"}`))
+	w := httptest.NewRecorder()
+	proxy.HandlerFunc(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "model1", w.Body.String())
+}
+
+func TestProxyManager_ResetFailedModel(t *testing.T) {
+	config := AddDefaultGroupToConfig(Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]ModelConfig{
+			"broken": {
+				Cmd:           "nonexistent-command",
+				Proxy:         "http://127.0.0.1:9913",
+				CheckEndpoint: "/health",
+			},
+		},
+		LogLevel: "error",
+	})
+
+	proxy := New(config)
+	defer proxy.StopProcesses()
+
+	req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"broken"}`))
+	w := httptest.NewRecorder()
+	proxy.HandlerFunc(w, req)
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+
+	// /running reports why it failed
+	req = httptest.NewRequest("GET", "/running", nil)
+	w = httptest.NewRecorder()
+	proxy.HandlerFunc(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "failed", gjson.Get(w.Body.String(), "running.0.state").String())
+	assert.Contains(t, gjson.Get(w.Body.String(), "running.0.lastFailure").String(), "nonexistent-command")
+
+	req = httptest.NewRequest("POST", "/api/models/broken/reset", nil)
+	w = httptest.NewRecorder()
+	proxy.HandlerFunc(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, StateStopped, proxy.processGroups[DEFAULT_GROUP_ID].processes["broken"].CurrentState())
+
+	// can not reset a model that has not failed
+	req = httptest.NewRequest("POST", "/api/models/broken/reset", nil)
+	w = httptest.NewRecorder()
+	proxy.HandlerFunc(w, req)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	req = httptest.NewRequest("POST", "/api/models/nope/reset", nil)
+	w = httptest.NewRecorder()
+	proxy.HandlerFunc(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestProxyManager_PreloadModels(t *testing.T) {
+	model2Config := getTestSimpleResponderConfig("model2")
+	model2Config.Preload = true
+
+	config := AddDefaultGroupToConfig(Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]ModelConfig{
+			"model1": getTestSimpleResponderConfig("model1"),
+			"model2": model2Config,
+			"model3": getTestSimpleResponderConfig("model3"),
+		},
+		Preload:  []string{"model1"},
+		LogLevel: "error",
+		Groups: map[string]GroupConfig{
+			"forever": {
+				Swap:       true,
+				Exclusive:  false,
+				Persistent: true,
+				Members:    []string{"model2"},
+			},
+		},
+	})
+
+	proxy := New(config)
+	defer proxy.StopProcesses()
+
+	assert.Eventually(t, func() bool {
+		return proxy.findGroupByModelName("model1").processes["model1"].CurrentState() == StateReady &&
+			proxy.findGroupByModelName("model2").processes["model2"].CurrentState() == StateReady
+	}, 10*time.Second, 50*time.Millisecond)
+
+	assert.Equal(t, StateStopped, proxy.findGroupByModelName("model3").processes["model3"].CurrentState())
+}
+
+func TestProxyManager_LoadUnloadModel(t *testing.T) {
+	model1Config := getTestSimpleResponderConfig("model1")
+	model1Config.UnloadAfter = 60
+
+	config := AddDefaultGroupToConfig(Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]ModelConfig{
+			"model1": model1Config,
+			"model2": getTestSimpleResponderConfig("model2"),
+		},
+		LogLevel: "error",
+	})
+
+	proxy := New(config)
+	defer proxy.StopProcesses()
+
+	req := httptest.NewRequest("GET", "/api/models/model1", nil)
+	w := httptest.NewRecorder()
+	proxy.HandlerFunc(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "stopped", gjson.Get(w.Body.String(), "state").String())
+	assert.Equal(t, DEFAULT_GROUP_ID, gjson.Get(w.Body.String(), "group").String())
+	assert.True(t, gjson.Get(w.Body.String(), "ttlRemaining").Type == gjson.Null)
+
+	req = httptest.NewRequest("POST", "/api/models/model1/load", nil)
+	w = httptest.NewRecorder()
+	proxy.HandlerFunc(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "ready", gjson.Get(w.Body.String(), "state").String())
+	assert.Greater(t, gjson.Get(w.Body.String(), "lastStartDuration").Float(), 0.0)
+	assert.Equal(t, int64(60), gjson.Get(w.Body.String(), "ttl").Int())
+	assert.InDelta(t, 60, gjson.Get(w.Body.String(), "ttlRemaining").Float(), 2)
+
+	// loading another model swaps out model1
+	req = httptest.NewRequest("POST", "/api/models/model2/load", nil)
+	w = httptest.NewRecorder()
+	proxy.HandlerFunc(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, StateStopped, proxy.processGroups[DEFAULT_GROUP_ID].processes["model1"].CurrentState())
+	assert.Equal(t, StateReady, proxy.processGroups[DEFAULT_GROUP_ID].processes["model2"].CurrentState())
+
+	req = httptest.NewRequest("POST", "/api/models/model2/unload", nil)
+	w = httptest.NewRecorder()
+	proxy.HandlerFunc(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "stopped", gjson.Get(w.Body.String(), "state").String())
+
+	for _, path := range []string{"/api/models/nope/load", "/api/models/nope/unload"} {
+		req = httptest.NewRequest("POST", path, nil)
+		w = httptest.NewRecorder()
+		proxy.HandlerFunc(w, req)
+		assert.Equal(t, http.StatusNotFound, w.Code, path)
+	}
+
+	req = httptest.NewRequest("GET", "/api/models/nope", nil)
+	w = httptest.NewRecorder()
+	proxy.HandlerFunc(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestProxyManager_Metrics(t *testing.T) {
+	config := AddDefaultGroupToConfig(Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]ModelConfig{
+			"metrics-model": getTestSimpleResponderConfig("metrics-model"),
+		},
+		LogLevel: "error",
+	})
+
+	proxy := New(config)
+	defer proxy.StopProcesses()
+
+	req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"metrics-model"}`))
+	w := httptest.NewRecorder()
+	proxy.HandlerFunc(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	req = httptest.NewRequest("GET", "/metrics", nil)
+	w = httptest.NewRecorder()
+	proxy.HandlerFunc(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/plain")
+
+	body := w.Body.String()
+	assert.Contains(t, body, `llamaswap_requests_total{model="metrics-model",endpoint="/v1/chat/completions",status="200"} 1`)
+	assert.Contains(t, body, `llamaswap_request_duration_seconds_count{model="metrics-model",endpoint="/v1/chat/completions",status="200"} 1`)
+	assert.Contains(t, body, `llamaswap_model_swaps_total{model="metrics-model"} 1`)
+	assert.Contains(t, body, `llamaswap_model_start_duration_seconds_count{model="metrics-model"} 1`)
+	assert.Contains(t, body, `llamaswap_model_state{model="metrics-model",state="ready"} 1`)
+	assert.Contains(t, body, `llamaswap_model_state{model="metrics-model",state="stopped"} 0`)
+	assert.Contains(t, body, `llamaswap_in_flight_requests{model="metrics-model"} 0`)
+	assert.Contains(t, body, `llamaswap_queue_depth{model="metrics-model"} 0`)
+}
+
+func TestProxyManager_RequestLog(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "requests.jsonl")
+	modelConfig := getTestSimpleResponderConfig("model1")
+	modelConfig.Aliases = []string{"model1-alias"}
+
+	config := Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]ModelConfig{
+			"model1": modelConfig,
+		},
+		LogLevel: "error",
+		RequestLog: RequestLogConfig{
+			Path:        logPath,
+			Bodies:      true,
+			MaxBodySize: 16,
+		},
+	}
+	config.aliases = map[string]string{"model1-alias": "model1"}
+	config = AddDefaultGroupToConfig(config)
+
+	proxy := New(config)
+	defer proxy.StopProcesses()
+
+	req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"model1-alias"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	proxy.HandlerFunc(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// requests that are not for a model are not logged
+	req = httptest.NewRequest("GET", "/running", nil)
+	w = httptest.NewRecorder()
+	proxy.HandlerFunc(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	proxy.Shutdown()
+
+	data, err := os.ReadFile(logPath)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if !assert.Len(t, lines, 1) {
+		return
+	}
+
+	var entry RequestLogEntry
+	if !assert.NoError(t, json.Unmarshal([]byte(lines[0]), &entry)) {
+		return
+	}
+	assert.Equal(t, "POST", entry.Method)
+	assert.Equal(t, "/v1/chat/completions", entry.Endpoint)
+	assert.Equal(t, "model1-alias", entry.RequestedModel)
+	assert.Equal(t, "model1", entry.Model)
+	assert.Equal(t, http.StatusOK, entry.Status)
+	assert.Greater(t, entry.StartMs, 0.0)
+	assert.GreaterOrEqual(t, entry.TotalMs, entry.StartMs)
+	assert.Equal(t, "application/json", entry.RequestContentType)
+	assert.Equal(t, `{"model":"model1`, entry.RequestBody)
+	assert.True(t, entry.RequestBodyTruncated)
+	assert.Equal(t, "model1", entry.ResponseBody)
+	assert.False(t, entry.ResponseBodyTruncated)
+}
+
+func TestProxyManager_ModelLogs(t *testing.T) {
+	model1Config := getTestSimpleResponderConfig("model1")
+	model1Config.Cmd = strings.Replace(model1Config.Cmd, "--silent", "", 1)
+
+	config := AddDefaultGroupToConfig(Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]ModelConfig{
+			"model1": model1Config,
+			"model2": getTestSimpleResponderConfig("model2"),
+		},
+		LogLevel: "error",
+	})
+
+	proxy := New(config)
+	defer proxy.StopProcesses()
+
+	assert.NoError(t, proxy.loadModel("model1"))
+
+	// lines in the combined log are tagged with the model
+	req := httptest.NewRequest("GET", "/logs", nil)
+	w := httptest.NewRecorder()
+	proxy.HandlerFunc(w, req)
+	assert.Contains(t, w.Body.String(), "[model1] ")
+	assert.Contains(t, w.Body.String(), "simple-responder listening on")
+
+	req = httptest.NewRequest("GET", "/logs?format=json", nil)
+	w = httptest.NewRecorder()
+	proxy.HandlerFunc(w, req)
+	assert.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"))
+	var found bool
+	for _, entry := range gjson.Parse(w.Body.String()).Array() {
+		if entry.Get("model").String() == "model1" && strings.Contains(entry.Get("message").String(), "simple-responder listening on") {
+			found = true
+			assert.Equal(t, "upstream", entry.Get("source").String())
+			assert.Equal(t, "info", entry.Get("level").String())
+		}
+	}
+	assert.True(t, found)
+
+	for _, path := range []string{"/logs/stream/model1", "/logs/streamSSE/model1"} {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		req = httptest.NewRequest("GET", path, nil).WithContext(ctx)
+		w = httptest.NewRecorder()
+		proxy.HandlerFunc(w, req)
+		cancel()
+
+		assert.Equal(t, http.StatusOK, w.Code, path)
+		assert.Contains(t, w.Body.String(), "simple-responder listening on", path)
+		assert.NotContains(t, w.Body.String(), "[model1] ", path)
+	}
+
+	// one JSON entry per line or event
+	for _, path := range []string{"/logs/stream/model1?format=json", "/logs/streamSSE/model1?format=json"} {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		req = httptest.NewRequest("GET", path, nil).WithContext(ctx)
+		w = httptest.NewRecorder()
+		proxy.HandlerFunc(w, req)
+		cancel()
+
+		lines := strings.SplitN(w.Body.String(), "\n", 3)
+		line := lines[0]
+		if strings.Contains(path, "SSE") {
+			// event:message followed by data:{...}
+			line = strings.TrimPrefix(lines[1], "data:")
+		}
+		assert.Equal(t, "model1", gjson.Get(line, "model").String(), path)
+	}
+
+	req = httptest.NewRequest("GET", "/logs/stream/nope", nil)
+	w = httptest.NewRecorder()
+	proxy.HandlerFunc(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// a model's log is kept when its config changes
+	logger := proxy.processGroups[DEFAULT_GROUP_ID].processes["model1"].LogMonitor()
+	newConfig := config
+	newConfig.Models = map[string]ModelConfig{
+		"model1": getTestSimpleResponderConfig("model1"),
+		"model2": config.Models["model2"],
+	}
+	proxy.ReloadConfig(newConfig)
+	assert.Same(t, logger, proxy.processGroups[DEFAULT_GROUP_ID].processes["model1"].LogMonitor())
+}
+
+func TestProxyManager_LogQuery(t *testing.T) {
+	config := AddDefaultGroupToConfig(Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]ModelConfig{
+			"model1": getTestSimpleResponderConfig("model1"),
+		},
+		LogLevel: "info",
+	})
+
+	proxy := New(config)
+	defer proxy.StopProcesses()
+
+	proxy.processGroups[DEFAULT_GROUP_ID].processes["model1"].LogMonitor().Write([]byte("query: loading\n[WARN] query: low memory\n"))
+	proxy.proxyLogger.Infof("query: ready")
+
+	query := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		proxy.HandlerFunc(w, req)
+		return w
+	}
+
+	messages := func(w *httptest.ResponseRecorder) []string {
+		var messages []string
+		for _, entry := range gjson.Parse(w.Body.String()).Array() {
+			messages = append(messages, entry.Get("message").String())
+		}
+		return messages
+	}
+
+	w := query("/logs/query?grep=^query:")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"query: loading", "query: low memory", "query: ready"}, messages(w))
+
+	assert.Equal(t, []string{"query: loading", "query: low memory"}, messages(query("/logs/query?grep=^query:&model=model1")))
+	assert.Equal(t, []string{"query: low memory"}, messages(query("/logs/query?grep=^query:&level=warn")))
+	assert.Equal(t, []string{"query: ready"}, messages(query("/logs/query?grep=^query:&limit=1")))
+	assert.Equal(t, []string{"query: loading", "query: low memory", "query: ready"}, messages(query("/logs/query?grep=^query:&since=1m")))
+	assert.Equal(t, "[]", query("/logs/query?grep=^query:&until=1m").Body.String())
+
+	w = query("/logs/query?grep=^query:&format=text")
+	assert.Equal(t, "[model1] query: loading\n[model1] [WARN] query: low memory\n[INFO] query: ready\n", w.Body.String())
+
+	for _, path := range []string{
+		"/logs/query?model=nope",
+		"/logs/query?level=loud",
+		"/logs/query?since=yesterday",
+		"/logs/query?grep=(",
+		"/logs/query?limit=-1",
+		"/logs/stream?level=loud",
+		"/logs/streamSSE?level=loud",
+	} {
+		assert.Equal(t, http.StatusBadRequest, query(path).Code, path)
+	}
+
+	// streams filter their history and new entries
+	for _, path := range []string{"/logs/stream?level=warn", "/logs/streamSSE?level=warn"} {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		req := httptest.NewRequest("GET", path, nil).WithContext(ctx)
+		w := httptest.NewRecorder()
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			proxy.proxyLogger.Infof("query: streamed info")
+			proxy.proxyLogger.Warnf("query: streamed warning")
+		}()
+		proxy.HandlerFunc(w, req)
+		cancel()
+
+		assert.Contains(t, w.Body.String(), "[model1] [WARN] query: low memory", path)
+		assert.Contains(t, w.Body.String(), "[WARN] query: streamed warning", path)
+		assert.NotContains(t, w.Body.String(), "query: loading", path)
+		assert.NotContains(t, w.Body.String(), "query: streamed info", path)
+	}
+}
+
+func TestProxyManager_LogFile(t *testing.T) {
+	dir := t.TempDir()
+	config := AddDefaultGroupToConfig(Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]ModelConfig{
+			"model1": getTestSimpleResponderConfig("model1"),
+		},
+		LogLevel: "info",
+		LogFile: LogFileConfig{
+			Proxy:    filepath.Join(dir, "proxy.log"),
+			Upstream: filepath.Join(dir, "upstream.log"),
+			ModelDir: filepath.Join(dir, "models"),
+		},
+	})
+
+	proxy := New(config)
+	defer proxy.StopProcesses()
+
+	proxy.proxyLogger.Infof("file: ready")
+	proxy.processGroups[DEFAULT_GROUP_ID].processes["model1"].LogMonitor().Write([]byte("file: loading\n"))
+
+	// models added by a reload get a file too
+	newConfig := config
+	newConfig.Models = map[string]ModelConfig{
+		"model1":     config.Models["model1"],
+		"org/model2": getTestSimpleResponderConfig("model2"),
+		"org_model2": getTestSimpleResponderConfig("model3"),
+	}
+	proxy.ReloadConfig(AddDefaultGroupToConfig(newConfig))
+	proxy.processGroups[DEFAULT_GROUP_ID].processes["org/model2"].LogMonitor().Write([]byte("file: loading 2\n"))
+	proxy.processGroups[DEFAULT_GROUP_ID].processes["org_model2"].LogMonitor().Write([]byte("file: loading 3\n"))
+	assert.NoError(t, proxy.logFiles.Close())
+
+	read := func(name string) string {
+		data, _ := os.ReadFile(filepath.Join(dir, name))
+		return string(data)
+	}
+	assert.Contains(t, read("proxy.log"), " [INFO] file: ready\n")
+	assert.NotContains(t, read("proxy.log"), "file: loading")
+	assert.Contains(t, read("upstream.log"), " [model1] file: loading\n")
+	assert.Contains(t, read("upstream.log"), " [org/model2] file: loading 2\n")
+	assert.Regexp(t, `^\S+ file: loading\n$`, read("models/model1.log"))
+	// model IDs that only differ in escaped characters have their own files
+	assert.Regexp(t, `^\S+ file: loading 2\n$`, read("models/org%2Fmodel2.log"))
+	assert.Regexp(t, `^\S+ file: loading 3\n$`, read("models/org_model2.log"))
+}
+
+func TestProxyManager_Kill(t *testing.T) {
+	// the upstream ignores SIGTERM, Shutdown() would wait 5s for it
+	config := AddDefaultGroupToConfig(Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]ModelConfig{
+			"model1": {
+				Cmd:           `sh -c 'trap "" TERM; while true; do sleep 0.1; done'`,
+				Proxy:         "http://127.0.0.1:9999",
+				CheckEndpoint: "none",
+			},
+		},
+		LogLevel: "error",
+	})
+
+	proxy := New(config)
+	defer proxy.StopProcesses()
+	assert.NoError(t, proxy.loadModel("model1"))
+
+	process := proxy.processGroups[DEFAULT_GROUP_ID].processes["model1"]
+	start := time.Now()
+	proxy.Kill()
+	assert.Less(t, time.Since(start), 2*time.Second)
+
+	select {
+	case <-process.cmdDone:
+	default:
+		t.Fatal("upstream process is still running")
+	}
+}
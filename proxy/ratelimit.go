@@ -0,0 +1,215 @@
+package proxy
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimitConfig limits how much a client, an API key or a client IP when
+// there is no key, can use the models. Zero values are unlimited.
+type RateLimitConfig struct {
+	// token bucket refilled at requestsPerMinute holding up to burst requests.
+	// burst defaults to requestsPerMinute
+	RequestsPerMinute int `yaml:"requestsPerMinute"`
+	Burst             int `yaml:"burst"`
+
+	// requests handled at the same time
+	Concurrency int `yaml:"concurrency"`
+
+	// completion tokens per day, counted from the usage reported by the upstream
+	DailyCompletionTokens int64 `yaml:"dailyCompletionTokens"`
+}
+
+func (c RateLimitConfig) enabled() bool {
+	return c.RequestsPerMinute > 0 || c.Concurrency > 0 || c.DailyCompletionTokens > 0
+}
+
+func (c RateLimitConfig) burst() float64 {
+	if c.Burst < 1 {
+		return float64(c.RequestsPerMinute)
+	}
+	return float64(c.Burst)
+}
+
+// clients not seen for this long are forgotten
+const rateLimitIdleExpiry = 25 * time.Hour
+
+// clientUsage is the usage counted against a client's limits
+type clientUsage struct {
+	tokens     float64
+	lastRefill time.Time
+	inFlight   int
+
+	day              string
+	completionTokens int64
+
+	lastSeen time.Time
+}
+
+// rateLimiter tracks the usage of each client. It belongs to the
+// ProxyManager so the counts survive reloading the config.
+type rateLimiter struct {
+	sync.Mutex
+	clients   map[string]*clientUsage
+	lastPrune time.Time
+
+	// for testing
+	now func() time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{
+		clients: make(map[string]*clientUsage),
+		now:     time.Now,
+	}
+}
+
+// rateLimitError is returned when a request is over a limit
+type rateLimitError struct {
+	message    string
+	code       string
+	retryAfter time.Duration
+}
+
+func (e *rateLimitError) Error() string {
+	return e.message
+}
+
+// acquire counts a request against the client's limits. The returned
+// function must be called with the completion tokens used once the request
+// is done.
+func (r *rateLimiter) acquire(client string, limits RateLimitConfig) (func(completionTokens int), error) {
+	r.Lock()
+	defer r.Unlock()
+
+	now := r.now()
+	r.pruneLocked(now)
+
+	usage, ok := r.clients[client]
+	if !ok {
+		usage = &clientUsage{tokens: limits.burst(), lastRefill: now}
+		r.clients[client] = usage
+	}
+	usage.lastSeen = now
+
+	if limits.DailyCompletionTokens > 0 {
+		if today := now.Format(time.DateOnly); usage.day != today {
+			usage.day = today
+			usage.completionTokens = 0
+		}
+
+		if usage.completionTokens >= limits.DailyCompletionTokens {
+			year, month, day := now.Date()
+			tomorrow := time.Date(year, month, day+1, 0, 0, 0, 0, now.Location())
+			return nil, &rateLimitError{
+				message:    fmt.Sprintf("daily quota of %d completion tokens exceeded", limits.DailyCompletionTokens),
+				code:       "insufficient_quota",
+				retryAfter: tomorrow.Sub(now),
+			}
+		}
+	}
+
+	if limits.Concurrency > 0 && usage.inFlight >= limits.Concurrency {
+		return nil, &rateLimitError{
+			message:    fmt.Sprintf("limit of %d concurrent requests reached", limits.Concurrency),
+			code:       "rate_limit_exceeded",
+			retryAfter: time.Second,
+		}
+	}
+
+	if limits.RequestsPerMinute > 0 {
+		perSecond := float64(limits.RequestsPerMinute) / 60
+		usage.tokens = math.Min(limits.burst(), usage.tokens+now.Sub(usage.lastRefill).Seconds()*perSecond)
+		usage.lastRefill = now
+
+		if usage.tokens < 1 {
+			return nil, &rateLimitError{
+				message:    fmt.Sprintf("limit of %d requests per minute reached", limits.RequestsPerMinute),
+				code:       "rate_limit_exceeded",
+				retryAfter: time.Duration((1 - usage.tokens) / perSecond * float64(time.Second)),
+			}
+		}
+		usage.tokens--
+	}
+
+	usage.inFlight++
+	return func(completionTokens int) {
+		r.Lock()
+		defer r.Unlock()
+		usage.inFlight--
+		usage.completionTokens += int64(completionTokens)
+	}, nil
+}
+
+// pruneLocked forgets clients that have not been seen in a while. The caller
+// must hold the lock.
+func (r *rateLimiter) pruneLocked(now time.Time) {
+	if now.Sub(r.lastPrune) < time.Hour {
+		return
+	}
+	r.lastPrune = now
+
+	for client, usage := range r.clients {
+		if usage.inFlight == 0 && now.Sub(usage.lastSeen) > rateLimitIdleExpiry {
+			delete(r.clients, client)
+		}
+	}
+}
+
+// rateLimitMiddleware applies the rate limits of the request's API key, or
+// of the client IP when there is no key
+func (pm *ProxyManager) rateLimitMiddleware(c *gin.Context) {
+	config := pm.currentConfig()
+
+	client := "ip:" + c.ClientIP()
+	logName := client
+	limits := config.RateLimit
+	if apiKey := requestAPIKey(c); apiKey != nil {
+		// names can be changed in the config, the id stays the same
+		client = "key:" + apiKey.id
+		logName = "key:" + apiKey.Name
+		if apiKey.RateLimit != nil {
+			limits = *apiKey.RateLimit
+		}
+	}
+
+	if !limits.enabled() {
+		c.Next()
+		return
+	}
+
+	release, err := pm.rateLimiter.acquire(client, limits)
+	if err != nil {
+		rlErr := err.(*rateLimitError)
+		pm.proxyLogger.Infof("Rate limited %s: %s", logName, rlErr.message)
+		c.Header("Retry-After", retryAfterSeconds(rlErr.retryAfter))
+		body := gin.H{
+			"error": gin.H{
+				"message": rlErr.message,
+				"type":    "requests",
+				"param":   nil,
+				"code":    rlErr.code,
+			},
+		}
+		if !translateRouteError(c, http.StatusTooManyRequests, body) {
+			c.JSON(http.StatusTooManyRequests, body)
+		}
+		c.Abort()
+		return
+	}
+
+	defer func() {
+		var completionTokens int
+		if stats := requestStatsFromContext(c.Request.Context()); stats != nil && stats.found {
+			completionTokens = stats.usage.CompletionTokens
+		}
+		release(completionTokens)
+	}()
+
+	c.Next()
+}
@@ -0,0 +1,161 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func TestRateLimiter_RequestsPerMinute(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	r := newRateLimiter()
+	r.now = func() time.Time { return now }
+	limits := RateLimitConfig{RequestsPerMinute: 60, Burst: 2}
+
+	for i := 0; i < 2; i++ {
+		release, err := r.acquire("ip:1", limits)
+		if assert.NoError(t, err) {
+			release(0)
+		}
+	}
+
+	_, err := r.acquire("ip:1", limits)
+	if assert.Error(t, err) {
+		assert.Equal(t, time.Second, err.(*rateLimitError).retryAfter)
+	}
+
+	// other clients have their own bucket
+	_, err = r.acquire("ip:2", limits)
+	assert.NoError(t, err)
+
+	// refilled at one request per second
+	now = now.Add(time.Second)
+	_, err = r.acquire("ip:1", limits)
+	assert.NoError(t, err)
+}
+
+func TestRateLimiter_Concurrency(t *testing.T) {
+	r := newRateLimiter()
+	limits := RateLimitConfig{Concurrency: 1}
+
+	release, err := r.acquire("key:a", limits)
+	assert.NoError(t, err)
+
+	_, err = r.acquire("key:a", limits)
+	assert.ErrorContains(t, err, "limit of 1 concurrent requests reached")
+
+	release(0)
+	_, err = r.acquire("key:a", limits)
+	assert.NoError(t, err)
+}
+
+func TestRateLimiter_DailyCompletionTokens(t *testing.T) {
+	now := time.Date(2025, 1, 1, 18, 0, 0, 0, time.UTC)
+	r := newRateLimiter()
+	r.now = func() time.Time { return now }
+	limits := RateLimitConfig{DailyCompletionTokens: 100}
+
+	release, err := r.acquire("key:a", limits)
+	assert.NoError(t, err)
+	release(60)
+
+	release, err = r.acquire("key:a", limits)
+	assert.NoError(t, err)
+	release(60)
+
+	_, err = r.acquire("key:a", limits)
+	if assert.Error(t, err) {
+		rlErr := err.(*rateLimitError)
+		assert.Equal(t, "insufficient_quota", rlErr.code)
+		assert.Equal(t, 6*time.Hour, rlErr.retryAfter)
+	}
+
+	// the quota resets the next day
+	now = now.Add(6 * time.Hour)
+	_, err = r.acquire("key:a", limits)
+	assert.NoError(t, err)
+}
+
+func TestRateLimiter_Middleware(t *testing.T) {
+	config := AddDefaultGroupToConfig(Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]ModelConfig{
+			"model1": getTestSimpleResponderConfig("model1"),
+		},
+		LogLevel:  "error",
+		RateLimit: RateLimitConfig{RequestsPerMinute: 1},
+	})
+
+	proxy := New(config)
+	defer proxy.StopProcesses()
+
+	do := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"model1"}`))
+		w := httptest.NewRecorder()
+		proxy.HandlerFunc(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, do().Code)
+
+	w := do()
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+	assert.Equal(t, "rate_limit_exceeded", gjson.Get(w.Body.String(), "error.code").String())
+
+	// the count survives reloading the config
+	proxy.ReloadConfig(config)
+	assert.Equal(t, http.StatusTooManyRequests, do().Code)
+
+	// endpoints that do not use a model are not limited
+	req := httptest.NewRequest("GET", "/v1/models", nil)
+	w = httptest.NewRecorder()
+	proxy.HandlerFunc(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestRateLimiter_MiddlewareKeys(t *testing.T) {
+	newConfig := func(name string) Config {
+		config := Config{
+			HealthCheckTimeout: 15,
+			Models: map[string]ModelConfig{
+				"model1": getTestSimpleResponderConfig("model1"),
+			},
+			LogLevel:  "error",
+			RateLimit: RateLimitConfig{RequestsPerMinute: 1},
+			Auth: AuthConfig{
+				Keys: []APIKeyConfig{
+					{Key: "sk-a", Name: name},
+					{Key: "sk-b"},
+				},
+			},
+		}
+		assert.NoError(t, resolveAPIKeys(&config))
+		return AddDefaultGroupToConfig(config)
+	}
+
+	config := newConfig("team-a")
+	proxy := New(config)
+	defer proxy.StopProcesses()
+
+	do := func(key string) int {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"model1"}`))
+		req.Header.Set("Authorization", "Bearer "+key)
+		w := httptest.NewRecorder()
+		proxy.HandlerFunc(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, do("sk-a"))
+	assert.Equal(t, http.StatusTooManyRequests, do("sk-a"))
+	assert.Equal(t, http.StatusOK, do("sk-b"))
+
+	// the limit follows the key, not its name
+	proxy.ReloadConfig(newConfig("renamed"))
+	assert.Equal(t, http.StatusTooManyRequests, do("sk-a"))
+}
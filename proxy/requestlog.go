@@ -0,0 +1,160 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ginKeyRequestedModel is set by handlers to the model name the client asked
+// for, before aliases are resolved
+const ginKeyRequestedModel = "llama-swap.requestedModel"
+
+// RequestLogEntry is a line in the request log
+type RequestLogEntry struct {
+	Timestamp      time.Time   `json:"timestamp"`
+	ClientIP       string      `json:"clientIP"`
+	Method         string      `json:"method"`
+	Endpoint       string      `json:"endpoint"`
+	RequestedModel string      `json:"requestedModel"`
+	Model          string      `json:"model,omitempty"`
+	Status         int         `json:"status"`
+	StartMs        float64     `json:"startMs"`
+	TotalMs        float64     `json:"totalMs"`
+	Usage          *TokenUsage `json:"usage,omitempty"`
+
+	// only written when bodies are enabled. Binary bodies, like audio
+	// uploads, are not written.
+	RequestContentType    string `json:"requestContentType,omitempty"`
+	RequestBody           string `json:"requestBody,omitempty"`
+	RequestBodyTruncated  bool   `json:"requestBodyTruncated,omitempty"`
+	ResponseBody          string `json:"responseBody,omitempty"`
+	ResponseBodyTruncated bool   `json:"responseBodyTruncated,omitempty"`
+}
+
+// requestLog writes a RequestLogEntry for every request made for a model
+type requestLog struct {
+	sync.Mutex
+	out         io.WriteCloser
+	bodies      bool
+	maxBodySize int
+}
+
+func newRequestLog(config RequestLogConfig) (*requestLog, error) {
+	out, err := NewRotatingFile(config.Path, config.maxSize(), config.maxBackups())
+	if err != nil {
+		return nil, err
+	}
+
+	return &requestLog{
+		out:         out,
+		bodies:      config.Bodies,
+		maxBodySize: config.maxBodySize(),
+	}, nil
+}
+
+func (l *requestLog) write(entry RequestLogEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	_, err = l.out.Write(append(data, '\n'))
+	return err
+}
+
+func (l *requestLog) Close() error {
+	return l.out.Close()
+}
+
+// capture starts capturing the request and response bodies of c. The
+// returned function fills in the bodies of the entry after the request
+// has been handled.
+func (l *requestLog) capture(c *gin.Context) func(entry *RequestLogEntry) {
+	if !l.bodies {
+		return func(*RequestLogEntry) {}
+	}
+
+	contentType := c.GetHeader("Content-Type")
+	requestBody := &cappedBuffer{max: l.maxBodySize}
+	if c.Request.Body != nil {
+		c.Request.Body = &teeReadCloser{
+			Reader: io.TeeReader(c.Request.Body, requestBody),
+			Closer: c.Request.Body,
+		}
+	}
+
+	responseBody := &cappedBuffer{max: l.maxBodySize}
+	c.Writer = &captureResponseWriter{ResponseWriter: c.Writer, capture: responseBody}
+
+	return func(entry *RequestLogEntry) {
+		entry.RequestContentType = contentType
+		entry.RequestBody, entry.RequestBodyTruncated = requestBody.text()
+		entry.ResponseBody, entry.ResponseBodyTruncated = responseBody.text()
+	}
+}
+
+// cappedBuffer keeps the first max bytes written to it
+type cappedBuffer struct {
+	buf       bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if remaining := b.max - b.buf.Len(); remaining < len(p) {
+		b.truncated = true
+		if remaining > 0 {
+			b.buf.Write(p[:remaining])
+		}
+	} else {
+		b.buf.Write(p)
+	}
+	return len(p), nil
+}
+
+// text returns the captured bytes, or nothing if they are binary
+func (b *cappedBuffer) text() (string, bool) {
+	data := b.buf.Bytes()
+	if b.truncated {
+		// don't count a multi-byte character cut off at the end as binary
+		for i := 0; i < utf8.UTFMax && len(data) > 0 && !utf8.Valid(data); i++ {
+			data = data[:len(data)-1]
+		}
+	}
+
+	if !utf8.Valid(data) {
+		return "", b.truncated
+	}
+	return string(data), b.truncated
+}
+
+type teeReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// captureResponseWriter copies the response body into capture
+type captureResponseWriter struct {
+	gin.ResponseWriter
+	capture io.Writer
+}
+
+func (w *captureResponseWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.capture.Write(p[:n])
+	return n, err
+}
+
+func (w *captureResponseWriter) WriteString(s string) (int, error) {
+	n, err := w.ResponseWriter.WriteString(s)
+	w.capture.Write([]byte(s[:n]))
+	return n, err
+}
@@ -0,0 +1,106 @@
+package proxy
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	ErrQueueFull    = errors.New("request queue is full")
+	ErrQueueTimeout = errors.New("timed out waiting in request queue")
+)
+
+// requestQueue limits the number of requests running at once. Requests over
+// the limit wait in FIFO order for a slot to free up.
+type requestQueue struct {
+	mu sync.Mutex
+
+	concurrency int
+	maxQueued   int
+
+	active  int
+	waiting *list.List // of chan struct{}, closed when a slot is handed over
+}
+
+func newRequestQueue(concurrency int, maxQueued int) *requestQueue {
+	return &requestQueue{
+		concurrency: concurrency,
+		maxQueued:   maxQueued,
+		waiting:     list.New(),
+	}
+}
+
+// acquire blocks until a slot is available. It returns ErrQueueFull right away
+// if too many requests are already waiting, ErrQueueTimeout if a slot did not
+// become available within timeout, or the context's error if it is cancelled.
+// A successful acquire must be followed by a call to release.
+func (q *requestQueue) acquire(ctx context.Context, timeout time.Duration) error {
+	q.mu.Lock()
+	if q.active < q.concurrency && q.waiting.Len() == 0 {
+		q.active++
+		q.mu.Unlock()
+		return nil
+	}
+
+	if q.waiting.Len() >= q.maxQueued {
+		q.mu.Unlock()
+		return ErrQueueFull
+	}
+
+	ready := make(chan struct{})
+	elem := q.waiting.PushBack(ready)
+	q.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	var waitErr error
+	select {
+	case <-ready:
+		return nil
+	case <-timer.C:
+		waitErr = ErrQueueTimeout
+	case <-ctx.Done():
+		waitErr = ctx.Err()
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	select {
+	case <-ready:
+		// a slot was handed over while giving up, pass it on
+		q.releaseLocked()
+	default:
+		q.waiting.Remove(elem)
+	}
+
+	return waitErr
+}
+
+// release frees a slot, handing it to the longest waiting request
+func (q *requestQueue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.releaseLocked()
+}
+
+func (q *requestQueue) releaseLocked() {
+	if front := q.waiting.Front(); front != nil {
+		q.waiting.Remove(front)
+		close(front.Value.(chan struct{}))
+		return
+	}
+
+	q.active--
+}
+
+// depth returns the number of requests waiting for a slot
+func (q *requestQueue) depth() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.waiting.Len()
+}
@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestQueue_FIFO(t *testing.T) {
+	q := newRequestQueue(1, 10)
+	assert.NoError(t, q.acquire(context.Background(), time.Second))
+
+	var mu sync.Mutex
+	var order []int
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.NoError(t, q.acquire(context.Background(), 5*time.Second))
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+			q.release()
+		}(i)
+
+		// make sure they queue up in order
+		assert.Eventually(t, func() bool { return q.depth() == i+1 }, time.Second, time.Millisecond)
+	}
+
+	q.release()
+	wg.Wait()
+
+	assert.Equal(t, []int{0, 1, 2}, order)
+	assert.Equal(t, 0, q.depth())
+	assert.Equal(t, 0, q.active)
+}
+
+func TestRequestQueue_Full(t *testing.T) {
+	q := newRequestQueue(1, 1)
+	assert.NoError(t, q.acquire(context.Background(), time.Second))
+
+	go q.acquire(context.Background(), time.Second)
+	assert.Eventually(t, func() bool { return q.depth() == 1 }, time.Second, time.Millisecond)
+
+	assert.ErrorIs(t, q.acquire(context.Background(), time.Second), ErrQueueFull)
+}
+
+func TestRequestQueue_Timeout(t *testing.T) {
+	q := newRequestQueue(1, 10)
+	assert.NoError(t, q.acquire(context.Background(), time.Second))
+
+	assert.ErrorIs(t, q.acquire(context.Background(), 50*time.Millisecond), ErrQueueTimeout)
+	assert.Equal(t, 0, q.depth())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, q.acquire(ctx, time.Second), context.Canceled)
+
+	// the slot is still usable after the waiters gave up
+	q.release()
+	assert.NoError(t, q.acquire(context.Background(), time.Second))
+}
@@ -0,0 +1,254 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// placementCandidate is a loaded model that may be evicted to make room
+type placementCandidate struct {
+	modelID   string
+	resources map[string]float64
+	lastUsed  time.Time
+
+	// busy models are starting, stopping or have requests in flight. They
+	// still use their resources but can not be evicted until they are idle.
+	busy bool
+
+	// pinned models, members of persistent groups, are never evicted
+	pinned bool
+}
+
+// checkModelResources makes sure every model's resources are part of the
+// budget and that each model fits in it on its own
+func checkModelResources(config Config) error {
+	for modelID, modelConfig := range config.Models {
+		for name, amount := range modelConfig.Resources {
+			if amount < 0 {
+				return fmt.Errorf("model %s: resource %s can not be negative", modelID, name)
+			}
+
+			budget, found := config.Resources[name]
+			if !found {
+				return fmt.Errorf("model %s: resource %s is not in the resources budget", modelID, name)
+			}
+
+			if amount > budget {
+				return fmt.Errorf("model %s: needs %v %s but the budget is %v", modelID, amount, name, budget)
+			}
+		}
+	}
+
+	return nil
+}
+
+// errResourcesBusy is returned by planEviction when the model would fit once
+// busy models become idle
+var errResourcesBusy = errors.New("models using the resources are busy")
+
+// planEviction decides which loaded models to unload so a model needing
+// resources fits in the budget. Idle models are evicted least recently used
+// first. Busy and pinned models are never evicted.
+func planEviction(budget map[string]float64, need map[string]float64, loaded []placementCandidate) ([]string, error) {
+	used := make(map[string]float64)
+	for _, candidate := range loaded {
+		for name, amount := range candidate.resources {
+			used[name] += amount
+		}
+	}
+
+	fits := func() bool {
+		for name, amount := range need {
+			if used[name]+amount > budget[name] {
+				return false
+			}
+		}
+		return true
+	}
+
+	evictable := make([]placementCandidate, 0, len(loaded))
+	var busy []placementCandidate
+	for _, candidate := range loaded {
+		switch {
+		case candidate.pinned:
+		case candidate.busy:
+			busy = append(busy, candidate)
+		default:
+			evictable = append(evictable, candidate)
+		}
+	}
+
+	sort.SliceStable(evictable, func(i, j int) bool {
+		return evictable[i].lastUsed.Before(evictable[j].lastUsed)
+	})
+
+	var evict []string
+	for _, candidate := range evictable {
+		if fits() {
+			break
+		}
+
+		evict = append(evict, candidate.modelID)
+		for name, amount := range candidate.resources {
+			used[name] -= amount
+		}
+	}
+
+	if !fits() {
+		// check if it would fit once the busy models can be evicted
+		for _, candidate := range busy {
+			for name, amount := range candidate.resources {
+				used[name] -= amount
+			}
+		}
+		if fits() {
+			return nil, errResourcesBusy
+		}
+		for _, candidate := range busy {
+			for name, amount := range candidate.resources {
+				used[name] += amount
+			}
+		}
+
+		var missing []string
+		for name, amount := range need {
+			if used[name]+amount > budget[name] {
+				missing = append(missing, fmt.Sprintf("%s: need %v, %v available", name, amount, budget[name]-used[name]))
+			}
+		}
+		sort.Strings(missing)
+		return nil, fmt.Errorf("not enough resources, %s", strings.Join(missing, ", "))
+	}
+
+	return evict, nil
+}
+
+// placeModel makes room for modelID within the resource budget, unloading
+// the least recently used idle models, and then starts it. When only busy
+// models can make room it waits up to the health check timeout for them to
+// become idle. Placement decisions are serialized so concurrent requests can
+// not overcommit the budget, but models are started outside the lock so a
+// slow load does not hold up models that fit.
+func (pm *ProxyManager) placeModel(config Config, modelID string) error {
+	processGroup := pm.findGroupByModelName(modelID)
+	if processGroup == nil {
+		return fmt.Errorf("could not find process group for model %s", modelID)
+	}
+
+	process := processGroup.processes[modelID]
+	if process.CurrentState() == StateReady {
+		return nil
+	}
+
+	reserved, err := pm.reservePlacement(config, modelID, process)
+	if err != nil || !reserved {
+		return err
+	}
+
+	err = process.start()
+	pm.placementMutex.Lock()
+	delete(pm.placing, modelID)
+	pm.placementMutex.Unlock()
+
+	if err != nil {
+		return fmt.Errorf("unable to start model %s: %v", modelID, err)
+	}
+	return nil
+}
+
+// reservePlacement evicts models to make room for modelID and reserves its
+// resources. It returns false when the model is already being started.
+func (pm *ProxyManager) reservePlacement(config Config, modelID string, process *Process) (bool, error) {
+	deadline := time.Now().Add(time.Duration(config.HealthCheckTimeout) * time.Second)
+	for waiting := false; ; waiting = true {
+		pm.placementMutex.Lock()
+
+		// it may have been started while waiting
+		if pm.placing[modelID] || process.CurrentState() != StateStopped {
+			pm.placementMutex.Unlock()
+			return false, nil
+		}
+
+		evict, loadedProcesses, err := pm.planPlacement(config, modelID)
+		if err == nil {
+			pm.evictModels(evict, loadedProcesses, modelID)
+			if pm.placing == nil {
+				pm.placing = make(map[string]bool)
+			}
+			pm.placing[modelID] = true
+			pm.placementMutex.Unlock()
+			return true, nil
+		}
+		pm.placementMutex.Unlock()
+
+		if !errors.Is(err, errResourcesBusy) || time.Now().After(deadline) {
+			return false, fmt.Errorf("unable to load model %s: %v", modelID, err)
+		}
+
+		if !waiting {
+			pm.proxyLogger.Infof("Waiting for busy models to become idle to make room for %s", modelID)
+		}
+
+		select {
+		case <-pm.shutdownCtx.Done():
+			return false, fmt.Errorf("unable to load model %s: shutting down", modelID)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// planPlacement returns the models to evict to make room for modelID and
+// the loaded processes by model ID. Models being started count as busy. The
+// caller must hold placementMutex.
+func (pm *ProxyManager) planPlacement(config Config, modelID string) ([]string, map[string]*Process, error) {
+	var loaded []placementCandidate
+	loadedProcesses := make(map[string]*Process)
+	for _, group := range pm.currentProcessGroups() {
+		for otherID, other := range group.processes {
+			if otherID == modelID {
+				continue
+			}
+
+			switch other.CurrentState() {
+			case StateReady, StateStarting, StateStopping:
+			default:
+				if !pm.placing[otherID] {
+					continue
+				}
+			}
+
+			loaded = append(loaded, placementCandidate{
+				modelID:   otherID,
+				resources: config.Models[otherID].Resources,
+				lastUsed:  other.LastRequestHandled(),
+				busy:      other.InFlightRequests() > 0 || other.CurrentState() != StateReady,
+				pinned:    group.persistent,
+			})
+			loadedProcesses[otherID] = other
+		}
+	}
+
+	evict, err := planEviction(config.Resources, config.Models[modelID].Resources, loaded)
+	return evict, loadedProcesses, err
+}
+
+// evictModels stops the evicted processes and waits for them to stop
+func (pm *ProxyManager) evictModels(evict []string, loadedProcesses map[string]*Process, modelID string) {
+	var wg sync.WaitGroup
+	for _, evictID := range evict {
+		evictProcess := loadedProcesses[evictID]
+		pm.proxyLogger.Infof("Evicting model %s, last used %v ago, to make room for %s",
+			evictID, time.Since(evictProcess.LastRequestHandled()).Round(time.Second), modelID)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			evictProcess.Stop()
+		}()
+	}
+	wg.Wait()
+}
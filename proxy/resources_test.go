@@ -0,0 +1,293 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResources_PlanEviction(t *testing.T) {
+	now := time.Now()
+	budget := map[string]float64{"vram": 48}
+
+	tests := []struct {
+		name          string
+		need          map[string]float64
+		loaded        []placementCandidate
+		expectedEvict []string
+		expectedError string
+	}{
+		{
+			name: "fits without evicting",
+			need: map[string]float64{"vram": 20},
+			loaded: []placementCandidate{
+				{modelID: "a", resources: map[string]float64{"vram": 20}, lastUsed: now},
+			},
+			expectedEvict: nil,
+		},
+		{
+			name: "evicts least recently used first",
+			need: map[string]float64{"vram": 20},
+			loaded: []placementCandidate{
+				{modelID: "new", resources: map[string]float64{"vram": 20}, lastUsed: now},
+				{modelID: "old", resources: map[string]float64{"vram": 20}, lastUsed: now.Add(-time.Hour)},
+			},
+			expectedEvict: []string{"old"},
+		},
+		{
+			name: "evicts idle models before busy ones",
+			need: map[string]float64{"vram": 20},
+			loaded: []placementCandidate{
+				{modelID: "busy", resources: map[string]float64{"vram": 20}, lastUsed: now.Add(-time.Hour), busy: true},
+				{modelID: "idle", resources: map[string]float64{"vram": 20}, lastUsed: now},
+			},
+			expectedEvict: []string{"idle"},
+		},
+		{
+			name: "busy models are never evicted",
+			need: map[string]float64{"vram": 40},
+			loaded: []placementCandidate{
+				{modelID: "busy", resources: map[string]float64{"vram": 20}, lastUsed: now.Add(-time.Hour), busy: true},
+				{modelID: "idle", resources: map[string]float64{"vram": 20}, lastUsed: now},
+			},
+			expectedError: errResourcesBusy.Error(),
+		},
+		{
+			name: "waiting for busy models does not help",
+			need: map[string]float64{"vram": 40},
+			loaded: []placementCandidate{
+				{modelID: "pinned", resources: map[string]float64{"vram": 20}, lastUsed: now, pinned: true},
+				{modelID: "starting", resources: map[string]float64{"vram": 20}, lastUsed: now, busy: true},
+			},
+			expectedError: "not enough resources, vram: need 40, 8 available",
+		},
+		{
+			name: "evicts as many as needed",
+			need: map[string]float64{"vram": 38},
+			loaded: []placementCandidate{
+				{modelID: "a", resources: map[string]float64{"vram": 10}, lastUsed: now.Add(-3 * time.Minute)},
+				{modelID: "b", resources: map[string]float64{"vram": 10}, lastUsed: now.Add(-2 * time.Minute)},
+				{modelID: "c", resources: map[string]float64{"vram": 10}, lastUsed: now.Add(-1 * time.Minute)},
+			},
+			expectedEvict: []string{"a", "b"},
+		},
+		{
+			name: "pinned models are never evicted",
+			need: map[string]float64{"vram": 40},
+			loaded: []placementCandidate{
+				{modelID: "pinned", resources: map[string]float64{"vram": 20}, lastUsed: now.Add(-time.Hour), pinned: true},
+				{modelID: "a", resources: map[string]float64{"vram": 20}, lastUsed: now},
+			},
+			expectedError: "not enough resources, vram: need 40, 28 available",
+		},
+		{
+			name: "models without resources are free",
+			need: nil,
+			loaded: []placementCandidate{
+				{modelID: "a", resources: map[string]float64{"vram": 48}, lastUsed: now},
+			},
+			expectedEvict: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			evict, err := planEviction(budget, tt.need, tt.loaded)
+			if tt.expectedError != "" {
+				assert.EqualError(t, err, tt.expectedError)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedEvict, evict)
+		})
+	}
+}
+
+func TestResources_CheckModelResources(t *testing.T) {
+	config := Config{
+		Resources: map[string]float64{"vram": 24},
+		Models: map[string]ModelConfig{
+			"model1": {Resources: map[string]float64{"vram": 30}},
+		},
+	}
+	assert.ErrorContains(t, checkModelResources(config), "needs 30 vram but the budget is 24")
+
+	config.Models["model1"] = ModelConfig{Resources: map[string]float64{"ram": 8}}
+	assert.ErrorContains(t, checkModelResources(config), "resource ram is not in the resources budget")
+}
+
+func TestProxyManager_ResourceBudgetEviction(t *testing.T) {
+	newModel := func(name string, vram float64) ModelConfig {
+		modelConfig := getTestSimpleResponderConfig(name)
+		modelConfig.Resources = map[string]float64{"vram": vram}
+		return modelConfig
+	}
+
+	config := AddDefaultGroupToConfig(Config{
+		HealthCheckTimeout: 15,
+		Resources:          map[string]float64{"vram": 48},
+		Models: map[string]ModelConfig{
+			"model1": newModel("model1", 20),
+			"model2": newModel("model2", 20),
+			"model3": newModel("model3", 20),
+		},
+		LogLevel: "error",
+	})
+
+	proxy := New(config)
+	defer proxy.StopProcesses()
+
+	for _, modelName := range []string{"model1", "model2", "model1", "model3"} {
+		reqBody := fmt.Sprintf(`{"model":"%s"}`, modelName)
+		req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(reqBody))
+		w := httptest.NewRecorder()
+		proxy.HandlerFunc(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, modelName, w.Body.String())
+	}
+
+	// model2 was the least recently used so it made room for model3
+	processes := proxy.processGroups[DEFAULT_GROUP_ID].processes
+	assert.Equal(t, StateReady, processes["model1"].CurrentState())
+	assert.Equal(t, StateStopped, processes["model2"].CurrentState())
+	assert.Equal(t, StateReady, processes["model3"].CurrentState())
+}
+
+func TestProxyManager_ResourceBudgetWaitsForBusyModel(t *testing.T) {
+	newModel := func(name string, vram float64) ModelConfig {
+		modelConfig := getTestSimpleResponderConfig(name)
+		modelConfig.Resources = map[string]float64{"vram": vram}
+		return modelConfig
+	}
+
+	config := AddDefaultGroupToConfig(Config{
+		HealthCheckTimeout: 15,
+		Resources:          map[string]float64{"vram": 48},
+		Models: map[string]ModelConfig{
+			"model1": newModel("model1", 20),
+			"model2": newModel("model2", 40),
+		},
+		LogLevel: "error",
+	})
+
+	proxy := New(config)
+	defer proxy.StopProcesses()
+
+	var wg sync.WaitGroup
+	sendRequest := func(modelName string, wait string) {
+		defer wg.Done()
+		reqBody := fmt.Sprintf(`{"model":"%s"}`, modelName)
+		req := httptest.NewRequest("POST", "/v1/chat/completions?wait="+wait, bytes.NewBufferString(reqBody))
+		w := httptest.NewRecorder()
+		proxy.HandlerFunc(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, modelName, w.Body.String())
+	}
+
+	// keep model1 busy
+	wg.Add(1)
+	go sendRequest("model1", "1000ms")
+	processes := proxy.processGroups[DEFAULT_GROUP_ID].processes
+	assert.Eventually(t, func() bool {
+		return processes["model1"].InFlightRequests() > 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// model2 only fits once model1 is idle and can be evicted
+	wg.Add(1)
+	go sendRequest("model2", "0ms")
+	<-time.After(200 * time.Millisecond)
+	assert.Equal(t, StateStopped, processes["model2"].CurrentState())
+
+	wg.Wait()
+	assert.Equal(t, StateStopped, processes["model1"].CurrentState())
+	assert.Equal(t, StateReady, processes["model2"].CurrentState())
+}
+
+func TestProxyManager_ResourceBudgetLoadsInParallel(t *testing.T) {
+	newModel := func(name string, vram float64) ModelConfig {
+		modelConfig := getTestSimpleResponderConfig(name)
+		modelConfig.Resources = map[string]float64{"vram": vram}
+		return modelConfig
+	}
+
+	// model1 takes a while to start
+	slowModel := newModel("model1", 20)
+	slowModel.Cmd = "sh -c 'sleep 2 && exec " + slowModel.Cmd + "'"
+
+	config := AddDefaultGroupToConfig(Config{
+		HealthCheckTimeout: 15,
+		Resources:          map[string]float64{"vram": 48},
+		Models: map[string]ModelConfig{
+			"model1": slowModel,
+			"model2": newModel("model2", 20),
+		},
+		LogLevel: "error",
+	})
+
+	proxy := New(config)
+	defer proxy.StopProcesses()
+
+	sendRequest := func(modelName string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(fmt.Sprintf(`{"model":"%s"}`, modelName)))
+		w := httptest.NewRecorder()
+		proxy.HandlerFunc(w, req)
+		return w
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		assert.Equal(t, http.StatusOK, sendRequest("model1").Code)
+	}()
+
+	processes := proxy.processGroups[DEFAULT_GROUP_ID].processes
+	assert.Eventually(t, func() bool {
+		return processes["model1"].CurrentState() == StateStarting
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// model2 fits so it does not wait for model1 to finish loading
+	w := sendRequest("model2")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, StateStarting, processes["model1"].CurrentState())
+
+	wg.Wait()
+	assert.Equal(t, StateReady, processes["model1"].CurrentState())
+	assert.Equal(t, StateReady, processes["model2"].CurrentState())
+}
+
+func TestProxyManager_ResourceBudgetStartFailure(t *testing.T) {
+	brokenModel := ModelConfig{
+		Cmd:           "nonexistent-command",
+		Proxy:         "http://127.0.0.1:9913",
+		CheckEndpoint: "/health",
+		Resources:     map[string]float64{"vram": 40},
+	}
+
+	config := AddDefaultGroupToConfig(Config{
+		HealthCheckTimeout: 15,
+		Resources:          map[string]float64{"vram": 48},
+		Models: map[string]ModelConfig{
+			"broken": brokenModel,
+		},
+		LogLevel: "error",
+	})
+
+	proxy := New(config)
+	defer proxy.StopProcesses()
+
+	err := proxy.placeModel(config, "broken")
+	assert.ErrorContains(t, err, "unable to start model broken")
+
+	// the reservation is released
+	proxy.placementMutex.Lock()
+	assert.Empty(t, proxy.placing)
+	proxy.placementMutex.Unlock()
+}
@@ -0,0 +1,125 @@
+package proxy
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+const DEFAULT_RESPONSE_STORE_SIZE = 1000
+
+func (c *Config) responseStoreSize() int {
+	if c.ResponseStoreSize < 1 {
+		return DEFAULT_RESPONSE_STORE_SIZE
+	}
+	return c.ResponseStoreSize
+}
+
+// responseStore keeps the conversation of each /v1/responses response in
+// memory so a later request can continue it with previous_response_id. The
+// least recently used responses are dropped when it is full.
+type responseStore struct {
+	sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // front is the most recently used
+}
+
+// storedResponse holds the messages a response added to the conversation.
+// Earlier messages are shared with the response it continues so the memory
+// used grows with the length of a conversation, not its square.
+type storedResponse struct {
+	id string
+
+	// name of the API key that created the response, empty without auth.
+	// Only the same key can continue or delete it.
+	owner string
+
+	// the response this one continues, nil for a new conversation. It is
+	// kept even after being dropped from the store.
+	previous *storedResponse
+
+	// chat completion messages of the request and the response, without
+	// the instructions
+	messages []gin.H
+}
+
+// conversation returns all the messages up to and including the response
+func (r *storedResponse) conversation() []gin.H {
+	var chain []*storedResponse
+	count := 0
+	for stored := r; stored != nil; stored = stored.previous {
+		chain = append(chain, stored)
+		count += len(stored.messages)
+	}
+
+	messages := make([]gin.H, 0, count)
+	for i := len(chain) - 1; i >= 0; i-- {
+		messages = append(messages, chain[i].messages...)
+	}
+	return messages
+}
+
+func newResponseStore() *responseStore {
+	return &responseStore{
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// get returns a response created by owner
+func (s *responseStore) get(id, owner string) (*storedResponse, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	element, ok := s.entries[id]
+	if !ok || element.Value.(*storedResponse).owner != owner {
+		return nil, false
+	}
+	s.order.MoveToFront(element)
+	return element.Value.(*storedResponse), true
+}
+
+// put stores a response, dropping the least recently used responses over
+// maxEntries
+func (s *responseStore) put(response *storedResponse, maxEntries int) {
+	s.Lock()
+	defer s.Unlock()
+
+	if element, ok := s.entries[response.id]; ok {
+		element.Value = response
+		s.order.MoveToFront(element)
+	} else {
+		s.entries[response.id] = s.order.PushFront(response)
+	}
+
+	for s.order.Len() > maxEntries {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.(*storedResponse).id)
+	}
+}
+
+// delete removes a response created by owner, it returns false if there
+// is no such response
+func (s *responseStore) delete(id, owner string) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	element, ok := s.entries[id]
+	if !ok || element.Value.(*storedResponse).owner != owner {
+		return false
+	}
+	s.order.Remove(element)
+	delete(s.entries, id)
+	return true
+}
+
+// responseOwner returns the owner of responses created by the request. It is
+// the key's id, names can be changed in the config.
+func responseOwner(c *gin.Context) string {
+	if apiKey := requestAPIKey(c); apiKey != nil {
+		return apiKey.id
+	}
+	return ""
+}
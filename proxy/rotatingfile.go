@@ -0,0 +1,228 @@
+package proxy
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// RotatingFile is an io.WriteCloser that appends to a file and rotates it
+// when it grows past maxSize bytes, or is older than maxAge. Rotated files
+// are renamed to path.1, path.2, ... with path.1 being the most recent, and
+// at most maxBackups of them are kept. When compress is set the rotated
+// files are gzipped to path.1.gz, path.2.gz, ... in the background.
+type RotatingFile struct {
+	sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	maxAge     time.Duration
+	compress   bool
+
+	// nil after Close, or when the file could not be reopened
+	file    *os.File
+	closed  bool
+	size    int64
+	created time.Time
+
+	// the background gzip of the last rotated file
+	compressing sync.WaitGroup
+}
+
+func NewRotatingFile(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	r := &RotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *RotatingFile) open() error {
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	r.file = file
+	r.size = info.Size()
+	r.created = time.Now()
+	if r.size > 0 {
+		// the creation time is not portable, the last write is close enough
+		r.created = info.ModTime()
+	}
+	return nil
+}
+
+// SetMaxAge rotates the file when it is older than maxAge, a maxAge of 0
+// only rotates by size
+func (r *RotatingFile) SetMaxAge(maxAge time.Duration) {
+	r.Lock()
+	defer r.Unlock()
+	r.maxAge = maxAge
+}
+
+// SetCompress gzips the rotated files
+func (r *RotatingFile) SetCompress(compress bool) {
+	r.Lock()
+	defer r.Unlock()
+	r.compress = compress
+}
+
+// Write writes p to the file, rotating it first if p does not fit. A single
+// write is never split across files. When the file can not be rotated p is
+// appended to it and rotating is tried again on the next write.
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.closed {
+		return 0, os.ErrClosed
+	}
+
+	if r.file != nil {
+		tooOld := r.maxAge > 0 && time.Since(r.created) > r.maxAge
+		if r.size > 0 && (r.size+int64(len(p)) > r.maxSize || tooOld) {
+			// errors are ignored, there is nowhere to log them
+			r.rotate()
+		}
+	}
+
+	if r.file == nil {
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate shifts the backups and starts a new file. If the file can not be
+// rotated it is reopened to append to it. The caller must hold the lock.
+func (r *RotatingFile) rotate() error {
+	// the handle can not be used after a failed Close, it is rotated anyway
+	closeErr := r.file.Close()
+	r.file = nil
+
+	err := r.shiftBackups()
+	if openErr := r.open(); openErr != nil {
+		return openErr
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	return err
+}
+
+// shiftBackups renames the file to the first backup, shifting the older ones
+func (r *RotatingFile) shiftBackups() error {
+	// the last rotated file must be compressed before it can be shifted
+	r.compressing.Wait()
+
+	if r.maxBackups == 0 {
+		if err := os.Remove(r.path); err != nil {
+			return fmt.Errorf("failed to rotate %s: %v", r.path, err)
+		}
+		return nil
+	}
+
+	for _, name := range r.backupNames(r.maxBackups) {
+		os.Remove(name)
+	}
+	for i := r.maxBackups - 1; i > 0; i-- {
+		// missing backups are expected until the file has rotated maxBackups times
+		for j, name := range r.backupNames(i) {
+			os.Rename(name, r.backupNames(i + 1)[j])
+		}
+	}
+
+	rotated := fmt.Sprintf("%s.1", r.path)
+	if err := os.Rename(r.path, rotated); err != nil {
+		return fmt.Errorf("failed to rotate %s: %v", r.path, err)
+	}
+
+	if r.compress {
+		r.compressing.Add(1)
+		go func() {
+			defer r.compressing.Done()
+			// the uncompressed backup is kept if it can not be compressed
+			compressFile(rotated, rotated+".gz")
+		}()
+	}
+	return nil
+}
+
+// backupNames returns the names backup n can have. A backup that could not
+// be compressed keeps its uncompressed name and is shifted along with the
+// compressed ones.
+func (r *RotatingFile) backupNames(n int) []string {
+	name := fmt.Sprintf("%s.%d", r.path, n)
+	if r.compress {
+		return []string{name, name + ".gz"}
+	}
+	return []string{name}
+}
+
+// compressFile gzips src to dst and removes src
+func compressFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(out)
+	if _, err := io.Copy(gz, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+
+	in.Close()
+	return os.Remove(src)
+}
+
+// Close closes the file and waits for a rotated file being compressed
+func (r *RotatingFile) Close() error {
+	r.Lock()
+	defer r.Unlock()
+
+	r.compressing.Wait()
+	if r.file == nil {
+		r.closed = true
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	r.closed = true
+	return err
+}
@@ -0,0 +1,201 @@
+package proxy
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRotatingFile_Rotates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "requests.jsonl")
+	r, err := NewRotatingFile(path, 10, 2)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer r.Close()
+
+	for _, line := range []string{"aaaaaaaa\n", "bbbbbbbb\n", "cccccccc\n", "dddddddd\n"} {
+		_, err := r.Write([]byte(line))
+		assert.NoError(t, err)
+	}
+
+	read := func(name string) string {
+		data, _ := os.ReadFile(name)
+		return string(data)
+	}
+	assert.Equal(t, "dddddddd\n", read(path))
+	assert.Equal(t, "cccccccc\n", read(path+".1"))
+	assert.Equal(t, "bbbbbbbb\n", read(path+".2"))
+	assert.NoFileExists(t, path+".3")
+}
+
+func TestRotatingFile_AppendsToExistingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "requests.jsonl")
+	assert.NoError(t, os.WriteFile(path, []byte("12345\n"), 0644))
+
+	r, err := NewRotatingFile(path, 10, 1)
+	if !assert.NoError(t, err) {
+		return
+	}
+	r.Write([]byte("678\n"))
+	r.Write([]byte("90\n"))
+	assert.NoError(t, r.Close())
+
+	data, _ := os.ReadFile(path)
+	assert.Equal(t, "90\n", string(data))
+	data, _ = os.ReadFile(path + ".1")
+	assert.Equal(t, "12345\n678\n", string(data))
+
+	_, err = r.Write([]byte("closed"))
+	assert.ErrorIs(t, err, os.ErrClosed)
+}
+
+func TestRotatingFile_Compress(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proxy.log")
+	r, err := NewRotatingFile(path, 10, 2)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer r.Close()
+	r.SetCompress(true)
+
+	for _, line := range []string{"aaaaaaaa\n", "bbbbbbbb\n", "cccccccc\n", "dddddddd\n"} {
+		_, err := r.Write([]byte(line))
+		assert.NoError(t, err)
+	}
+
+	// wait for the background compression
+	assert.NoError(t, r.Close())
+
+	read := func(name string) string {
+		file, err := os.Open(name)
+		if err != nil {
+			return ""
+		}
+		defer file.Close()
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return ""
+		}
+		data, _ := io.ReadAll(gz)
+		return string(data)
+	}
+	data, _ := os.ReadFile(path)
+	assert.Equal(t, "dddddddd\n", string(data))
+	assert.Equal(t, "cccccccc\n", read(path+".1.gz"))
+	assert.Equal(t, "bbbbbbbb\n", read(path+".2.gz"))
+	assert.NoFileExists(t, path+".1")
+	assert.NoFileExists(t, path+".3.gz")
+}
+
+func TestRotatingFile_KeepsBackupWhenCompressFails(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "proxy.log")
+
+	// the backups can not be compressed
+	assert.NoError(t, os.MkdirAll(filepath.Join(path+".1.gz", "busy"), 0755))
+	assert.NoError(t, os.MkdirAll(filepath.Join(path+".2.gz", "busy"), 0755))
+
+	r, err := NewRotatingFile(path, 10, 2)
+	if !assert.NoError(t, err) {
+		return
+	}
+	r.SetCompress(true)
+
+	for _, line := range []string{"aaaaaaaa\n", "bbbbbbbb\n", "cccccccc\n"} {
+		_, err := r.Write([]byte(line))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, r.Close())
+
+	// the uncompressed backups are shifted, not overwritten
+	data, _ := os.ReadFile(path)
+	assert.Equal(t, "cccccccc\n", string(data))
+	data, _ = os.ReadFile(path + ".1")
+	assert.Equal(t, "bbbbbbbb\n", string(data))
+	data, _ = os.ReadFile(path + ".2")
+	assert.Equal(t, "aaaaaaaa\n", string(data))
+}
+
+func TestRotatingFile_RotatesWhenCloseFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proxy.log")
+	r, err := NewRotatingFile(path, 10, 1)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer r.Close()
+
+	_, err = r.Write([]byte("aaaaaaaa\n"))
+	assert.NoError(t, err)
+
+	// closing the handle again when rotating fails
+	assert.NoError(t, r.file.Close())
+
+	_, err = r.Write([]byte("bbbbbbbb\n"))
+	assert.NoError(t, err)
+	_, err = r.Write([]byte("cccccccc\n"))
+	assert.NoError(t, err)
+
+	data, _ := os.ReadFile(path)
+	assert.Equal(t, "cccccccc\n", string(data))
+	data, _ = os.ReadFile(path + ".1")
+	assert.Equal(t, "bbbbbbbb\n", string(data))
+}
+
+func TestRotatingFile_KeepsWritingWhenRotateFails(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "proxy.log")
+
+	// the first backup can not be replaced
+	assert.NoError(t, os.MkdirAll(filepath.Join(path+".1", "busy"), 0755))
+
+	r, err := NewRotatingFile(path, 10, 1)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer r.Close()
+
+	for _, line := range []string{"aaaaaaaa\n", "bbbbbbbb\n"} {
+		_, err := r.Write([]byte(line))
+		assert.NoError(t, err)
+	}
+
+	data, _ := os.ReadFile(path)
+	assert.Equal(t, "aaaaaaaa\nbbbbbbbb\n", string(data))
+
+	// rotating works again once the backup can be replaced
+	assert.NoError(t, os.RemoveAll(path+".1"))
+	_, err = r.Write([]byte("cccccccc\n"))
+	assert.NoError(t, err)
+	data, _ = os.ReadFile(path)
+	assert.Equal(t, "cccccccc\n", string(data))
+	data, _ = os.ReadFile(path + ".1")
+	assert.Equal(t, "aaaaaaaa\nbbbbbbbb\n", string(data))
+}
+
+func TestRotatingFile_MaxAge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proxy.log")
+	assert.NoError(t, os.WriteFile(path, []byte("old\n"), 0644))
+	yesterday := time.Now().Add(-24 * time.Hour)
+	assert.NoError(t, os.Chtimes(path, yesterday, yesterday))
+
+	r, err := NewRotatingFile(path, 1024, 1)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer r.Close()
+	r.SetMaxAge(time.Hour)
+
+	r.Write([]byte("new\n"))
+	r.Write([]byte("newer\n"))
+
+	data, _ := os.ReadFile(path)
+	assert.Equal(t, "new\nnewer\n", string(data))
+	data, _ = os.ReadFile(path + ".1")
+	assert.Equal(t, "old\n", string(data))
+}
@@ -0,0 +1,233 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const UNIX_SOCKET_PREFIX = "unix://"
+
+// Listener is an address to serve on:
+//
+//	host:port          HTTPS when a certificate is configured, otherwise HTTP
+//	http://host:port   always HTTP
+//	https://host:port  HTTPS, a certificate is required
+//	unix:///path.sock  HTTP over a unix domain socket
+type Listener struct {
+	network string
+	address string
+	tls     bool
+}
+
+// ParseListener parses a listen address. useTLS is true when a certificate
+// is configured.
+func ParseListener(addr string, useTLS bool) (Listener, error) {
+	switch {
+	case strings.HasPrefix(addr, UNIX_SOCKET_PREFIX):
+		path := strings.TrimPrefix(addr, UNIX_SOCKET_PREFIX)
+		if path == "" {
+			return Listener{}, fmt.Errorf("listen %s: missing socket path", addr)
+		}
+		return Listener{network: "unix", address: path}, nil
+	case strings.HasPrefix(addr, "http://"):
+		return Listener{network: "tcp", address: strings.TrimPrefix(addr, "http://")}, nil
+	case strings.HasPrefix(addr, "https://"):
+		if !useTLS {
+			return Listener{}, fmt.Errorf("listen %s: a TLS certificate and key are required", addr)
+		}
+		return Listener{network: "tcp", address: strings.TrimPrefix(addr, "https://"), tls: true}, nil
+	case strings.Contains(addr, "://"):
+		return Listener{}, fmt.Errorf("listen %s: unsupported scheme, use http://, https:// or unix://", addr)
+	default:
+		return Listener{network: "tcp", address: addr, tls: useTLS}, nil
+	}
+}
+
+func (l Listener) String() string {
+	switch {
+	case l.network == "unix":
+		return UNIX_SOCKET_PREFIX + l.address
+	case l.tls:
+		return "https://" + l.address
+	default:
+		return "http://" + l.address
+	}
+}
+
+// listen opens the listener. A stale unix socket left behind by a previous
+// run is removed first.
+func (l Listener) listen() (net.Listener, error) {
+	if l.network == "unix" {
+		if info, err := os.Stat(l.address); err == nil && info.Mode()&os.ModeSocket != 0 {
+			if conn, err := net.Dial("unix", l.address); err == nil {
+				conn.Close()
+				return nil, fmt.Errorf("listen %s: socket is in use", l)
+			}
+			os.Remove(l.address)
+		}
+	}
+	return net.Listen(l.network, l.address)
+}
+
+// certificateReloader serves a certificate that can be reloaded from disk
+// without restarting the listeners
+type certificateReloader struct {
+	sync.RWMutex
+	certFile string
+	keyFile  string
+	cert     *tls.Certificate
+}
+
+func newCertificateReloader(certFile, keyFile string) (*certificateReloader, error) {
+	r := &certificateReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reload loads the certificate, the current one is kept if it fails
+func (r *certificateReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %v", err)
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	r.cert = &cert
+	return nil
+}
+
+func (r *certificateReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.RLock()
+	defer r.RUnlock()
+	return r.cert, nil
+}
+
+// Serve serves on all listeners until they are closed. certFile and keyFile
+// may be empty when no listener uses TLS. It returns the first error from a
+// listener.
+func (pm *ProxyManager) Serve(listeners []Listener, certFile, keyFile string) error {
+	var tlsConfig *tls.Config
+	for _, l := range listeners {
+		if l.tls && tlsConfig == nil {
+			certs, err := newCertificateReloader(certFile, keyFile)
+			if err != nil {
+				return err
+			}
+			pm.serverMutex.Lock()
+			pm.certificates = certs
+			pm.serverMutex.Unlock()
+			tlsConfig = &tls.Config{GetCertificate: certs.getCertificate}
+		}
+	}
+
+	netListeners := make([]net.Listener, 0, len(listeners))
+	for _, l := range listeners {
+		netListener, err := l.listen()
+		if err != nil {
+			for _, opened := range netListeners {
+				opened.Close()
+			}
+			return err
+		}
+		if l.tls {
+			netListener = tls.NewListener(netListener, tlsConfig)
+		}
+		netListeners = append(netListeners, netListener)
+	}
+
+	errChan := make(chan error, len(listeners))
+	for i, netListener := range netListeners {
+		server := &http.Server{Handler: pm.ginEngine}
+		pm.serverMutex.Lock()
+		pm.servers = append(pm.servers, server)
+		pm.serverMutex.Unlock()
+
+		pm.proxyLogger.Infof("Listening on %s", listeners[i])
+		go func(server *http.Server, netListener net.Listener) {
+			err := server.Serve(netListener)
+			if errors.Is(err, http.ErrServerClosed) {
+				err = nil
+			}
+			errChan <- err
+		}(server, netListener)
+	}
+
+	var firstErr error
+	for range netListeners {
+		if err := <-errChan; err != nil && firstErr == nil {
+			firstErr = err
+			// stop the other listeners
+			pm.closeServers()
+		}
+	}
+	return firstErr
+}
+
+// closeServers immediately closes all listeners and connections
+func (pm *ProxyManager) closeServers() {
+	pm.serverMutex.Lock()
+	defer pm.serverMutex.Unlock()
+	for _, server := range pm.servers {
+		server.Close()
+	}
+}
+
+// GracefulShutdown stops accepting new requests and waits up to drainTimeout
+// for in-flight requests, including streamed responses, to finish. Requests
+// still running after drainTimeout are cut off. The upstreams are shut down
+// once the requests are done.
+func (pm *ProxyManager) GracefulShutdown(drainTimeout time.Duration) {
+	pm.drainCancel()
+
+	pm.serverMutex.Lock()
+	servers := pm.servers
+	pm.serverMutex.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, server := range servers {
+		wg.Add(1)
+		go func(server *http.Server) {
+			defer wg.Done()
+			if err := server.Shutdown(ctx); err != nil {
+				pm.proxyLogger.Warnf("Requests did not finish within %v, closing connections", drainTimeout)
+				server.Close()
+			}
+		}(server)
+	}
+	wg.Wait()
+
+	pm.Shutdown()
+}
+
+// ReloadCertificates reloads the TLS certificate and key from disk. It is a
+// no-op when TLS is not used.
+func (pm *ProxyManager) ReloadCertificates() error {
+	pm.serverMutex.Lock()
+	certs := pm.certificates
+	pm.serverMutex.Unlock()
+
+	if certs == nil {
+		return nil
+	}
+
+	if err := certs.reload(); err != nil {
+		pm.proxyLogger.Errorf("Keeping the current TLS certificate: %v", err)
+		return err
+	}
+	pm.proxyLogger.Info("Reloaded TLS certificate")
+	return nil
+}
@@ -0,0 +1,272 @@
+package proxy
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_ParseListener(t *testing.T) {
+	tests := []struct {
+		addr    string
+		useTLS  bool
+		want    Listener
+		wantErr string
+	}{
+		{":8080", false, Listener{network: "tcp", address: ":8080"}, ""},
+		{":8443", true, Listener{network: "tcp", address: ":8443", tls: true}, ""},
+		{"http://127.0.0.1:8080", true, Listener{network: "tcp", address: "127.0.0.1:8080"}, ""},
+		{"https://:8443", true, Listener{network: "tcp", address: ":8443", tls: true}, ""},
+		{"unix:///run/llama-swap.sock", true, Listener{network: "unix", address: "/run/llama-swap.sock"}, ""},
+		{"https://:8443", false, Listener{}, "a TLS certificate and key are required"},
+		{"unix://", false, Listener{}, "missing socket path"},
+		{"ftp://:21", false, Listener{}, "unsupported scheme"},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseListener(tt.addr, tt.useTLS)
+		if tt.wantErr != "" {
+			assert.ErrorContains(t, err, tt.wantErr, tt.addr)
+			continue
+		}
+		assert.NoError(t, err, tt.addr)
+		assert.Equal(t, tt.want, got, tt.addr)
+	}
+}
+
+// writeTestCertificate writes a self-signed certificate for 127.0.0.1
+func writeTestCertificate(t *testing.T, dir string, serial int64) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "llama-swap test"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	return certFile, keyFile
+}
+
+// freePort returns a port that is free to listen on
+func freePort(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func TestServer_ServeMultipleListeners(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCertificate(t, dir, 1)
+	socketPath := filepath.Join(dir, "llama-swap.sock")
+	tlsAddr := freePort(t)
+
+	listeners := []Listener{}
+	for _, addr := range []string{tlsAddr, "unix://" + socketPath} {
+		l, err := ParseListener(addr, true)
+		if !assert.NoError(t, err) {
+			return
+		}
+		listeners = append(listeners, l)
+	}
+
+	proxy := New(Config{LogLevel: "error"})
+	defer proxy.StopProcesses()
+
+	served := make(chan error, 1)
+	go func() { served <- proxy.Serve(listeners, certFile, keyFile) }()
+
+	tlsClient := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+	unixClient := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+		},
+	}}
+
+	get := func(client *http.Client, url string) (*http.Response, error) {
+		var resp *http.Response
+		var err error
+		// wait for the listeners to start
+		for i := 0; i < 50; i++ {
+			if resp, err = client.Get(url); err == nil {
+				break
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		return resp, err
+	}
+
+	resp, err := get(tlsClient, "https://"+tlsAddr+"/v1/models")
+	if assert.NoError(t, err) {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, big.NewInt(1), resp.TLS.PeerCertificates[0].SerialNumber)
+	}
+
+	resp, err = get(unixClient, "http://llama-swap/v1/models")
+	if assert.NoError(t, err) {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	// new connections get the reloaded certificate
+	writeTestCertificate(t, dir, 2)
+	assert.NoError(t, proxy.ReloadCertificates())
+	tlsClient.CloseIdleConnections()
+	resp, err = get(tlsClient, "https://"+tlsAddr+"/v1/models")
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, big.NewInt(2), resp.TLS.PeerCertificates[0].SerialNumber)
+	}
+
+	proxy.closeServers()
+	select {
+	case err := <-served:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not return after the servers were closed")
+	}
+}
+
+func TestServer_GracefulShutdownDrainsRequests(t *testing.T) {
+	config := AddDefaultGroupToConfig(Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]ModelConfig{
+			"model1": getTestSimpleResponderConfig("model1"),
+		},
+		LogLevel: "error",
+	})
+
+	addr := freePort(t)
+	listener, _ := ParseListener(addr, false)
+
+	proxy := New(config)
+	go proxy.Serve([]Listener{listener}, "", "")
+
+	// a streamed response that takes a while to finish
+	var resp *http.Response
+	var err error
+	for i := 0; i < 50; i++ {
+		if resp, err = http.Get("http://" + addr + "/upstream/model1/slow-respond?echo=abcde&delay=200ms"); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+
+	// the headers of a log stream are not sent until there is a log line
+	logStreamDone := make(chan struct{})
+	go func() {
+		defer close(logStreamDone)
+		if logStream, err := http.Get("http://" + addr + "/logs/stream?no-history"); err == nil {
+			io.Copy(io.Discard, logStream.Body)
+			logStream.Body.Close()
+		}
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	done := make(chan struct{})
+	go func() {
+		proxy.GracefulShutdown(10 * time.Second)
+		close(done)
+	}()
+
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "abcde", string(body))
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("GracefulShutdown did not return")
+	}
+
+	// log streams end instead of holding up the shutdown
+	select {
+	case <-logStreamDone:
+	case <-time.After(time.Second):
+		t.Fatal("log stream did not end")
+	}
+
+	_, err = http.Get("http://" + addr + "/v1/models")
+	assert.Error(t, err, "new connections are refused")
+	assert.Equal(t, StateShutdown, proxy.processGroups[DEFAULT_GROUP_ID].processes["model1"].CurrentState())
+}
+
+func TestServer_GracefulShutdownTimeout(t *testing.T) {
+	config := AddDefaultGroupToConfig(Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]ModelConfig{
+			"model1": getTestSimpleResponderConfig("model1"),
+		},
+		LogLevel: "error",
+	})
+
+	addr := freePort(t)
+	listener, _ := ParseListener(addr, false)
+
+	proxy := New(config)
+	go proxy.Serve([]Listener{listener}, "", "")
+
+	var resp *http.Response
+	var err error
+	for i := 0; i < 50; i++ {
+		if resp, err = http.Get("http://" + addr + "/upstream/model1/slow-respond?echo=abcdefghij&delay=1s"); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+
+	start := time.Now()
+	proxy.GracefulShutdown(500 * time.Millisecond)
+	assert.Less(t, time.Since(start), 8*time.Second)
+
+	// the response was cut off
+	body, _ := io.ReadAll(resp.Body)
+	assert.NotEqual(t, "abcdefghij", string(body))
+}
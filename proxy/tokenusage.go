@@ -0,0 +1,196 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// non-streaming responses larger than this are not parsed for usage
+const maxUsageBodySize = 8 * 1024 * 1024
+
+// TokenUsage is the token usage and throughput of a single request. It is
+// parsed from the usage and timings objects returned by the upstream.
+type TokenUsage struct {
+	PromptTokens     int `json:"promptTokens"`
+	CompletionTokens int `json:"completionTokens"`
+
+	// llama-server timings, zero when the upstream does not report them
+	PromptMs        float64 `json:"promptMs"`
+	PredictedMs     float64 `json:"predictedMs"`
+	PromptPerSecond float64 `json:"promptPerSecond"`
+	TokensPerSecond float64 `json:"tokensPerSecond"`
+}
+
+// usageObserver is written a copy of the upstream response and pulls out the
+// token usage. For SSE streams the last usage and timings seen win, llama-server
+// sends them in the final chunk.
+type usageObserver struct {
+	streaming bool
+	buf       []byte
+	overflow  bool
+	found     bool
+	usage     TokenUsage
+}
+
+// newUsageObserver returns nil if the response can not contain usage
+func newUsageObserver(contentType string) *usageObserver {
+	switch {
+	case strings.Contains(contentType, "text/event-stream"):
+		return &usageObserver{streaming: true}
+	case strings.Contains(contentType, "json"):
+		return &usageObserver{}
+	default:
+		return nil
+	}
+}
+
+func (o *usageObserver) Write(p []byte) (int, error) {
+	if o.overflow {
+		return len(p), nil
+	}
+
+	o.buf = append(o.buf, p...)
+	if o.streaming {
+		for {
+			i := bytes.IndexByte(o.buf, '\n')
+			if i < 0 {
+				break
+			}
+			o.parseLine(o.buf[:i])
+			o.buf = o.buf[i+1:]
+		}
+	}
+
+	if len(o.buf) > maxUsageBodySize {
+		o.overflow = true
+		o.buf = nil
+	}
+	return len(p), nil
+}
+
+func (o *usageObserver) parseLine(line []byte) {
+	line = bytes.TrimSpace(line)
+	data, ok := bytes.CutPrefix(line, []byte("data:"))
+	if !ok {
+		return
+	}
+
+	// skip parsing chunks that can not have usage
+	if !bytes.Contains(data, []byte(`"usage"`)) && !bytes.Contains(data, []byte(`"timings"`)) {
+		return
+	}
+	o.parse(bytes.TrimSpace(data))
+}
+
+func (o *usageObserver) parse(data []byte) {
+	if !gjson.ValidBytes(data) {
+		return
+	}
+
+	// OpenAI compatible servers send "usage": null in streamed chunks
+	if usage := gjson.GetBytes(data, "usage"); usage.IsObject() {
+		o.found = true
+		o.usage.PromptTokens = int(usage.Get("prompt_tokens").Int())
+		o.usage.CompletionTokens = int(usage.Get("completion_tokens").Int())
+	}
+
+	if timings := gjson.GetBytes(data, "timings"); timings.IsObject() {
+		if !o.found {
+			o.usage.PromptTokens = int(timings.Get("prompt_n").Int())
+			o.usage.CompletionTokens = int(timings.Get("predicted_n").Int())
+		}
+		o.found = true
+		o.usage.PromptMs = timings.Get("prompt_ms").Float()
+		o.usage.PredictedMs = timings.Get("predicted_ms").Float()
+		o.usage.PromptPerSecond = timings.Get("prompt_per_second").Float()
+		o.usage.TokensPerSecond = timings.Get("predicted_per_second").Float()
+	}
+}
+
+// finish returns the usage once the whole response has been written
+func (o *usageObserver) finish() (TokenUsage, bool) {
+	if !o.streaming && !o.overflow {
+		o.parse(o.buf)
+	} else if o.streaming && len(o.buf) > 0 {
+		o.parseLine(o.buf)
+	}
+	o.buf = nil
+	return o.usage, o.found
+}
+
+// UsageTotals aggregates the token usage of requests to a model
+type UsageTotals struct {
+	Requests         int     `json:"requests"`
+	PromptTokens     int64   `json:"promptTokens"`
+	CompletionTokens int64   `json:"completionTokens"`
+	PromptPerSecond  float64 `json:"promptPerSecond"`
+	TokensPerSecond  float64 `json:"tokensPerSecond"`
+
+	// only requests with timings count towards the throughput
+	timedPromptTokens    int64
+	promptMs             float64
+	timedPredictedTokens int64
+	predictedMs          float64
+}
+
+func (t *UsageTotals) add(u TokenUsage) {
+	t.Requests++
+	t.PromptTokens += int64(u.PromptTokens)
+	t.CompletionTokens += int64(u.CompletionTokens)
+
+	if u.PromptMs > 0 {
+		t.timedPromptTokens += int64(u.PromptTokens)
+		t.promptMs += u.PromptMs
+		t.PromptPerSecond = float64(t.timedPromptTokens) / (t.promptMs / 1000)
+	}
+
+	if u.PredictedMs > 0 {
+		t.timedPredictedTokens += int64(u.CompletionTokens)
+		t.predictedMs += u.PredictedMs
+		t.TokensPerSecond = float64(t.timedPredictedTokens) / (t.predictedMs / 1000)
+	}
+}
+
+// modelUsage holds the usage totals of a process
+type modelUsage struct {
+	sync.Mutex
+	totals UsageTotals
+}
+
+func (m *modelUsage) add(u TokenUsage) {
+	m.Lock()
+	defer m.Unlock()
+	m.totals.add(u)
+}
+
+func (m *modelUsage) get() UsageTotals {
+	m.Lock()
+	defer m.Unlock()
+	return m.totals
+}
+
+// requestStats carries the usage and start time of a request from
+// Process.ProxyRequest back to the access log middleware through the
+// request context
+type requestStats struct {
+	found         bool
+	usage         TokenUsage
+	startDuration time.Duration
+}
+
+type requestStatsKey struct{}
+
+func withRequestStats(ctx context.Context) (context.Context, *requestStats) {
+	rs := &requestStats{}
+	return context.WithValue(ctx, requestStatsKey{}, rs), rs
+}
+
+func requestStatsFromContext(ctx context.Context) *requestStats {
+	rs, _ := ctx.Value(requestStatsKey{}).(*requestStats)
+	return rs
+}
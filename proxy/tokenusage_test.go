@@ -0,0 +1,68 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUsageObserver_JSON(t *testing.T) {
+	o := newUsageObserver("application/json; charset=utf-8")
+	o.Write([]byte(`{"choices":[],"usage":{"prompt_tokens":12,`))
+	o.Write([]byte(`"completion_tokens":34},"timings":{"prompt_n":12,"prompt_ms":10,"prompt_per_second":1200,"predicted_n":34,"predicted_ms":340,"predicted_per_second":100}}`))
+
+	usage, found := o.finish()
+	assert.True(t, found)
+	assert.Equal(t, TokenUsage{
+		PromptTokens:     12,
+		CompletionTokens: 34,
+		PromptMs:         10,
+		PredictedMs:      340,
+		PromptPerSecond:  1200,
+		TokensPerSecond:  100,
+	}, usage)
+}
+
+func TestUsageObserver_SSE(t *testing.T) {
+	o := newUsageObserver("text/event-stream")
+
+	// chunks are split in the middle of lines and usage is null until the end
+	o.Write([]byte("data: {\"choices\":[{\"delta\":{\"content\":\"hi\"}}],\"usage\":null}\n\nda"))
+	o.Write([]byte("ta: {\"choices\":[],\"usage\":{\"prompt_tokens\":5,\"completion_tokens\":7},"))
+	o.Write([]byte("\"timings\":{\"predicted_ms\":70,\"predicted_per_second\":100}}\n\ndata: [DONE]\n\n"))
+
+	usage, found := o.finish()
+	assert.True(t, found)
+	assert.Equal(t, 5, usage.PromptTokens)
+	assert.Equal(t, 7, usage.CompletionTokens)
+	assert.Equal(t, 100.0, usage.TokensPerSecond)
+}
+
+func TestUsageObserver_NoUsage(t *testing.T) {
+	assert.Nil(t, newUsageObserver("text/plain"))
+
+	o := newUsageObserver("text/event-stream")
+	o.Write([]byte("data: {\"choices\":[],\"usage\":null}\n\ndata: [DONE]\n\n"))
+	_, found := o.finish()
+	assert.False(t, found)
+
+	o = newUsageObserver("application/json")
+	o.Write([]byte(`{"object":"list","data":[]}`))
+	_, found = o.finish()
+	assert.False(t, found)
+}
+
+func TestUsageTotals_Add(t *testing.T) {
+	var totals UsageTotals
+	totals.add(TokenUsage{PromptTokens: 100, CompletionTokens: 10, PromptMs: 100, PredictedMs: 500})
+	totals.add(TokenUsage{PromptTokens: 300, CompletionTokens: 30, PromptMs: 100, PredictedMs: 500})
+
+	// without timings it only counts the tokens
+	totals.add(TokenUsage{PromptTokens: 1, CompletionTokens: 1})
+
+	assert.Equal(t, 3, totals.Requests)
+	assert.Equal(t, int64(401), totals.PromptTokens)
+	assert.Equal(t, int64(41), totals.CompletionTokens)
+	assert.Equal(t, 2000.0, totals.PromptPerSecond)
+	assert.Equal(t, 40.0, totals.TokensPerSecond)
+}
@@ -0,0 +1,228 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// responseTranslator converts OpenAI chat completion responses into another
+// API's response format
+type responseTranslator interface {
+	// contentType of the translated response
+	contentType(streaming bool) string
+
+	// streamChunk is called with the data of each SSE event in a streamed response
+	streamChunk(w io.Writer, data []byte) error
+
+	// streamEnd is called once a streamed response is complete
+	streamEnd(w io.Writer) error
+
+	// response is called with the body of a response that was not streamed
+	response(w io.Writer, body []byte) error
+}
+
+// errorTranslator is implemented by translators for APIs with their own
+// error format
+type errorTranslator interface {
+	// errorResponse is called with the status code and body of an error
+	// response, from the upstream or llama-swap itself
+	errorResponse(w io.Writer, statusCode int, body []byte) error
+}
+
+// routeErrorTranslators translate the errors sent by middleware, before the
+// handler runs, for routes of APIs with their own error format
+var routeErrorTranslators = map[string]errorTranslator{
+	"/v1/messages": &anthropicTranslator{},
+}
+
+// translateRouteError writes an OpenAI style error body in the error format of
+// the request's route. It returns false, without writing anything, when the
+// route uses the OpenAI format.
+func translateRouteError(c *gin.Context, statusCode int, body gin.H) bool {
+	translator, ok := routeErrorTranslators[c.FullPath()]
+	if !ok {
+		return false
+	}
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return false
+	}
+	c.Header("Content-Type", "application/json; charset=utf-8")
+	c.Status(statusCode)
+	translator.errorResponse(c.Writer, statusCode, data)
+	return true
+}
+
+// translatingWriter sits between Process.ProxyRequest and the client and
+// translates successful OpenAI responses as they are written. Error responses
+// are translated when the translator is an errorTranslator and passed through
+// unchanged otherwise.
+type translatingWriter struct {
+	gin.ResponseWriter
+	translator responseTranslator
+
+	// upstream headers are kept separate, Content-Length and Content-Type do
+	// not match the translated response
+	header http.Header
+
+	wroteHeader bool
+	passthrough bool
+	streaming   bool
+	errorStatus int // status code of an error response being translated
+	buf         []byte
+	err         error
+}
+
+func newTranslatingWriter(w gin.ResponseWriter, translator responseTranslator) *translatingWriter {
+	return &translatingWriter{
+		ResponseWriter: w,
+		translator:     translator,
+		header:         make(http.Header),
+	}
+}
+
+func (w *translatingWriter) Header() http.Header {
+	if w.passthrough {
+		return w.ResponseWriter.Header()
+	}
+	return w.header
+}
+
+func (w *translatingWriter) WriteHeader(statusCode int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+
+	out := w.ResponseWriter.Header()
+	if _, ok := w.translator.(errorTranslator); ok && statusCode >= http.StatusBadRequest {
+		// keep headers like Retry-After
+		for k, vv := range w.header {
+			out[k] = vv
+		}
+		out.Del("Content-Length")
+		out.Set("Content-Type", "application/json")
+		w.errorStatus = statusCode
+		w.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+
+	if statusCode != http.StatusOK {
+		w.passthrough = true
+		for k, vv := range w.header {
+			out[k] = vv
+		}
+		w.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+
+	w.streaming = strings.Contains(w.header.Get("Content-Type"), "text/event-stream")
+	out.Set("Content-Type", w.translator.contentType(w.streaming))
+	if w.streaming {
+		out.Set("Cache-Control", "no-cache")
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *translatingWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if w.passthrough {
+		return w.ResponseWriter.Write(p)
+	}
+
+	w.buf = append(w.buf, p...)
+	if w.streaming {
+		for w.err == nil {
+			i := bytes.IndexByte(w.buf, '\n')
+			if i < 0 {
+				break
+			}
+			w.handleLine(w.buf[:i])
+			w.buf = w.buf[i+1:]
+		}
+	}
+
+	// the upstream response is consumed even if the client went away
+	return len(p), nil
+}
+
+func (w *translatingWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *translatingWriter) handleLine(line []byte) {
+	data, ok := bytes.CutPrefix(bytes.TrimSpace(line), []byte("data:"))
+	if !ok {
+		return
+	}
+
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || bytes.Equal(data, []byte("[DONE]")) {
+		return
+	}
+	w.err = w.translator.streamChunk(w.ResponseWriter, data)
+}
+
+// finish translates what is left of the response. It must be called after
+// the request has been proxied.
+func (w *translatingWriter) finish() error {
+	if !w.wroteHeader || w.passthrough || w.err != nil {
+		return w.err
+	}
+
+	if w.errorStatus != 0 {
+		return w.translator.(errorTranslator).errorResponse(w.ResponseWriter, w.errorStatus, w.buf)
+	}
+
+	if w.streaming {
+		if len(w.buf) > 0 {
+			w.handleLine(w.buf)
+		}
+		if w.err == nil {
+			w.err = w.translator.streamEnd(w.ResponseWriter)
+		}
+		w.ResponseWriter.Flush()
+		return w.err
+	}
+
+	return w.translator.response(w.ResponseWriter, w.buf)
+}
+
+// proxyTranslatedRequest sends an OpenAI request built from another API's
+// request through proxyOAIHandler, so it gets the same alias resolution,
+// swapping and request rewriting, and translates the response
+func (pm *ProxyManager) proxyTranslatedRequest(c *gin.Context, path string, body []byte, translator responseTranslator) {
+	c.Request.URL.Path = path
+	c.Request.URL.RawPath = ""
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	c.Request.ContentLength = int64(len(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+
+	original := c.Writer
+	tw := newTranslatingWriter(c.Writer, translator)
+	c.Writer = tw
+	pm.proxyOAIHandler(c)
+	c.Writer = original
+
+	if err := tw.finish(); err != nil {
+		pm.proxyLogger.Errorf("Error translating response for %s: %v", c.FullPath(), err)
+	}
+}
+
+// newResponseID returns a random ID for a translated response
+func newResponseID(prefix string) string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return prefix + hex.EncodeToString(b)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mostlygeek/llama-swap/proxy"
+)
+
+// runReplay re-sends the requests recorded in a request log file to a running
+// llama-swap instance. Requests are sent in the order they were recorded.
+func runReplay(args []string) int {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	target := flags.String("url", "http://localhost:8080", "base URL of the llama-swap instance")
+	concurrency := flags.Int("concurrency", 1, "number of requests to send at once")
+	apiKey := flags.String("api-key", os.Getenv("LLAMA_SWAP_API_KEY"), "API key sent with each request, defaults to $LLAMA_SWAP_API_KEY")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: llama-swap replay [flags] <requests.jsonl>\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		fmt.Printf("Error opening request log: %v\n", err)
+		return 1
+	}
+	defer file.Close()
+
+	if *concurrency < 1 {
+		*concurrency = 1
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		sent     int
+		skipped  int
+		failed   int
+		elapsed  time.Duration
+		slots    = make(chan struct{}, *concurrency)
+		baseURL  = strings.TrimSuffix(*target, "/")
+		client   = &http.Client{}
+		scanner  = bufio.NewScanner(file)
+		lineNum  int
+		replayed = time.Now()
+	)
+
+	// bodies can be large
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+
+	for scanner.Scan() {
+		lineNum++
+		var entry proxy.RequestLogEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			fmt.Printf("line %d: skipped, invalid JSON: %v\n", lineNum, err)
+			skipped++
+			continue
+		}
+
+		// the request can only be replayed if its whole body was recorded
+		if entry.Method != http.MethodGet && (entry.RequestBody == "" || entry.RequestBodyTruncated) {
+			skipped++
+			continue
+		}
+
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(lineNum int, entry proxy.RequestLogEntry) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			status, duration, err := replayRequest(client, baseURL, *apiKey, entry)
+
+			mu.Lock()
+			defer mu.Unlock()
+			sent++
+			elapsed += duration
+			if err != nil {
+				failed++
+				fmt.Printf("line %d: %s %s model=%s error: %v\n", lineNum, entry.Method, entry.Endpoint, entry.RequestedModel, err)
+				return
+			}
+			if status != entry.Status {
+				failed++
+			}
+			fmt.Printf("line %d: %s %s model=%s status=%d->%d time=%.0fms->%.0fms\n",
+				lineNum, entry.Method, entry.Endpoint, entry.RequestedModel,
+				entry.Status, status, entry.TotalMs, float64(duration.Microseconds())/1000)
+		}(lineNum, entry)
+	}
+	wg.Wait()
+
+	if err := scanner.Err(); err != nil {
+		fmt.Printf("Error reading request log: %v\n", err)
+		return 1
+	}
+
+	var avg time.Duration
+	if sent > 0 {
+		avg = elapsed / time.Duration(sent)
+	}
+	fmt.Printf("replayed %d requests in %v, %d skipped, %d failed or changed status, average time %v\n",
+		sent, time.Since(replayed).Round(time.Millisecond), skipped, failed, avg.Round(time.Millisecond))
+
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+func replayRequest(client *http.Client, baseURL string, apiKey string, entry proxy.RequestLogEntry) (int, time.Duration, error) {
+	req, err := http.NewRequest(entry.Method, baseURL+entry.Endpoint, bytes.NewBufferString(entry.RequestBody))
+	if err != nil {
+		return 0, 0, err
+	}
+	if entry.RequestContentType != "" {
+		req.Header.Set("Content-Type", entry.RequestContentType)
+	}
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, time.Since(start), err
+	}
+	defer resp.Body.Close()
+
+	// wait for the whole response, streamed responses included
+	_, err = io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode, time.Since(start), err
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/mostlygeek/llama-swap/proxy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplay_SendsRecordedRequests(t *testing.T) {
+	type received struct {
+		method, path, body, contentType, auth string
+	}
+
+	var (
+		mu       sync.Mutex
+		requests []received
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		requests = append(requests, received{
+			method:      r.Method,
+			path:        r.URL.Path,
+			body:        string(body),
+			contentType: r.Header.Get("Content-Type"),
+			auth:        r.Header.Get("Authorization"),
+		})
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	entries := []proxy.RequestLogEntry{
+		{
+			Method:             http.MethodPost,
+			Endpoint:           "/v1/chat/completions",
+			RequestedModel:     "model1",
+			Status:             http.StatusOK,
+			RequestContentType: "application/json",
+			RequestBody:        `{"model":"model1","messages":[]}`,
+		},
+		{
+			// not replayed, the body was not recorded in full
+			Method:               http.MethodPost,
+			Endpoint:             "/v1/completions",
+			Status:               http.StatusOK,
+			RequestBody:          `{"model":"mod`,
+			RequestBodyTruncated: true,
+		},
+		{
+			Method:   http.MethodGet,
+			Endpoint: "/v1/models",
+			Status:   http.StatusOK,
+		},
+	}
+
+	logPath := filepath.Join(t.TempDir(), "requests.jsonl")
+	file, err := os.Create(logPath)
+	require.NoError(t, err)
+	encoder := json.NewEncoder(file)
+	for _, entry := range entries {
+		require.NoError(t, encoder.Encode(entry))
+	}
+	require.NoError(t, file.Close())
+
+	code := runReplay([]string{"--url", srv.URL + "/", "--api-key", "secret", logPath})
+	assert.Equal(t, 0, code)
+
+	// sent one at a time, in the order they were recorded
+	require.Len(t, requests, 2)
+	assert.Equal(t, received{
+		method:      http.MethodPost,
+		path:        "/v1/chat/completions",
+		body:        `{"model":"model1","messages":[]}`,
+		contentType: "application/json",
+		auth:        "Bearer secret",
+	}, requests[0])
+	assert.Equal(t, received{
+		method: http.MethodGet,
+		path:   "/v1/models",
+		auth:   "Bearer secret",
+	}, requests[1])
+}